@@ -27,6 +27,12 @@ func Int64Flag(cmd *cobra.Command, name, description string, value int64) {
 	viper.BindPFlag(name, cmd.Flags().Lookup(name)) // nolint: errcheck, gas
 }
 
+// StringSliceFlag initializes a comma separated string slice flag
+func StringSliceFlag(cmd *cobra.Command, name, description string, value []string) {
+	cmd.Flags().StringSlice(name, value, description)
+	viper.BindPFlag(name, cmd.Flags().Lookup(name)) // nolint: errcheck, gas
+}
+
 // FlagChecker defines the function used to validate flags
 type FlagChecker func() error
 
@@ -55,3 +61,59 @@ func RequireString(flag string) FlagChecker {
 		return nil
 	}
 }
+
+// RequireInt64 returns an error if the given setting is zero
+func RequireInt64(flag string) FlagChecker {
+	return func() error {
+		if viper.GetInt64(flag) == 0 {
+			return fmt.Errorf("flag %s can not be zero", flag)
+		}
+		return nil
+	}
+}
+
+// RequireStringSlice returns an error if the given setting contains no values
+func RequireStringSlice(flag string) FlagChecker {
+	return func() error {
+		if len(GetStringSlice(flag)) == 0 {
+			return fmt.Errorf("flag %s requires at least one value", flag)
+		}
+		return nil
+	}
+}
+
+// GetStringSlice returns the trimmed, non-empty values of a string slice flag
+func GetStringSlice(flag string) []string {
+	var result []string
+	for _, v := range viper.GetStringSlice(flag) {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// RequireAny returns an error if none of the given checkers pass
+func RequireAny(checkers ...FlagChecker) FlagChecker {
+	return func() error {
+		var fails []string
+		for _, checker := range checkers {
+			err := checker()
+			if err == nil {
+				return nil
+			}
+			fails = append(fails, err.Error())
+		}
+		return fmt.Errorf("one of the following is required: %s", strings.Join(fails, ", "))
+	}
+}
+
+// RequireWhen runs the given checkers if the string flag is set to the value
+func RequireWhen(flag, value string, checkers ...FlagChecker) FlagChecker {
+	return func() error {
+		if viper.GetString(flag) != value {
+			return nil
+		}
+		return CheckFlags(checkers...)
+	}
+}
@@ -12,15 +12,19 @@ import (
 	"github.com/kolonialno/pr-deployment-controller/cmd/internal"
 	"github.com/kolonialno/pr-deployment-controller/pkg"
 	"github.com/kolonialno/pr-deployment-controller/pkg/apis"
+	"github.com/kolonialno/pr-deployment-controller/pkg/auth"
 	"github.com/kolonialno/pr-deployment-controller/pkg/builder"
+	"github.com/kolonialno/pr-deployment-controller/pkg/buildlog"
 	"github.com/kolonialno/pr-deployment-controller/pkg/cleanup"
 	"github.com/kolonialno/pr-deployment-controller/pkg/controller"
 	"github.com/kolonialno/pr-deployment-controller/pkg/controller/build"
 	"github.com/kolonialno/pr-deployment-controller/pkg/controller/database"
 	"github.com/kolonialno/pr-deployment-controller/pkg/databaseprovisioner/worker"
 	"github.com/kolonialno/pr-deployment-controller/pkg/debug"
+	"github.com/kolonialno/pr-deployment-controller/pkg/delivery"
 	"github.com/kolonialno/pr-deployment-controller/pkg/docker"
 	"github.com/kolonialno/pr-deployment-controller/pkg/github"
+	"github.com/kolonialno/pr-deployment-controller/pkg/gitlab"
 	"github.com/kolonialno/pr-deployment-controller/pkg/k8s"
 	"github.com/kolonialno/pr-deployment-controller/pkg/status"
 	"github.com/kolonialno/pr-deployment-controller/pkg/webhook"
@@ -48,21 +52,92 @@ func init() {
 
 	internal.StringFlag(runCmd, "statusServiceName", "the name of the service exposing the status server", "")
 	internal.Int64Flag(runCmd, "statusServicePort", "the service port exposing the status server", 8000)
+	internal.StringFlag(runCmd, "statusURL", "Public URL of the status server, used to link build logs", "")
 
-	internal.StringFlag(runCmd, "dockerHost", "Docker daemon listen address", "")
+	internal.StringFlag(runCmd, "buildBackend", "Image build backend, docker or kaniko", docker.DockerBackend)
+	internal.StringSliceFlag(
+		runCmd, "dockerHost", "Docker daemon listen addresses, builds are spread across the daemons", nil,
+	)
 	internal.StringFlag(runCmd, "dockerAPIVersion", "Docker API version", "1.39")
-	internal.StringFlag(runCmd, "dockerCertFile", "Docker certificate path", "")
-	internal.StringFlag(runCmd, "dockerKeyFile", "Docker certificate key path", "")
-	internal.StringFlag(runCmd, "dockerCAFile", "Docker CA certificate path", "")
+	internal.StringSliceFlag(runCmd, "dockerCertFile", "Docker certificate paths, one per daemon or shared", nil)
+	internal.StringSliceFlag(runCmd, "dockerKeyFile", "Docker certificate key paths, one per daemon or shared", nil)
+	internal.StringSliceFlag(runCmd, "dockerCAFile", "Docker CA certificate paths, one per daemon or shared", nil)
 	internal.StringFlag(runCmd, "dockerRegistry", "Docker registry prefix", "")
 	internal.StringFlag(runCmd, "dockerRegistryUsername", "Docker registry username", "")
 	internal.StringFlag(runCmd, "dockerRegistryPassword", "Docker registry password", "")
 	internal.StringFlag(runCmd, "dockerRegistryPasswordFile", "Docker registry password file", "")
+	internal.StringFlag(
+		runCmd, "kanikoNamespace", "Namespace the kaniko build jobs run in, must differ from the operator namespace", "",
+	)
+	internal.StringFlag(runCmd, "kanikoImage", "Kaniko executor image", "gcr.io/kaniko-project/executor:v1.9.1")
+	internal.StringFlag(runCmd, "kanikoCPULimit", "CPU limit of the kaniko build jobs", "2")
+	internal.StringFlag(runCmd, "kanikoMemoryLimit", "Memory limit of the kaniko build jobs", "4Gi")
+	internal.StringFlag(
+		runCmd, "kanikoRegistrySecret", "Docker config secret used by the kaniko build jobs to push images", "",
+	)
+	internal.StringFlag(
+		runCmd, "kanikoCacheRepository", "Repository storing the kaniko layer cache, defaults to <image repository>/cache",
+		"",
+	)
+	internal.Int64Flag(
+		runCmd, "maxBuildContextSize", "Max size of the build context sent to docker in bytes", builder.MaxBuildContextSize,
+	)
 
-	internal.StringFlag(runCmd, "githubWebhookSecret", "Secret used to sign GitHub webhooks", "")
+	internal.StringSliceFlag(
+		runCmd,
+		"githubWebhookSecret",
+		"Secrets used to sign GitHub webhooks, comma separated to allow secret rotation",
+		nil,
+	)
+	internal.BoolFlag(
+		runCmd,
+		"githubWebhookAllowSHA1",
+		"Accept webhooks signed with the legacy SHA-1 X-Hub-Signature header",
+		false,
+	)
 	internal.StringFlag(runCmd, "githubAccessToken", "Access token used to authenticate with the GitHub API", "")
+	internal.Int64Flag(runCmd, "githubAppID", "GitHub App id, used instead of the access token", 0)
+	internal.StringFlag(runCmd, "githubAppPrivateKeyFile", "GitHub App private key path", "")
+	internal.Int64Flag(
+		runCmd, "githubAppInstallationID", "GitHub App installation used for requests outside a repository", 0,
+	)
+	internal.BoolFlag(
+		runCmd,
+		"githubChecks",
+		"Report builds through the GitHub Checks API, requires GitHub App authentication",
+		false,
+	)
 	internal.StringFlag(runCmd, "githubUsername", "GitHub token owner username, used to filter comments", "")
 
+	internal.StringFlag(runCmd, "gitlabURL", "GitLab instance url, enables merge request environments", "")
+	internal.StringFlag(runCmd, "gitlabAccessToken", "Access token (api scope) used to authenticate with GitLab", "")
+	internal.StringSliceFlag(
+		runCmd,
+		"gitlabWebhookSecret",
+		"Secret tokens sent by GitLab webhooks, comma separated to allow secret rotation",
+		nil,
+	)
+	internal.StringFlag(runCmd, "gitlabUsername", "GitLab token owner username, used to filter notes", "")
+
+	internal.StringFlag(
+		runCmd,
+		"deliveryConfigMapName",
+		"ConfigMap used to record recent webhook deliveries",
+		"pr-deployment-controller-deliveries",
+	)
+	internal.StringFlag(
+		runCmd,
+		"approvalConfigMapName",
+		"ConfigMap used to store the approved commits of pull requests from forks and untrusted authors",
+		"pr-deployment-controller-approvals",
+	)
+	internal.StringFlag(
+		runCmd,
+		"apiTokenSecretName",
+		"Secret containing the bearer tokens accepted by the API, the API is disabled if empty",
+		"",
+	)
+
 	internal.StringFlag(
 		runCmd,
 		"databaseStorageClassName",
@@ -89,14 +164,34 @@ var runCmd = &cobra.Command{
 
 			internal.RequireString("statusServiceName"),
 
-			internal.RequireString("dockerHost"),
-			internal.RequireString("dockerAPIVersion"),
-			internal.RequireString("dockerCertFile"),
-			internal.RequireString("dockerKeyFile"),
-			internal.RequireString("dockerCAFile"),
-
-			internal.RequireString("githubWebhookSecret"),
-			internal.RequireString("githubAccessToken"),
+			internal.RequireString("buildBackend"),
+			internal.RequireWhen(
+				"buildBackend", docker.DockerBackend,
+				internal.RequireStringSlice("dockerHost"),
+				internal.RequireString("dockerAPIVersion"),
+				internal.RequireStringSlice("dockerCertFile"),
+				internal.RequireStringSlice("dockerKeyFile"),
+				internal.RequireStringSlice("dockerCAFile"),
+			),
+			internal.RequireWhen(
+				"buildBackend", docker.KanikoBackend,
+				internal.RequireString("kanikoNamespace"),
+				internal.RequireString("kanikoImage"),
+				internal.RequireString("kanikoCPULimit"),
+				internal.RequireString("kanikoMemoryLimit"),
+			),
+
+			internal.RequireStringSlice("githubWebhookSecret"),
+			internal.RequireAny(
+				internal.RequireString("githubAccessToken"),
+				internal.RequireString("githubAppPrivateKeyFile"),
+			),
+			// The Checks API is only available to GitHub Apps
+			internal.RequireWhen(
+				"githubChecks", "true",
+				internal.RequireInt64("githubAppID"),
+				internal.RequireString("githubAppPrivateKeyFile"),
+			),
 
 			internal.RequireString("databaseStorageClassName"),
 			internal.RequireString("databaseServiceAccountName"),
@@ -110,9 +205,23 @@ var runCmd = &cobra.Command{
 		var buildClusterRole string
 		var statusServiceName string
 		var statusServicePort int64
-		var dockerHost, dockerAPIVersion, dockerCertFile, dockerKeyFile, dockerCAFile string
+		var statusURL string
+		var buildBackend string
+		var dockerHosts, dockerCertFiles, dockerKeyFiles, dockerCAFiles []string
+		var dockerAPIVersion string
 		var dockerRegistry, dockerRegistryUsername, dockerRegistryPassword, dockerRegistryPasswordFile string
-		var githubWebhookSecret, githubAccessToken, githubUsername string
+		var kanikoNamespace, kanikoImage, kanikoRegistrySecret, kanikoCacheRepository string
+		var kanikoCPULimit, kanikoMemoryLimit string
+		var maxBuildContextSize int64
+		var githubWebhookSecrets []string
+		var githubWebhookAllowSHA1 bool
+		var githubAccessToken, githubUsername string
+		var githubAppID, githubAppInstallationID int64
+		var githubAppPrivateKeyFile string
+		var githubChecks bool
+		var gitlabURL, gitlabAccessToken, gitlabUsername string
+		var gitlabWebhookSecrets []string
+		var deliveryConfigMapName, approvalConfigMapName, apiTokenSecretName string
 		var databaseStorageClassName, databaseServiceAccountName string
 		{
 			statusAddr = viper.GetString("statusAddr")
@@ -128,21 +237,44 @@ var runCmd = &cobra.Command{
 
 			statusServiceName = viper.GetString("statusServiceName")
 			statusServicePort = viper.GetInt64("statusServicePort")
+			statusURL = viper.GetString("statusURL")
 
-			dockerHost = viper.GetString("dockerHost")
+			buildBackend = viper.GetString("buildBackend")
+			dockerHosts = internal.GetStringSlice("dockerHost")
 			dockerAPIVersion = viper.GetString("dockerAPIVersion")
-			dockerCertFile = viper.GetString("dockerCertFile")
-			dockerKeyFile = viper.GetString("dockerKeyFile")
-			dockerCAFile = viper.GetString("dockerCAFile")
+			dockerCertFiles = internal.GetStringSlice("dockerCertFile")
+			dockerKeyFiles = internal.GetStringSlice("dockerKeyFile")
+			dockerCAFiles = internal.GetStringSlice("dockerCAFile")
 			dockerRegistry = viper.GetString("dockerRegistry")
 			dockerRegistryUsername = viper.GetString("dockerRegistryUsername")
 			dockerRegistryPassword = viper.GetString("dockerRegistryPassword")
 			dockerRegistryPasswordFile = viper.GetString("dockerRegistryPasswordFile")
-
-			githubWebhookSecret = viper.GetString("githubWebhookSecret")
+			kanikoNamespace = viper.GetString("kanikoNamespace")
+			kanikoImage = viper.GetString("kanikoImage")
+			kanikoRegistrySecret = viper.GetString("kanikoRegistrySecret")
+			kanikoCacheRepository = viper.GetString("kanikoCacheRepository")
+			kanikoCPULimit = viper.GetString("kanikoCPULimit")
+			kanikoMemoryLimit = viper.GetString("kanikoMemoryLimit")
+			maxBuildContextSize = viper.GetInt64("maxBuildContextSize")
+
+			githubWebhookSecrets = internal.GetStringSlice("githubWebhookSecret")
+			githubWebhookAllowSHA1 = viper.GetBool("githubWebhookAllowSHA1")
 			githubAccessToken = viper.GetString("githubAccessToken")
+			githubAppID = viper.GetInt64("githubAppID")
+			githubAppPrivateKeyFile = viper.GetString("githubAppPrivateKeyFile")
+			githubAppInstallationID = viper.GetInt64("githubAppInstallationID")
+			githubChecks = viper.GetBool("githubChecks")
 			githubUsername = viper.GetString("githubUsername")
 
+			gitlabURL = viper.GetString("gitlabURL")
+			gitlabAccessToken = viper.GetString("gitlabAccessToken")
+			gitlabWebhookSecrets = internal.GetStringSlice("gitlabWebhookSecret")
+			gitlabUsername = viper.GetString("gitlabUsername")
+
+			deliveryConfigMapName = viper.GetString("deliveryConfigMapName")
+			approvalConfigMapName = viper.GetString("approvalConfigMapName")
+			apiTokenSecretName = viper.GetString("apiTokenSecretName")
+
 			databaseStorageClassName = viper.GetString("databaseStorageClassName")
 			databaseServiceAccountName = viper.GetString("databaseServiceAccountName")
 		}
@@ -194,11 +326,28 @@ var runCmd = &cobra.Command{
 		})
 
 		// Setup GitHub interface
-		githubController, err := github.New(logger.WithField("component", "github"), githubAccessToken)
+		githubController, err := github.New(logger.WithField("component", "github"), &github.Config{
+			AccessToken:    githubAccessToken,
+			AppID:          githubAppID,
+			PrivateKeyFile: githubAppPrivateKeyFile,
+			InstallationID: githubAppInstallationID,
+		})
 		if err != nil {
 			return err
 		}
 
+		// Setup GitLab interface, optional
+		var gitlabController gitlab.GitLab
+		if gitlabURL != "" {
+			gitlabController, err = gitlab.New(logger.WithField("component", "gitlab"), &gitlab.Config{
+				URL:   gitlabURL,
+				Token: gitlabAccessToken,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
 		// Setup apiserver client config (Load in-cluster kubeconfig)
 		cfg, err := config.GetConfig()
 		if err != nil {
@@ -241,6 +390,7 @@ var runCmd = &cobra.Command{
 			BuildPrefix:       buildPrefix,
 			ClusterDomain:     clusterDomain,
 			GitHub:            githubController,
+			GitLab:            gitlabController,
 			IstioNamespace:    "istio-system",
 			IstioGateway:      "default",
 			BuildClusterRole:  buildClusterRole,
@@ -261,22 +411,51 @@ var runCmd = &cobra.Command{
 			return errors.Wrap(err, "unable to register controllers to the manager")
 		}
 
-		// Setup docker interface
-		dockerController, err := docker.New(logger.WithField("component", "docker"), &docker.Config{
-			Host:                 dockerHost,
+		// Setup docker interface, images are built by the docker daemon or kaniko jobs
+		dockerConfig := &docker.Config{
 			APIVersion:           dockerAPIVersion,
-			CertFile:             dockerCertFile,
-			KeyFile:              dockerKeyFile,
-			CACertFile:           dockerCAFile,
 			Registry:             dockerRegistry,
 			RegistryUsername:     dockerRegistryUsername,
 			RegistryPassword:     dockerRegistryPassword,
 			RegistryPasswordFile: dockerRegistryPasswordFile,
-		})
+		}
+
+		var dockerController docker.Docker
+		var dockerPool docker.Pool
+		switch buildBackend {
+		case docker.DockerBackend:
+			dockerConfig.Daemons, err = docker.DaemonConfigs(dockerHosts, dockerCertFiles, dockerKeyFiles, dockerCAFiles)
+			if err != nil {
+				return err
+			}
+
+			dockerPool, err = docker.New(logger.WithField("component", "docker"), dockerConfig)
+			dockerController = dockerPool
+		case docker.KanikoBackend:
+			// The build jobs run untrusted code next to the registry secret, the operator secrets
+			// are kept out of reach
+			if kanikoNamespace == namespace {
+				return errors.New("kanikoNamespace must be a dedicated namespace, not the operator namespace")
+			}
+
+			dockerController, err = docker.NewKaniko(logger.WithField("component", "kaniko"), dockerConfig, &docker.KanikoConfig{
+				Namespace:       kanikoNamespace,
+				Image:           kanikoImage,
+				RegistrySecret:  kanikoRegistrySecret,
+				CacheRepository: kanikoCacheRepository,
+				CPULimit:        kanikoCPULimit,
+				MemoryLimit:     kanikoMemoryLimit,
+			}, cfg)
+		default:
+			err = errors.Errorf("unknown build backend %s", buildBackend)
+		}
 		if err != nil {
 			return err
 		}
 
+		// Build output captured by the builders, served by the status server
+		buildLogs := buildlog.New()
+
 		// Setup a new builder
 		builderController, err := builder.New(logger.WithField("component", "builder"), &builder.Options{
 			GitHub: githubController,
@@ -286,31 +465,92 @@ var runCmd = &cobra.Command{
 			RuntimeSummary: jobDurationSeconds,
 			ClusterDomain:  clusterDomain,
 			BuildPrefix:    buildPrefix,
+			Checks:         githubChecks,
+
+			ApprovalConfigMapName: approvalConfigMapName,
+			MaxBuildContextSize:   maxBuildContextSize,
+			BuildLogs:             buildLogs,
+			StatusURL:             statusURL,
+			Ready:                 lockacquisition.Done(),
 		})
 		if err != nil {
 			return errors.Wrap(err, "could not create the build controller (the operator instance)")
 		}
 
+		// Setup a separate builder for GitLab merge requests, GitHub checks and deployments are not used
+		var gitlabOptions *webhook.GitLabOptions
+		if gitlabController != nil {
+			gitlabBuilder, err := builder.New(logger.WithField("component", "gitlab-builder"), &builder.Options{
+				SCM:    gitlabController,
+				Docker: dockerController,
+				K8s:    k8sEnv,
+
+				RuntimeSummary: jobDurationSeconds,
+				ClusterDomain:  clusterDomain,
+				BuildPrefix:    buildPrefix,
+
+				ApprovalConfigMapName: approvalConfigMapName,
+				MaxBuildContextSize:   maxBuildContextSize,
+				BuildLogs:             buildLogs,
+				StatusURL:             statusURL,
+				Ready:                 lockacquisition.Done(),
+			})
+			if err != nil {
+				return errors.Wrap(err, "could not create the gitlab build controller")
+			}
+
+			gitlabOptions = &webhook.GitLabOptions{
+				Builder:  gitlabBuilder,
+				GitLab:   gitlabController,
+				Secrets:  gitlabWebhookSecrets,
+				Username: gitlabUsername,
+			}
+		}
+
 		// Setup the background cleanup task
-		cleanupWorker, err := cleanup.New(logger.WithField("component", "cleanup"), k8sEnv, githubController)
+		cleanupWorker, err := cleanup.New(
+			logger.WithField("component", "cleanup"), k8sEnv, githubController, gitlabController,
+		)
 		if err != nil {
 			return errors.Wrap(err, "could not create the cleanup worker")
 		}
 
+		// Setup the webhook delivery store, used to ignore redeliveries
+		var deliveryStore delivery.Store
+		if deliveryConfigMapName != "" {
+			deliveryStore, err = delivery.New(logger.WithField("component", "delivery"), k8sEnv, deliveryConfigMapName)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Setup API authentication, bearer tokens are read from a secret
+		var authenticator *auth.Authenticator
+		if apiTokenSecretName != "" {
+			authenticator, err = auth.New(logger.WithField("component", "auth"), k8sEnv, apiTokenSecretName)
+			if err != nil {
+				return err
+			}
+		}
+
 		// Setup webhook http handlers
 		webhookHandler, err := webhook.New(
 			logger.WithField("component", "webhook"),
 			builderController,
 			githubController,
-			githubWebhookSecret,
+			githubWebhookSecrets,
+			githubWebhookAllowSHA1,
 			githubUsername,
+			gitlabOptions,
+			deliveryStore,
+			authenticator,
 		)
 		if err != nil {
 			return err
 		}
 
 		// Setup the status http handlers
-		statusHandler, err := status.New(logger.WithField("component", "status"), cfg, k8sEnv)
+		statusHandler, err := status.New(logger.WithField("component", "status"), cfg, k8sEnv, buildLogs)
 		if err != nil {
 			return err
 		}
@@ -414,10 +654,18 @@ var runCmd = &cobra.Command{
 			// Builder worker
 			g.Add(builderController.Start, builderController.Stop)
 		}
+		if gitlabOptions != nil {
+			// GitLab builder worker
+			g.Add(gitlabOptions.Builder.Start, gitlabOptions.Builder.Stop)
+		}
 		{
 			// Cleanup worker
 			g.Add(cleanupWorker.Runnable())
 		}
+		if dockerPool != nil {
+			// Docker daemon health checks
+			g.Add(dockerPool.Runnable())
+		}
 		{
 			// Database provisioner
 			g.Add(func() error {
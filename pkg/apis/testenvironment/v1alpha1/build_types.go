@@ -7,12 +7,17 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// ExpiresAnnotation overrides the time a build is removed by the cleanup worker (RFC3339)
+const ExpiresAnnotation = "testenvironment.kolonial.no/expires"
+
 // GitSpec defines the git context the build is based on
 type GitSpec struct {
 	Owner             string `json:"owner"`
 	Repository        string `json:"repository"`
 	Ref               string `json:"ref"`
-	PullRequestNumber int64  `json:"pullRequestNumber"`
+	PullRequestNumber int64  `json:"pullRequestNumber,omitempty"`
+	Branch            string `json:"branch,omitempty"`   // Set for branch builds, replaces the pull request number
+	Provider          string `json:"provider,omitempty"` // Source control provider, github if empty
 }
 
 // BuildSpec defines the desired state of Build
@@ -20,15 +25,21 @@ type BuildSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	Environment string   `json:"environment"`   // Environment name to base build on
-	Image       string   `json:"image"`         // Image to base containers on
-	Git         *GitSpec `json:"git,omitempty"` // Git reference build is based on
+	Environment  string            `json:"environment"`            // Environment name to base build on
+	Image        string            `json:"image"`                  // Image to base containers on
+	Images       map[string]string `json:"images,omitempty"`       // Named images, keyed by the image name
+	Git          *GitSpec          `json:"git,omitempty"`          // Git reference build is based on
+	DeploymentID int64             `json:"deploymentID,omitempty"` // GitHub deployment tracking the build
+	DeployedAt   *metav1.Time      `json:"deployedAt,omitempty"`   // Time of the last deploy
 }
 
 // BuildStatus defines the observed state of Build
 type BuildStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	DeploymentID    int64  `json:"deploymentID,omitempty"`    // GitHub deployment the state is reported to
+	DeploymentState string `json:"deploymentState,omitempty"` // Last state reported to the GitHub deployment
 }
 
 // +genclient
@@ -0,0 +1,64 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BuildRequestSpec defines a queued build job
+type BuildRequestSpec struct {
+	ID                int64    `json:"id"`                          // Queue time in unix nanoseconds, orders the jobs of a build
+	Provider          string   `json:"provider"`                    // Source control provider of the builder processing the job
+	Owner             string   `json:"owner"`                       // Repository owner
+	Repository        string   `json:"repository"`                  // Repository name
+	PullRequestNumber int64    `json:"pullRequestNumber,omitempty"` // Pull request to build
+	Branch            string   `json:"branch,omitempty"`            // Branch to build, replaces the pull request number
+	Ref               string   `json:"ref,omitempty"`               // Commit sha to build
+	User              string   `json:"user,omitempty"`              // User triggering the job
+	Author            string   `json:"author,omitempty"`            // Pull request author
+	Labels            []string `json:"labels,omitempty"`            // Pull request labels
+	Fork              bool     `json:"fork,omitempty"`              // Pull request opened from a fork
+	Draft             bool     `json:"draft,omitempty"`             // Draft pull request
+	FirstRun          bool     `json:"firstRun,omitempty"`          // First build of the pull request
+	Clean             bool     `json:"clean,omitempty"`             // Remove the existing build before deploying
+	Force             bool     `json:"force,omitempty"`             // Ignore the environment deployment policies
+	Delete            bool     `json:"delete,omitempty"`            // Delete the build instead of building
+	Conditional       bool     `json:"conditional,omitempty"`       // Keep the build if the deployment still is allowed
+	DeploymentID      int64    `json:"deploymentId,omitempty"`      // GitHub deployment created when the job was queued
+}
+
+// BuildRequestStatus defines the observed state of BuildRequest
+type BuildRequestStatus struct {
+	Holder         string       `json:"holder,omitempty"`         // Operator instance processing the job
+	LeaseExpiresAt *metav1.Time `json:"leaseExpiresAt,omitempty"` // The job is resumed by another worker after this time
+	Attempts       int          `json:"attempts,omitempty"`       // Number of times the job was claimed
+	NotBefore      *metav1.Time `json:"notBefore,omitempty"`      // Parked jobs are not claimed before this time
+	WaitingSince   *metav1.Time `json:"waitingSince,omitempty"`   // The job started waiting for an external image
+	ReportedImage  string       `json:"reportedImage,omitempty"`  // Image reported by external CI for the commit
+	CheckRunID     int64        `json:"checkRunId,omitempty"`     // Check run reused when a parked job resumes
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BuildRequest is the Schema for the buildrequests API, a job queued for the builder
+// +k8s:openapi-gen=true
+type BuildRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BuildRequestSpec   `json:"spec,omitempty"`
+	Status BuildRequestStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BuildRequestList contains a list of BuildRequest
+type BuildRequestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BuildRequest `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BuildRequest{}, &BuildRequestList{})
+}
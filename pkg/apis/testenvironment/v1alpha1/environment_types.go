@@ -47,6 +47,7 @@ type ServiceSpec struct {
 // TaskSpec defines the tasks based on the build image to run (migrations)
 type TaskSpec struct {
 	Name      string                      `json:"name"`
+	Image     string                      `json:"image,omitempty"` // Named image to run, defaults to the build image
 	Env       []corev1.EnvVar             `json:"env,omitempty"`
 	Args      []string                    `json:"args,omitempty"`
 	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
@@ -61,6 +62,7 @@ type ExecSpec struct {
 // ContainerSpec defines the containers to start based on the build image
 type ContainerSpec struct {
 	Name           string                      `json:"name"`
+	Image          string                      `json:"image,omitempty"` // Named image to run, defaults to the build image
 	Ports          []PortSpec                  `json:"ports,omitempty"`
 	Env            []corev1.EnvVar             `json:"env,omitempty"`
 	Args           []string                    `json:"args,omitempty"`
@@ -90,6 +92,52 @@ type LinkSpec struct {
 	URL   string `json:"url"`
 }
 
+// DraftPolicy defines how draft pull requests are handled
+type DraftPolicy string
+
+const (
+	// DraftPolicyDeploy deploys draft pull requests like other pull requests (default)
+	DraftPolicyDeploy DraftPolicy = "deploy"
+	// DraftPolicySkip skips draft pull requests
+	DraftPolicySkip DraftPolicy = "skip"
+	// DraftPolicyBuild builds the image of draft pull requests without deploying it
+	DraftPolicyBuild DraftPolicy = "build"
+)
+
+// ExternalImageSpec configures the deployment of images built and pushed by external CI
+type ExternalImageSpec struct {
+	// Image repository, tagged with the commit sha. Defaults to the controller registry path
+	Repository string `json:"repository,omitempty"`
+	// Seconds to wait for the image before the build fails, defaults to 30 minutes
+	TimeoutSeconds int64 `json:"timeoutSeconds,omitempty"`
+}
+
+// ImageBuildSpec configures the docker build of the environment image
+type ImageBuildSpec struct {
+	// Dockerfile path inside the build context, defaults to Dockerfile
+	Dockerfile string `json:"dockerfile,omitempty"`
+	// Repository subdirectory used as build context, defaults to the repository root
+	Context string `json:"context,omitempty"`
+	// Build args, the values are templated with .Owner, .Repository, .PullRequestNumber,
+	// .Branch and .Version (commit sha)
+	Args map[string]string `json:"args,omitempty"`
+	// Build stage, the last stage is built if empty
+	Target string `json:"target,omitempty"`
+	// Labels applied to the image
+	Labels map[string]string `json:"labels,omitempty"`
+	// Images used as build cache (the base branch image), the previous image of the pull
+	// request is always used. The kaniko backend caches the layers in the registry instead.
+	CacheFrom []string `json:"cacheFrom,omitempty"`
+}
+
+// NamedImageSpec configures an additional image built from the repository, containers and
+// tasks select the image by name
+type NamedImageSpec struct {
+	// Image name, lowercase alphanumeric characters and dashes
+	Name           string `json:"name"`
+	ImageBuildSpec `json:",inline"`
+}
+
 // EnvironmentSpec defines the desired state of Environment
 type EnvironmentSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
@@ -117,6 +165,22 @@ type EnvironmentSpec struct {
 	IgnoredUsers []string `json:"ignoredUsers,omitempty"`
 	// Dont deploy on demand builds automatically
 	OnDemand bool `json:"onDemand,omitempty"`
+	// Only deploy PRs labeled with this label
+	DeployLabel string `json:"deployLabel,omitempty"`
+	// Dont deploy PRs labeled with this label
+	ExcludeLabel string `json:"excludeLabel,omitempty"`
+	// Handling of draft pull requests, deploy, skip or build
+	DraftPolicy DraftPolicy `json:"draftPolicy,omitempty"`
+	// Deploy persistent environments for branches matching these patterns (release/*)
+	Branches []string `json:"branches,omitempty"`
+	// Build PRs from these users without approval, PRs from forks always require approval
+	TrustedUsers []string `json:"trustedUsers,omitempty"`
+	// Deploy images built by external CI instead of building in-cluster
+	ExternalImage *ExternalImageSpec `json:"externalImage,omitempty"`
+	// Docker build configuration, ignored when the image is built by external CI
+	ImageBuild *ImageBuildSpec `json:"imageBuild,omitempty"`
+	// Additional images built in parallel with the build image
+	Images []NamedImageSpec `json:"images,omitempty"`
 }
 
 // EnvironmentStatus defines the observed state of Environment
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/kolonialno/pr-deployment-controller/pkg/k8s"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// +kubebuilder:rbac:groups=,resources=secrets,verbs=get;list;watch
+
+// clientKey is the context key storing the authenticated client name
+type clientKey struct{}
+
+// Authenticator validates bearer tokens against the values of a Secret in the operator
+// namespace, the key of each value names the client (release-tool: <token>). Tokens are
+// read on each request, rotating a token only requires updating the Secret.
+type Authenticator struct {
+	logger     *logrus.Entry
+	k8s        *k8s.Environment
+	secretName string
+}
+
+// New returns a new authenticator
+func New(logger *logrus.Entry, k8sEnv *k8s.Environment, secretName string) (*Authenticator, error) {
+	return &Authenticator{
+		logger:     logger,
+		k8s:        k8sEnv,
+		secretName: secretName,
+	}, nil
+}
+
+// Authenticate validates the request bearer token and returns the client name
+func (a *Authenticator) Authenticate(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", ErrMissingToken
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	if token == "" {
+		return "", ErrMissingToken
+	}
+
+	tokens, err := a.tokens(r.Context())
+	if err != nil {
+		return "", err
+	}
+
+	return matchToken(tokens, token)
+}
+
+// Handler wraps a handler, requests without a valid bearer token are rejected
+func (a *Authenticator) Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		client, err := a.Authenticate(r)
+		if err != nil {
+			a.logger.WithError(err).WithField("path", r.URL.Path).Warn("rejected api request")
+
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			rw.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(rw).Encode(map[string]string{"error": err.Error()}) // nolint: gosec, gas, errcheck
+			return
+		}
+
+		a.logger.WithFields(logrus.Fields{"client": client, "path": r.URL.Path}).Info("api request")
+		next(rw, r.WithContext(context.WithValue(r.Context(), clientKey{}, client)))
+	}
+}
+
+// Client returns the name of the authenticated client, empty if the request isn't authenticated
+func Client(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+// tokens reads the tokens from the Secret
+func (a *Authenticator) tokens(ctx context.Context) (map[string][]byte, error) {
+	secret := &corev1.Secret{}
+	if err := a.k8s.Get(ctx, types.NamespacedName{Name: a.secretName, Namespace: a.k8s.Namespace}, secret); err != nil {
+		return nil, err
+	}
+
+	return secret.Data, nil
+}
+
+// matchToken compares the token against each of the tokens in constant time, and
+// returns the name of the matching token
+func matchToken(tokens map[string][]byte, token string) (string, error) {
+	var client string
+
+	for name, value := range tokens {
+		// Secrets created from files often include a trailing newline
+		value = bytes.TrimSpace(value)
+		if len(value) > 0 && subtle.ConstantTimeCompare(value, []byte(token)) == 1 {
+			client = name
+		}
+	}
+
+	if client == "" {
+		return "", ErrInvalidToken
+	}
+
+	return client, nil
+}
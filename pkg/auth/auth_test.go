@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchToken(t *testing.T) {
+	tokens := map[string][]byte{
+		"release-tool": []byte("first\n"),
+		"chat-ops":     []byte("second"),
+		"disabled":     []byte(""),
+	}
+
+	client, err := matchToken(tokens, "second")
+	assert.Nil(t, err)
+	assert.Equal(t, "chat-ops", client)
+
+	_, err = matchToken(tokens, "third")
+	assert.Equal(t, ErrInvalidToken, err)
+
+	_, err = matchToken(tokens, "")
+	assert.Equal(t, ErrInvalidToken, err)
+}
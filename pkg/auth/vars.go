@@ -0,0 +1,10 @@
+package auth
+
+import "errors"
+
+var (
+	// ErrMissingToken Error
+	ErrMissingToken = errors.New("missing bearer token in the Authorization header")
+	// ErrInvalidToken Error
+	ErrInvalidToken = errors.New("invalid bearer token")
+)
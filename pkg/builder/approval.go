@@ -0,0 +1,135 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
+	"github.com/kolonialno/pr-deployment-controller/pkg/internal"
+	"github.com/kolonialno/pr-deployment-controller/pkg/k8s"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+// +kubebuilder:rbac:groups=,resources=configmaps,verbs=get;list;watch;create;update
+
+// approval records the PR commit approved by a maintainer
+type approval struct {
+	SHA        string    `json:"sha"`
+	User       string    `json:"user"`
+	ApprovedAt time.Time `json:"approvedAt"`
+}
+
+// approvalStore stores the approvals in a ConfigMap in the operator namespace, one key
+// per PR. The ConfigMap is read on every lookup, builders for different providers share it.
+type approvalStore struct {
+	k8s  *k8s.Environment
+	name string
+}
+
+// newApprovalStore returns an approval store backed by a ConfigMap
+func newApprovalStore(k8sEnv *k8s.Environment, configMapName string) *approvalStore {
+	return &approvalStore{
+		k8s:  k8sEnv,
+		name: configMapName,
+	}
+}
+
+// approve stores the approved commit for a PR, replacing the previous approval
+func (s *approvalStore) approve(ctx context.Context, key, sha, user string, now time.Time) error {
+	value, err := json.Marshal(&approval{SHA: sha, User: user, ApprovedAt: now})
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap := &corev1.ConfigMap{}
+		err := s.k8s.Get(ctx, types.NamespacedName{Name: s.name, Namespace: s.k8s.Namespace}, configMap)
+		if err != nil && errors.IsNotFound(err) {
+			return s.k8s.Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      s.name,
+					Namespace: s.k8s.Namespace,
+				},
+				Data: map[string]string{key: string(value)},
+			})
+		} else if err != nil {
+			return err
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		pruneApprovals(configMap.Data, now)
+		configMap.Data[key] = string(value)
+
+		return s.k8s.Update(ctx, configMap)
+	})
+}
+
+// approved returns true if the commit is approved for the PR
+func (s *approvalStore) approved(ctx context.Context, key, sha string) (bool, error) {
+	configMap := &corev1.ConfigMap{}
+	err := s.k8s.Get(ctx, types.NamespacedName{Name: s.name, Namespace: s.k8s.Namespace}, configMap)
+	if err != nil && errors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	value, ok := configMap.Data[key]
+	if !ok {
+		return false, nil
+	}
+
+	var stored approval
+	if err = json.Unmarshal([]byte(value), &stored); err != nil {
+		return false, err
+	}
+
+	return stored.SHA == sha, nil
+}
+
+// pruneApprovals removes the approvals older than ApprovalRetention
+func pruneApprovals(data map[string]string, now time.Time) {
+	for key, value := range data {
+		var stored approval
+		if err := json.Unmarshal([]byte(value), &stored); err != nil || now.Sub(stored.ApprovedAt) > ApprovalRetention {
+			delete(data, key)
+		}
+	}
+}
+
+// approvalKey returns the ConfigMap key storing the approval for a PR
+func approvalKey(provider, owner, repository string, number int64) string {
+	return fmt.Sprintf("%s.%s.%s.%d", provider, internal.OwnerName(owner), repository, number)
+}
+
+// approvalRequired checks the job against the environment trust rules, the reason
+// is returned if the commit must be approved before it is built
+func approvalRequired(environment *testenvironmentv1alpha1.Environment, j *job) (bool, string) {
+	// Branch builds are triggered by pushes to the repository itself
+	if j.branch != "" {
+		return false, ""
+	}
+
+	if j.fork {
+		return true, "PR from a fork"
+	}
+
+	if len(environment.Spec.TrustedUsers) == 0 {
+		return false, ""
+	}
+	for _, user := range environment.Spec.TrustedUsers {
+		if user == j.author {
+			return false, ""
+		}
+	}
+
+	return true, "PR author not trusted"
+}
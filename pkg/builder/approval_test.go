@@ -0,0 +1,56 @@
+package builder
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApprovalRequired(t *testing.T) {
+	environment := &testenvironmentv1alpha1.Environment{}
+
+	required, _ := approvalRequired(environment, &job{author: "someone"})
+	assert.False(t, required, "no trusted users configured")
+
+	required, reason := approvalRequired(environment, &job{author: "someone", fork: true})
+	assert.True(t, required, "forks always require approval")
+	assert.Equal(t, "PR from a fork", reason)
+
+	environment.Spec.TrustedUsers = []string{"maintainer"}
+
+	required, _ = approvalRequired(environment, &job{author: "maintainer"})
+	assert.False(t, required, "trusted author")
+
+	required, reason = approvalRequired(environment, &job{author: "someone"})
+	assert.True(t, required, "untrusted author")
+	assert.Equal(t, "PR author not trusted", reason)
+
+	required, _ = approvalRequired(environment, &job{branch: "release/1.0", fork: true})
+	assert.False(t, required, "branch builds are trusted")
+}
+
+func TestPruneApprovals(t *testing.T) {
+	now := time.Now()
+
+	value := func(approvedAt time.Time) string {
+		data, _ := json.Marshal(&approval{SHA: "abc", User: "maintainer", ApprovedAt: approvedAt})
+		return string(data)
+	}
+
+	data := map[string]string{
+		"github.owner.repo.1": value(now.Add(-time.Hour)),
+		"github.owner.repo.2": value(now.Add(-ApprovalRetention - time.Hour)),
+		"github.owner.repo.3": "invalid",
+	}
+	pruneApprovals(data, now)
+
+	assert.Len(t, data, 1)
+	assert.Contains(t, data, "github.owner.repo.1")
+}
+
+func TestApprovalKey(t *testing.T) {
+	assert.Equal(t, "gitlab.group-subgroup.project.12", approvalKey("gitlab", "group/subgroup", "project", 12))
+}
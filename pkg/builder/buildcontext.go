@@ -0,0 +1,454 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+
+	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
+	"github.com/kolonialno/pr-deployment-controller/pkg/docker"
+)
+
+// buildContext streams the repository archive to the docker daemon, the root folder inside
+// the archive is removed while the daemon reads the context
+type buildContext struct {
+	*io.PipeReader
+
+	done chan struct{}
+	// err is set by the transform before done is closed
+	err error
+}
+
+// newBuildContext starts the transform of a repository archive into a build context, the
+// context contains the files inside the context folder and is limited to maxSize bytes
+func newBuildContext(dockerFile, contextDir string, archive io.Reader, maxSize int64) *buildContext {
+	source := newSpool(BuildContextMemoryBuffer)
+	pr, pw := io.Pipe()
+
+	c := &buildContext{
+		PipeReader: pr,
+		done:       make(chan struct{}),
+	}
+
+	// Download the archive independently of the upload, the daemon may read slower than the
+	// archive is served
+	go func() {
+		_, err := io.Copy(source, archive)
+		source.CloseWithError(err)
+	}()
+
+	go func() {
+		defer close(c.done)
+
+		c.err = rewriteBuildContext(dockerFile, contextDir, source, &limitWriter{w: pw, remaining: maxSize})
+		pw.CloseWithError(c.err) // nolint: gas, errcheck
+		source.Close()           // nolint: gas, errcheck
+	}()
+
+	return c
+}
+
+// result stops the transform and returns the transform error if the build failed because
+// of the build context, the build error is returned otherwise
+func (c *buildContext) result(buildErr error) error {
+	c.PipeReader.Close() // nolint: gas, errcheck
+	<-c.done
+
+	// The daemon stopped reading the context, the build error explains why
+	if c.err != nil && c.err != io.ErrClosedPipe {
+		return c.err
+	}
+
+	return buildErr
+}
+
+// imageBuildOptions returns the docker build options and the build context folder of an image
+// build configuration, the build args are templated with the job
+func imageBuildOptions(
+	spec *testenvironmentv1alpha1.ImageBuildSpec, j *job, image string,
+) (*docker.BuildOptions, string, error) {
+	options := &docker.BuildOptions{
+		Image:      image,
+		Dockerfile: "Dockerfile",
+	}
+
+	if spec == nil {
+		return options, "", nil
+	}
+
+	if spec.Dockerfile != "" {
+		options.Dockerfile = strings.TrimPrefix(path.Clean(spec.Dockerfile), "/")
+	}
+	options.Target = spec.Target
+	options.Labels = spec.Labels
+	options.CacheFrom = spec.CacheFrom
+
+	// Build arg template values
+	props := struct {
+		Owner             string
+		Repository        string
+		PullRequestNumber int64
+		Branch            string
+		Version           string
+	}{
+		Owner:             j.owner,
+		Repository:        j.repository,
+		PullRequestNumber: j.pullRequestNumber,
+		Branch:            j.branch,
+		Version:           j.ref,
+	}
+
+	if len(spec.Args) > 0 {
+		options.Args = map[string]string{}
+	}
+	for name, value := range spec.Args {
+		tmpl, err := template.New("arg").Parse(value)
+		if err != nil {
+			return nil, "", err
+		}
+
+		buff := bytes.NewBufferString("")
+		if err = tmpl.Execute(buff, props); err != nil {
+			return nil, "", err
+		}
+
+		options.Args[name] = buff.String()
+	}
+
+	// The context folder can't point outside the repository
+	contextDir := strings.Trim(path.Clean("/"+spec.Context), "/")
+
+	return options, contextDir, nil
+}
+
+// rewriteBuildContext removes the root folder inside the repository archive (place repository
+// files in the archive root), the context folder is used as root if set. Files outside the
+// context folder and the files excluded by .dockerignore are left out. Entries read
+// before the .dockerignore file are held back until the exclusions are known, git archives are
+// sorted so the held entries are the few paths sorting before .dockerignore.
+// nolint: gocyclo
+func rewriteBuildContext(dockerFile, contextDir string, r io.Reader, w io.Writer) error {
+	// Read gzip compressed file
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close() // nolint: gas, errcheck
+
+	// Read tar archive
+	tarReader := tar.NewReader(gzipReader)
+
+	// Initialize resulting tar writer
+	resultWriter := tar.NewWriter(w)
+
+	// The root folder name differs between providers (owner-repository-sha on GitHub,
+	// repository-sha-sha on GitLab), the first top level folder is used
+	baseFolder := ""
+	dockerFileFound := false
+
+	// ignore is nil until the .dockerignore file is read
+	var ignore dockerignore
+
+	// held stores the entries read before the .dockerignore file
+	held := newSpool(BuildContextMemoryBuffer)
+	defer held.Close() // nolint: gas, errcheck
+	heldWriter := tar.NewWriter(held)
+	holding := true
+
+	// write adds an entry to the build context unless excluded, the Dockerfile and
+	// .dockerignore are always sent (required by the daemon)
+	write := func(header *tar.Header, content io.Reader) error {
+		if header.Name == dockerFile {
+			dockerFileFound = true
+		} else if header.Name != dockerIgnoreFile && ignore.excluded(header.Name) {
+			return nil
+		}
+
+		if err := resultWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		// Copy content
+		_, err := io.Copy(resultWriter, content)
+		return err
+	}
+
+	// release writes the held entries, later entries are written directly
+	release := func() error {
+		holding = false
+
+		if err := heldWriter.Close(); err != nil {
+			return err
+		}
+		held.CloseWithError(nil)
+
+		heldReader := tar.NewReader(held)
+		for {
+			header, err := heldReader.Next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			if err := write(header, heldReader); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if header == nil {
+			break
+		}
+
+		// Skip the pax headers, GitHub and git archives start with a global header storing the commit
+		if header.Typeflag == tar.TypeXGlobalHeader || header.Typeflag == tar.TypeXHeader {
+			continue
+		}
+
+		// Skip the first folder (root folder inside the archive)
+		if baseFolder == "" && header.Typeflag == tar.TypeDir && strings.Count(header.Name, "/") == 1 &&
+			strings.HasSuffix(header.Name, "/") {
+			baseFolder = header.Name
+			continue
+		}
+
+		// Files at root level
+		header.Name = strings.TrimPrefix(header.Name, baseFolder)
+		if contextDir != "" {
+			if !strings.HasPrefix(header.Name, contextDir+"/") || header.Name == contextDir+"/" {
+				continue
+			}
+			header.Name = strings.TrimPrefix(header.Name, contextDir+"/")
+		}
+
+		if holding {
+			if header.Name == dockerIgnoreFile {
+				content, err := ioutil.ReadAll(tarReader)
+				if err != nil {
+					return err
+				}
+				if ignore, err = parseDockerignore(content); err != nil {
+					return err
+				}
+
+				if err := release(); err != nil {
+					return err
+				}
+				if err := write(header, bytes.NewReader(content)); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if !sortsAfterDockerignore(header.Name) {
+				if err := heldWriter.WriteHeader(header); err != nil {
+					return err
+				}
+				if _, err := io.Copy(heldWriter, tarReader); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// The archive has no .dockerignore file
+			if err := release(); err != nil {
+				return err
+			}
+		}
+
+		if err := write(header, tarReader); err != nil {
+			return err
+		}
+	}
+
+	if holding {
+		if err := release(); err != nil {
+			return err
+		}
+	}
+
+	// The daemon receives an incomplete context and fails the build
+	if !dockerFileFound {
+		return ErrNoDockerfileFound
+	}
+
+	return resultWriter.Close()
+}
+
+// sortsAfterDockerignore returns true if the path is placed after the .dockerignore file in
+// a git archive, folders are sorted as if the name ends with a slash
+func sortsAfterDockerignore(name string) bool {
+	top := name
+	if i := strings.Index(name, "/"); i >= 0 {
+		top = name[:i+1]
+	}
+
+	return top > dockerIgnoreFile
+}
+
+// limitWriter returns ErrBuildContextTooLarge when more than the remaining bytes are written
+type limitWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining {
+		return 0, ErrBuildContextTooLarge
+	}
+	l.remaining -= int64(len(p))
+
+	return l.w.Write(p)
+}
+
+// spool buffers data between a writer and a slower reader. Data is kept in memory up to the
+// limit and spilled to a temporary file while the reader is further behind, writes never block.
+type spool struct {
+	lock  sync.Mutex
+	ready *sync.Cond
+
+	limit  int
+	memory bytes.Buffer
+	// file holds the data written after the memory buffer filled up, removed when drained
+	file        *os.File
+	readOffset  int64
+	writeOffset int64
+
+	closed       bool
+	err          error // returned to the reader after the buffered data
+	readerClosed bool
+}
+
+func newSpool(limit int) *spool {
+	s := &spool{limit: limit}
+	s.ready = sync.NewCond(&s.lock)
+
+	return s
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.readerClosed {
+		return 0, io.ErrClosedPipe
+	}
+
+	if s.file == nil && s.memory.Len()+len(p) <= s.limit {
+		s.memory.Write(p) // nolint: gas, errcheck
+	} else {
+		if s.file == nil {
+			file, err := ioutil.TempFile("", "build-context-")
+			if err != nil {
+				return 0, err
+			}
+			s.file = file
+		}
+
+		n, err := s.file.WriteAt(p, s.writeOffset)
+		s.writeOffset += int64(n)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	s.ready.Broadcast()
+
+	return len(p), nil
+}
+
+func (s *spool) Read(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for {
+		// Data in memory is older than the data in the file
+		if s.memory.Len() > 0 {
+			return s.memory.Read(p)
+		}
+
+		if s.file != nil {
+			if s.readOffset < s.writeOffset {
+				if available := s.writeOffset - s.readOffset; int64(len(p)) > available {
+					p = p[:available]
+				}
+
+				n, err := s.file.ReadAt(p, s.readOffset)
+				s.readOffset += int64(n)
+				if err == io.EOF && n > 0 {
+					err = nil
+				}
+				return n, err
+			}
+
+			// The reader caught up, continue in memory
+			s.removeFile()
+		}
+
+		if s.readerClosed {
+			return 0, io.ErrClosedPipe
+		}
+		if s.closed {
+			if s.err != nil {
+				return 0, s.err
+			}
+			return 0, io.EOF
+		}
+
+		s.ready.Wait()
+	}
+}
+
+// CloseWithError closes the writer, the reader receives the error (io.EOF if nil) after the
+// buffered data
+func (s *spool) CloseWithError(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+	s.err = err
+	s.ready.Broadcast()
+}
+
+// Close closes the reader, the buffered data is discarded and further writes fails
+func (s *spool) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.readerClosed = true
+	s.memory.Reset()
+	s.removeFile()
+	s.ready.Broadcast()
+
+	return nil
+}
+
+// removeFile deletes the spill file, the caller holds the lock
+func (s *spool) removeFile() {
+	if s.file == nil {
+		return
+	}
+
+	s.file.Close()           // nolint: gas, errcheck
+	os.Remove(s.file.Name()) // nolint: gas, errcheck
+
+	s.file = nil
+	s.readOffset = 0
+	s.writeOffset = 0
+}
@@ -0,0 +1,172 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"sort"
+	"testing"
+
+	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
+	"github.com/stretchr/testify/assert"
+)
+
+// createArchive returns a gzip compressed tar archive shaped like GitHub archives, with the files
+// inside a root folder
+func createArchive(t *testing.T, files map[string]string) io.Reader {
+	buffer := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	// Entries are sorted like git archives
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// GitHub archives start with a pax global header storing the commit
+	assert.NoError(t, tarWriter.WriteHeader(&tar.Header{
+		Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "sha"},
+	}))
+	assert.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "owner-repo-sha/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for _, name := range names {
+		content := files[name]
+		assert.NoError(t, tarWriter.WriteHeader(&tar.Header{
+			Name: "owner-repo-sha/" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content)),
+		}))
+		_, err := tarWriter.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, tarWriter.Close())
+	assert.NoError(t, gzipWriter.Close())
+
+	return buffer
+}
+
+func TestBuildContext(t *testing.T) {
+	archive := createArchive(t, map[string]string{"Dockerfile": "FROM alpine"})
+
+	c := newBuildContext("Dockerfile", "", archive, MaxBuildContextSize)
+	content, err := ioutil.ReadAll(c)
+	assert.NoError(t, c.result(err))
+
+	// Files are moved to the archive root
+	header, err := tar.NewReader(bytes.NewReader(content)).Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "Dockerfile", header.Name)
+}
+
+func TestBuildContextDockerignore(t *testing.T) {
+	archive := createArchive(t, map[string]string{
+		".circleci/config.yml": "version: 2",
+		".dockerignore":        ".circleci\ndocs\nDockerfile\n",
+		"Dockerfile":           "FROM alpine",
+		"docs/index.md":        "# Docs",
+		"main.go":              "package main",
+	})
+
+	c := newBuildContext("Dockerfile", "", archive, MaxBuildContextSize)
+	content, err := ioutil.ReadAll(c)
+	assert.NoError(t, c.result(err))
+
+	var names []string
+	tarReader := tar.NewReader(bytes.NewReader(content))
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, header.Name)
+	}
+
+	// The Dockerfile and .dockerignore are always sent
+	assert.Equal(t, []string{".dockerignore", "Dockerfile", "main.go"}, names)
+}
+
+func TestBuildContextFolder(t *testing.T) {
+	archive := createArchive(t, map[string]string{
+		"docker/preview.Dockerfile": "FROM alpine",
+		"docker/entrypoint.sh":      "#!/bin/sh",
+		"main.go":                   "package main",
+	})
+
+	c := newBuildContext("preview.Dockerfile", "docker", archive, MaxBuildContextSize)
+	content, err := ioutil.ReadAll(c)
+	assert.NoError(t, c.result(err))
+
+	var names []string
+	tarReader := tar.NewReader(bytes.NewReader(content))
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, header.Name)
+	}
+
+	assert.Equal(t, []string{"entrypoint.sh", "preview.Dockerfile"}, names)
+}
+
+func TestImageBuildOptions(t *testing.T) {
+	environment := &testenvironmentv1alpha1.Environment{}
+	j := &job{owner: "kolonialno", repository: "test", pullRequestNumber: 3, ref: "abc"}
+
+	options, contextDir, err := imageBuildOptions(environment.Spec.ImageBuild, j, "registry/kolonialno/test:abc")
+	assert.NoError(t, err)
+	assert.Equal(t, "Dockerfile", options.Dockerfile)
+	assert.Equal(t, "", contextDir)
+
+	environment.Spec.ImageBuild = &testenvironmentv1alpha1.ImageBuildSpec{
+		Dockerfile: "/docker/preview.Dockerfile",
+		Context:    "../app/",
+		Target:     "preview",
+		Args:       map[string]string{"VERSION": "{{ .Version }}", "PR": "{{ .PullRequestNumber }}"},
+		CacheFrom:  []string{"registry/kolonialno/test:master"},
+	}
+	options, contextDir, err = imageBuildOptions(environment.Spec.ImageBuild, j, "registry/kolonialno/test:abc")
+	assert.NoError(t, err)
+	assert.Equal(t, "docker/preview.Dockerfile", options.Dockerfile)
+	assert.Equal(t, "app", contextDir)
+	assert.Equal(t, "preview", options.Target)
+	assert.Equal(t, map[string]string{"VERSION": "abc", "PR": "3"}, options.Args)
+	assert.Equal(t, []string{"registry/kolonialno/test:master"}, options.CacheFrom)
+}
+
+func TestBuildContextErrors(t *testing.T) {
+	c := newBuildContext("Dockerfile", "", createArchive(t, map[string]string{"main.go": "package main"}), MaxBuildContextSize)
+	_, err := ioutil.ReadAll(c)
+	assert.Equal(t, ErrNoDockerfileFound, c.result(err))
+
+	c = newBuildContext("Dockerfile", "", createArchive(t, map[string]string{"Dockerfile": "FROM alpine"}), 512)
+	_, err = ioutil.ReadAll(c)
+	assert.Equal(t, ErrBuildContextTooLarge, c.result(err))
+}
+
+func TestSpool(t *testing.T) {
+	s := newSpool(4)
+
+	// Data beyond the memory limit is spilled to disk and read in order
+	_, err := s.Write([]byte("abc"))
+	assert.NoError(t, err)
+	_, err = s.Write([]byte("defgh"))
+	assert.NoError(t, err)
+	assert.NotNil(t, s.file)
+	s.CloseWithError(nil)
+
+	content, err := ioutil.ReadAll(s)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdefgh", string(content))
+	assert.Nil(t, s.file)
+
+	// Writes fails when the reader is closed
+	s = newSpool(4)
+	assert.NoError(t, s.Close())
+	_, err = s.Write([]byte("abc"))
+	assert.Equal(t, io.ErrClosedPipe, err)
+}
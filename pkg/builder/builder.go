@@ -2,14 +2,16 @@ package builder
 
 import (
 	"context"
-	"fmt"
+	"os"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/kolonialno/pr-deployment-controller/pkg/buildlog"
 	"github.com/kolonialno/pr-deployment-controller/pkg/docker"
 	"github.com/kolonialno/pr-deployment-controller/pkg/github"
 	"github.com/kolonialno/pr-deployment-controller/pkg/k8s"
+	"github.com/kolonialno/pr-deployment-controller/pkg/scm"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
@@ -17,22 +19,50 @@ import (
 
 // Builder defines the builder controller
 type Builder interface {
-	NewBuild(
-		ctx context.Context, owner, repository string,
-		number int64, sha, user string, firstRun, clean, force bool,
-	) error
+	NewBuild(ctx context.Context, options BuildOptions) error
+	ApproveBuild(ctx context.Context, owner, repository string, number int64, sha, user string) error
+	ExternalImageReady(ctx context.Context, owner, repository, sha, image string) error
+	DeployLabel(ctx context.Context, owner, repository string) (string, error)
 	DeleteBuild(ctx context.Context, owner, repository string, number int64) error
+	ReevaluateBuild(
+		ctx context.Context, owner, repository string, number int64, labels []string, draft bool,
+	) error
+	NewBranchBuild(ctx context.Context, owner, repository, branch, sha, user string) error
+	DeleteBranchBuild(ctx context.Context, owner, repository, branch string) error
+	ExtendBuild(
+		ctx context.Context, owner, repository string, number int64, duration time.Duration,
+	) (time.Time, error)
+	BuildSummary(ctx context.Context, owner, repository string, number int64) (*BuildSummary, error)
+	ListBuilds(ctx context.Context, owner, repository string) ([]BuildInfo, error)
+	JobStatus(ctx context.Context, owner, repository string, number int64) (*JobStatus, error)
 
 	Start() error
 	Stop(err error)
 }
 
+// BuildOptions defines the pull request commit built by NewBuild
+type BuildOptions struct {
+	Owner      string
+	Repository string
+	Number     int64    // Pull request number
+	SHA        string   // Commit to build
+	User       string   // User triggering the build
+	Author     string   // Pull request author
+	Labels     []string // Pull request labels
+	Fork       bool     // Pull request opened from a fork
+	Draft      bool     // Draft pull request
+	FirstRun   bool     // First build of the pull request
+	Clean      bool     // Remove the existing build before deploying
+	Force      bool     // Ignore the environment deployment policies
+}
+
 type baseBuilder struct {
 	logger    *log.Entry
 	options   *Options
 	scheduler *scheduler
+	approvals *approvalStore
+	queue     *jobQueue
 
-	jobs    chan *job
 	results chan *jobResult
 
 	stop    chan struct{}
@@ -42,42 +72,136 @@ type baseBuilder struct {
 
 // Options defines the options required by the builder
 type Options struct {
+	// GitHub enables the GitHub specific features (checks and deployments), nil for other providers
 	GitHub github.Github
+	// SCM is the provider used to clone and report builds, defaults to GitHub
+	SCM    scm.Provider
 	Docker docker.Docker
 	K8s    *k8s.Environment
 
 	RuntimeSummary *prometheus.SummaryVec
 	ClusterDomain  string
 	BuildPrefix    string
+
+	// Checks reports builds through the GitHub Checks API instead of commit statuses
+	Checks bool
+	// ApprovalConfigMapName is the ConfigMap storing the approved commits of untrusted PRs
+	ApprovalConfigMapName string
+
+	// MaxBuildContextSize limits the size of the build context sent to docker, defaults to MaxBuildContextSize
+	MaxBuildContextSize int64
+
+	// BuildLogs stores the output of the build jobs, nil disables log capture
+	BuildLogs *buildlog.Store
+	// StatusURL is the public status server URL, used to link the build logs from the commit status
+	StatusURL string
+
+	// Identity identifies the operator instance holding the job leases, defaults to the hostname
+	Identity string
+	// Ready delays processing of the queued jobs until closed (leader election), nil starts immediately
+	Ready <-chan struct{}
 }
 
 // New returns a new builder controller
 func New(logger *log.Entry, options *Options) (Builder, error) {
+	if options.SCM == nil {
+		if options.GitHub == nil {
+			return nil, ErrMissingProvider
+		}
+		options.SCM = options.GitHub
+	}
+	if options.ApprovalConfigMapName == "" {
+		return nil, ErrMissingApprovalConfigMap
+	}
+	if options.MaxBuildContextSize <= 0 {
+		options.MaxBuildContextSize = MaxBuildContextSize
+	}
+	if options.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		options.Identity = hostname
+	}
+
 	return &baseBuilder{
 		logger:    logger,
 		options:   options,
 		scheduler: newScheduler(),
+		approvals: newApprovalStore(options.K8s, options.ApprovalConfigMapName),
+		queue: newJobQueue(
+			logger.WithField("component", "queue"), options.K8s, options.SCM.Name(), options.Identity,
+		),
 
-		jobs:    make(chan *job, 100),
 		results: make(chan *jobResult, 100),
 
-		stop:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
 		stopped: false,
 		wg:      sync.WaitGroup{},
 	}, nil
 }
 
 // NewBuild creates a new build
-func (b *baseBuilder) NewBuild(
-	ctx context.Context,
-	owner,
-	repository string,
-	number int64,
-	ref,
-	user string,
-	firstRun bool,
-	clean bool,
-	force bool,
+func (b *baseBuilder) NewBuild(ctx context.Context, options BuildOptions) error {
+	if b.stopped {
+		return ErrWorkerClosed
+	}
+
+	var createTime = time.Now()
+
+	job := &job{
+		id:                b.scheduler.getNextJobID(),
+		owner:             options.Owner,
+		repository:        options.Repository,
+		deleteEnvironment: false,
+
+		pullRequestNumber: options.Number,
+		ref:               options.SHA,
+		user:              options.User,
+		author:            options.Author,
+		fork:              options.Fork,
+		labels:            options.Labels,
+		draft:             options.Draft,
+		firstRun:          options.FirstRun,
+		clean:             options.Clean,
+		force:             options.Force,
+
+		createTime: &createTime,
+	}
+
+	return b.queueJob(ctx, job)
+}
+
+// ApproveBuild approves building a PR commit, required for PRs from forks and untrusted authors
+func (b *baseBuilder) ApproveBuild(
+	ctx context.Context, owner, repository string, number int64, sha, user string,
+) error {
+	return b.approvals.approve(
+		ctx, approvalKey(b.options.SCM.Name(), owner, repository, number), sha, user, time.Now(),
+	)
+}
+
+// ExternalImageReady records the commit image built by external CI on the jobs waiting for it and
+// resumes them, the registry is checked again if the image reference is empty
+func (b *baseBuilder) ExternalImageReady(ctx context.Context, owner, repository, sha, image string) error {
+	return b.queue.reportImage(ctx, owner, repository, sha, image)
+}
+
+// DeleteBuild deletes a build
+func (b *baseBuilder) DeleteBuild(ctx context.Context, owner, repository string, number int64) error {
+	return b.deleteBuild(ctx, owner, repository, number, false, nil, false)
+}
+
+// ReevaluateBuild deletes a build if the PR labels or draft state no longer allows the PR to be deployed
+func (b *baseBuilder) ReevaluateBuild(
+	ctx context.Context, owner, repository string, number int64, labels []string, draft bool,
+) error {
+	return b.deleteBuild(ctx, owner, repository, number, true, labels, draft)
+}
+
+// deleteBuild queues a build deletion job
+func (b *baseBuilder) deleteBuild(
+	ctx context.Context, owner, repository string, number int64, conditional bool, labels []string, draft bool,
 ) error {
 	if b.stopped {
 		return ErrWorkerClosed
@@ -89,31 +213,48 @@ func (b *baseBuilder) NewBuild(
 		id:                b.scheduler.getNextJobID(),
 		owner:             owner,
 		repository:        repository,
-		deleteEnvironment: false,
+		deleteEnvironment: true,
+		conditional:       conditional,
 
 		pullRequestNumber: number,
-		ref:               ref,
-		user:              user,
-		firstRun:          firstRun,
-		clean:             clean,
-		force:             force,
+		labels:            labels,
+		draft:             draft,
 
 		createTime: &createTime,
 	}
 
-	// Make sure the job ID is higher than the previous job for this repository
-	if err := b.scheduler.scheduleJob(fmt.Sprintf("%s/%s-%d", owner, repository, number), job.id); err != nil {
-		return errors.Wrap(err, "skipping job due to outdated job id")
+	return b.queueJob(ctx, job)
+}
+
+// NewBranchBuild creates a new build for a branch, the build is skipped by the worker if
+// the branch doesn't match the environment branch patterns
+func (b *baseBuilder) NewBranchBuild(ctx context.Context, owner, repository, branch, sha, user string) error {
+	if b.stopped {
+		return ErrWorkerClosed
 	}
 
-	b.jobs <- job
-	b.wg.Add(1)
+	var createTime = time.Now()
 
-	return nil
+	// Branch builds are persistent, pull request policies (on demand, labels and drafts) are ignored
+	job := &job{
+		id:                b.scheduler.getNextJobID(),
+		owner:             owner,
+		repository:        repository,
+		deleteEnvironment: false,
+
+		branch: branch,
+		ref:    sha,
+		user:   user,
+		force:  true,
+
+		createTime: &createTime,
+	}
+
+	return b.queueJob(ctx, job)
 }
 
-// DeleteBuild deletes a build
-func (b *baseBuilder) DeleteBuild(ctx context.Context, owner, repository string, number int64) error {
+// DeleteBranchBuild deletes the build for a branch
+func (b *baseBuilder) DeleteBranchBuild(ctx context.Context, owner, repository, branch string) error {
 	if b.stopped {
 		return ErrWorkerClosed
 	}
@@ -126,79 +267,118 @@ func (b *baseBuilder) DeleteBuild(ctx context.Context, owner, repository string,
 		repository:        repository,
 		deleteEnvironment: true,
 
-		pullRequestNumber: number,
+		branch: branch,
 
 		createTime: &createTime,
 	}
 
+	return b.queueJob(ctx, job)
+}
+
+// JobStatus returns the status of the latest job queued for a pull request
+func (b *baseBuilder) JobStatus(ctx context.Context, owner, repository string, number int64) (*JobStatus, error) {
+	status, ok := b.scheduler.jobStatus((&job{owner: owner, repository: repository, pullRequestNumber: number}).key())
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	return status, nil
+}
+
+// queueJob persists a job for the workers, the job is processed by any operator
+// instance if this instance stops
+func (b *baseBuilder) queueJob(ctx context.Context, j *job) error {
 	// Make sure the job ID is higher than the previous job for this repository
-	if err := b.scheduler.scheduleJob(fmt.Sprintf("%s/%s-%d", owner, repository, number), job.id); err != nil {
+	if err := b.scheduler.scheduleJob(j.key(), j.id); err != nil {
 		return errors.Wrap(err, "skipping job due to outdated job id")
 	}
 
-	b.jobs <- job
-	b.wg.Add(1)
+	// Pull request deployments are created when the job is queued, the worker reports the build
+	// progress on the same deployment. Branch deployments are created once the branch matched.
+	if !j.deleteEnvironment && j.branch == "" {
+		if err := createDeployment(ctx, b.options, j); err != nil {
+			b.logger.WithError(err).Warn("could not create deployment")
+		}
+		postDeploymentStatus(ctx, b.options, j, github.DeploymentQueued, "Build queued") // nolint: gas, errcheck
+	}
+
+	if err := b.queue.add(ctx, j); err != nil {
+		postDeploymentStatus(ctx, b.options, j, github.DeploymentFailure, "Could not queue build") // nolint: gas, errcheck
+		return errors.Wrap(err, "could not queue job")
+	}
+
+	b.scheduler.trackJob(j)
 
 	return nil
 }
 
 func (b *baseBuilder) Start() error {
-	var err error
+	// Wait until this operator instance is allowed to process jobs
+	if b.options.Ready != nil {
+		select {
+		case <-b.options.Ready:
+		case <-b.stop:
+			return nil
+		}
+	}
 
-	// Start background workers
+	// Start background workers, the workers claims jobs until the builder is stopped
 	for id := 1; id <= WorkerPoolSize; id++ {
-		var w *worker
-
-		w, err = newWorker(id, b.logger.WithField("worker_id", id), b.jobs, b.results, b.scheduler, b.options)
+		w, err := newWorker(
+			id,
+			b.logger.WithField("worker_id", id),
+			b.queue,
+			b.results,
+			b.stop,
+			b.scheduler,
+			b.approvals,
+			b.options,
+		)
 		if err != nil {
 			return err
 		}
 
-		go w.run()
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			w.run()
+		}()
 	}
 
 	// Start result collection
 	go func() {
-		for {
-			r, more := <-b.results
+		for r := range b.results {
 			currentTime := time.Now()
 
 			// Track total job execution time
 			b.options.RuntimeSummary.WithLabelValues(
 				r.job.owner,
 				r.job.repository,
-				strconv.FormatInt(r.job.pullRequestNumber, 10),
+				r.job.identifier(),
 				strconv.FormatInt(r.job.id, 10),
 				"total",
 			).Observe(currentTime.Sub(*r.job.createTime).Seconds())
 
-			if more {
-				if r.err != nil {
-					b.logger.WithFields(log.Fields{
-						"job_id":  r.job.id,
-						"runtime": currentTime.Sub(*r.job.createTime),
-						"err":     r.err,
-					}).Error("job failed")
-				} else {
-					b.logger.WithFields(log.Fields{
-						"job_id":  r.job.id,
-						"runtime": currentTime.Sub(*r.job.createTime),
-					}).Info("job succeeded")
-				}
-
-				b.wg.Done()
+			if r.err != nil {
+				b.logger.WithFields(log.Fields{
+					"job_id":  r.job.id,
+					"runtime": currentTime.Sub(*r.job.createTime),
+					"err":     r.err,
+				}).Error("job failed")
 			} else {
-				return
+				b.logger.WithFields(log.Fields{
+					"job_id":  r.job.id,
+					"runtime": currentTime.Sub(*r.job.createTime),
+				}).Info("job succeeded")
 			}
 		}
 	}()
 
-	// Wait on close signal and then all pending builds
+	// Wait on close signal and then the running jobs, queued jobs are kept for the next start
 	<-b.stop
 	b.wg.Wait()
 
-	// Close jobs and results channels, this stops the workers
-	close(b.jobs)
+	// Close results channel, this stops the result collection
 	close(b.results)
 
 	return nil
@@ -210,5 +390,5 @@ func (b *baseBuilder) Stop(err error) {
 	}
 
 	b.stopped = true
-	b.stop <- struct{}{}
+	close(b.stop)
 }
@@ -0,0 +1,150 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kolonialno/pr-deployment-controller/pkg/docker"
+	"github.com/kolonialno/pr-deployment-controller/pkg/github"
+)
+
+// checkRunStep stores an executed build operation
+type checkRunStep struct {
+	description string
+	duration    time.Duration
+	err         error
+}
+
+// checkRun reports the progress of a build job through the GitHub Checks API,
+// each executed operation is added as a step in the check run summary
+type checkRun struct {
+	id   int64
+	lock sync.Mutex
+
+	steps []*checkRunStep
+	err   error
+}
+
+// createCheckRun creates the check run used to report the job progress
+func (w *worker) createCheckRun(ctx context.Context, j *job) error {
+	// Parked jobs resume reporting through the check run created by the first claim
+	if j.checkRunID != 0 {
+		j.checkRun = &checkRun{id: j.checkRunID}
+		return nil
+	}
+
+	id, err := w.options.GitHub.CreateCheckRun(ctx, j.owner, j.repository, j.ref, CheckRunName)
+	if err != nil {
+		return err
+	}
+
+	j.checkRun = &checkRun{id: id}
+	j.checkRunID = id
+
+	// Jobs resumed after an operator failure reports through the same check run
+	if err = w.queue.setCheckRun(ctx, j.request, id); err != nil {
+		w.logger.WithError(err).Warn("could not store the job check run")
+	}
+
+	return nil
+}
+
+// updateCheckRun updates the job check run based on a commit status state
+func (w *worker) updateCheckRun(
+	ctx context.Context, j *job, state github.State, description, url string,
+) error {
+	update := &github.CheckRunUpdate{
+		Status:     github.CheckRunInProgress,
+		DetailsURL: url,
+		Title:      description,
+		Summary:    j.checkRun.summary(),
+	}
+
+	switch {
+	case j.superseded:
+		update.Status = github.CheckRunCompleted
+		update.Conclusion = github.CheckRunCancelled
+	case state == github.SuccessState:
+		update.Status = github.CheckRunCompleted
+		update.Conclusion = github.CheckRunSuccess
+	case state == github.ErrorState || state == github.FailureState:
+		update.Status = github.CheckRunCompleted
+		update.Conclusion = github.CheckRunFailure
+		update.Text = j.checkRun.text()
+	}
+
+	return w.options.GitHub.UpdateCheckRun(ctx, j.owner, j.repository, j.checkRun.id, CheckRunName, update)
+}
+
+// addStep records an executed operation
+func (c *checkRun) addStep(description string, duration time.Duration, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.steps = append(c.steps, &checkRunStep{
+		description: description,
+		duration:    duration,
+		err:         err,
+	})
+}
+
+// fail records the error that stopped the build
+func (c *checkRun) fail(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.err = err
+}
+
+// summary renders the executed steps as a markdown table
+func (c *checkRun) summary() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	lines := []string{
+		"| Step | Duration | Result |",
+		"| --- | --- | --- |",
+	}
+
+	var total time.Duration
+	for _, step := range c.steps {
+		result := "✅"
+		if step.err != nil {
+			result = "❌"
+		}
+		total += step.duration
+
+		lines = append(lines, fmt.Sprintf(
+			"| %s | %s | %s |", step.description, step.duration.Round(time.Millisecond), result,
+		))
+	}
+
+	lines = append(lines, "", fmt.Sprintf("Total time: %s", total.Round(time.Millisecond)))
+
+	return strings.Join(lines, "\n")
+}
+
+// text renders the error details, including the docker build log excerpt if available
+func (c *checkRun) text() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err == nil {
+		return ""
+	}
+
+	text := fmt.Sprintf("**Error:** `%s`", c.err.Error())
+
+	if buildErr, ok := c.err.(*docker.BuildError); ok && len(buildErr.Log) > 0 {
+		text += fmt.Sprintf(
+			"\n\n<details open>\n<summary>Build log (last %d lines)</summary>\n\n```\n%s\n```\n</details>",
+			len(buildErr.Log),
+			strings.Join(buildErr.Log, "\n"),
+		)
+	}
+
+	return text
+}
@@ -0,0 +1,88 @@
+package builder
+
+import (
+	"context"
+
+	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
+	"github.com/kolonialno/pr-deployment-controller/pkg/comment"
+	buildcontroller "github.com/kolonialno/pr-deployment-controller/pkg/controller/build"
+	"github.com/kolonialno/pr-deployment-controller/pkg/k8s"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// updateEnvironmentComment updates the environment comment on the pull request, the last
+// deploy time and claimed database is read from the existing build manifest
+func (w *worker) updateEnvironmentComment(
+	ctx context.Context, j *job, environment *testenvironmentv1alpha1.Environment, state comment.State,
+) error {
+	// Branch builds have no pull request to comment on
+	if j.branch != "" {
+		return nil
+	}
+
+	info := &comment.Info{
+		Owner:             j.owner,
+		Repository:        j.repository,
+		PullRequestNumber: j.pullRequestNumber,
+		Ref:               j.ref,
+		State:             state,
+	}
+
+	build, err := w.getBuildManifest(ctx, j)
+	if err != nil {
+		return err
+	}
+
+	if build != nil {
+		if build.Spec.DeployedAt != nil {
+			info.DeployedAt = &build.Spec.DeployedAt.Time
+		}
+
+		if info.Database, err = claimedDatabase(ctx, w.options.K8s, environment, build.Name); err != nil {
+			return err
+		}
+	}
+
+	body, err := comment.Render(environment, info, w.options.BuildPrefix, w.options.ClusterDomain)
+	if err != nil {
+		return err
+	}
+
+	return w.options.SCM.UpsertPRComment(
+		ctx,
+		j.owner,
+		j.repository,
+		j.pullRequestNumber,
+		comment.Marker,
+		body,
+	)
+}
+
+// claimedDatabase returns the name of the database claimed by a build, empty if no database is claimed
+func claimedDatabase(
+	ctx context.Context,
+	k8sEnv *k8s.Environment,
+	environment *testenvironmentv1alpha1.Environment,
+	buildName string,
+) (string, error) {
+	if environment.Spec.DatabaseTemplate == nil || *environment.Spec.DatabaseTemplate == "" {
+		return "", nil
+	}
+
+	labelSelector, err := buildcontroller.NewBuildDatabaseLabelSelector(*environment.Spec.DatabaseTemplate, buildName)
+	if err != nil {
+		return "", err
+	}
+
+	databases := &testenvironmentv1alpha1.DatabaseList{}
+	err = k8sEnv.List(
+		ctx,
+		&client.ListOptions{Namespace: k8sEnv.Namespace, LabelSelector: labelSelector},
+		databases,
+	)
+	if err != nil || len(databases.Items) == 0 {
+		return "", err
+	}
+
+	return databases.Items[0].Status.DatabaseName, nil
+}
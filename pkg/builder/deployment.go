@@ -0,0 +1,72 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kolonialno/pr-deployment-controller/pkg/github"
+	"github.com/kolonialno/pr-deployment-controller/pkg/internal"
+)
+
+// createDeployment creates the GitHub deployment used to track the job environment, noop
+// for other providers
+func createDeployment(ctx context.Context, options *Options, j *job) error {
+	if options.GitHub == nil {
+		return nil
+	}
+
+	deploymentID, err := options.GitHub.CreateDeployment(
+		ctx,
+		j.owner,
+		j.repository,
+		j.ref,
+		deploymentEnvironment(j),
+		"test-environment",
+		j.branch == "",
+	)
+	if err != nil {
+		return err
+	}
+
+	j.deploymentID = deploymentID
+
+	return nil
+}
+
+// postDeploymentStatus updates the state of the job deployment, noop if the job has no deployment
+func postDeploymentStatus(
+	ctx context.Context, options *Options, j *job, state github.DeploymentState, description string,
+) error {
+	if j.deploymentID == 0 {
+		return nil
+	}
+
+	return options.GitHub.CreateDeploymentStatus(
+		ctx,
+		j.owner,
+		j.repository,
+		j.deploymentID,
+		state,
+		description,
+		fmt.Sprintf("https://%s", internal.GenerateBuildURL(
+			j.owner, j.repository, j.identifier(), options.ClusterDomain,
+		)),
+	)
+}
+
+// updateDeploymentStatus updates the state of the job deployment from the worker
+func (w *worker) updateDeploymentStatus(
+	ctx context.Context, j *job, state github.DeploymentState, description string,
+) error {
+	return postDeploymentStatus(ctx, w.options, j, state, description)
+}
+
+// deploymentEnvironment returns the GitHub environment name used by a job, the branch name
+// is used for branch builds
+func deploymentEnvironment(j *job) string {
+	if j.branch != "" {
+		return j.branch
+	}
+
+	return fmt.Sprintf("pr-%d", j.pullRequestNumber)
+}
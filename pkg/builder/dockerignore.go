@@ -0,0 +1,149 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// dockerIgnoreFile is the file listing the paths excluded from the build context
+const dockerIgnoreFile = ".dockerignore"
+
+// ignorePattern is a compiled .dockerignore line
+type ignorePattern struct {
+	exclusion bool
+	dirs      int // Number of path components
+	re        *regexp.Regexp
+}
+
+// dockerignore filters build context paths with the docker .dockerignore semantics, the
+// last matching pattern decides if a path is excluded
+type dockerignore []*ignorePattern
+
+// parseDockerignore parses the content of a .dockerignore file
+func parseDockerignore(content []byte) (dockerignore, error) {
+	var patterns dockerignore
+
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		pattern := &ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			pattern.exclusion = true
+			line = strings.TrimSpace(line[1:])
+		}
+
+		// Patterns are relative to the context root
+		line = path.Clean(line)
+		if len(line) > 1 && line[0] == '/' {
+			line = line[1:]
+		}
+
+		re, err := compileIgnorePattern(line)
+		if err != nil {
+			return nil, err
+		}
+		pattern.re = re
+		pattern.dirs = len(strings.Split(line, "/"))
+
+		patterns = append(patterns, pattern)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return patterns, nil
+}
+
+// excluded returns true if the path should be left out of the build context
+func (d dockerignore) excluded(name string) bool {
+	name = path.Clean(strings.TrimSuffix(name, "/"))
+
+	excluded := false
+	for _, pattern := range d {
+		// Exceptions only applies to excluded paths, and exclusions to included paths
+		if pattern.exclusion != excluded {
+			continue
+		}
+
+		if pattern.matches(name) {
+			excluded = !pattern.exclusion
+		}
+	}
+
+	return excluded
+}
+
+// matches returns true if the pattern matches the path or one of its parent folders
+func (p *ignorePattern) matches(name string) bool {
+	if p.re.MatchString(name) {
+		return true
+	}
+
+	parts := strings.Split(name, "/")
+	if len(parts) > p.dirs {
+		return p.re.MatchString(strings.Join(parts[:p.dirs], "/"))
+	}
+
+	return false
+}
+
+// compileIgnorePattern converts a .dockerignore pattern to a regular expression, * and ?
+// doesn't match separators while ** matches any number of folders
+func compileIgnorePattern(pattern string) (*regexp.Regexp, error) {
+	expr := "^"
+	inClass := false
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		ch := runes[i]
+
+		switch {
+		case inClass:
+			if ch == ']' {
+				inClass = false
+			}
+			expr += string(ch)
+		case ch == '[':
+			inClass = true
+			expr += string(ch)
+		case ch == '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				i++
+				// Treat **/ as **
+				if i+1 < len(runes) && runes[i+1] == '/' {
+					i++
+				}
+				if i+1 == len(runes) {
+					expr += ".*"
+				} else {
+					expr += "(.*/)?"
+				}
+			} else {
+				expr += "[^/]*"
+			}
+		case ch == '?':
+			expr += "[^/]"
+		case ch == '\\':
+			if i+1 == len(runes) {
+				return nil, path.ErrBadPattern
+			}
+			i++
+			expr += regexp.QuoteMeta(string(runes[i]))
+		default:
+			expr += regexp.QuoteMeta(string(ch))
+		}
+	}
+
+	return regexp.Compile(expr + "$")
+}
@@ -0,0 +1,38 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDockerignore(t *testing.T) {
+	ignore, err := parseDockerignore([]byte(`
+# Documentation
+docs
+*.md
+!README.md
+**/*_test.go
+/fixtures/**
+!fixtures/keep
+`))
+	assert.NoError(t, err)
+
+	for name, excluded := range map[string]bool{
+		"docs/":                 true,
+		"docs/index.html":       true,
+		"CHANGELOG.md":          true,
+		"README.md":             false,
+		"pkg/README.md":         false,
+		"main_test.go":          true,
+		"pkg/builder/x_test.go": true,
+		"fixtures/data.json":    true,
+		"fixtures/keep":         false,
+		"main.go":               false,
+	} {
+		assert.Equal(t, excluded, ignore.excluded(name), name)
+	}
+
+	_, err = parseDockerignore([]byte(`foo\`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,277 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
+	"github.com/kolonialno/pr-deployment-controller/pkg/docker"
+	log "github.com/sirupsen/logrus"
+)
+
+// validImageName matches the names allowed for named images, the name is appended to the image tag
+var validImageName = regexp.MustCompile("^[a-z0-9]([-a-z0-9]*[a-z0-9])?$")
+
+// externalImageName returns the image external CI is expected to push for the job
+func (w *worker) externalImageName(spec *testenvironmentv1alpha1.ExternalImageSpec, j *job) string {
+	if spec.Repository != "" {
+		return fmt.Sprintf("%s:%s", spec.Repository, j.ref)
+	}
+
+	return w.options.Docker.ImageName(j.owner, j.repository, j.ref)
+}
+
+// externalImage checks if the image built by external CI is pushed, returning the image to deploy.
+// The image reported by external CI is used if it references the expected image, optionally pinned
+// to a digest. ErrExternalImageTimeout is returned when the job has waited too long.
+func (w *worker) externalImage(
+	ctx context.Context, spec *testenvironmentv1alpha1.ExternalImageSpec, j *job, image string,
+	output io.Writer, now time.Time,
+) (string, bool, error) {
+	if j.reportedImage != "" {
+		if validReportedImage(j.reportedImage, image) {
+			return j.reportedImage, true, nil
+		}
+
+		w.logger.WithFields(log.Fields{
+			"image":    j.reportedImage,
+			"expected": image,
+		}).Warn("ignoring reported image not matching the expected image")
+		if output != nil {
+			fmt.Fprintf(output, "Ignoring reported image %s, expected %s\n", j.reportedImage, image) // nolint: gas, errcheck
+		}
+	}
+
+	exists, err := w.options.Docker.ImageExists(ctx, image)
+	if err != nil {
+		w.logger.WithError(err).WithField("image", image).Warn("could not check registry for image")
+	} else if exists {
+		return image, true, nil
+	}
+
+	timeout := ExternalImageTimeout
+	if spec.TimeoutSeconds > 0 {
+		timeout = time.Duration(spec.TimeoutSeconds) * time.Second
+	}
+	if j.waitingSince != nil && now.Sub(*j.waitingSince) > timeout {
+		return image, false, ErrExternalImageTimeout
+	}
+
+	return image, false, nil
+}
+
+// validReportedImage returns true if the reported image is the expected image, either by tag or
+// by the tag pinned to a digest
+func validReportedImage(reported, expected string) bool {
+	return reported == expected || strings.HasPrefix(reported, expected+"@sha256:")
+}
+
+// imageBuild is an image built and pushed by a job
+type imageBuild struct {
+	name       string // Named image, empty for the build image
+	options    *docker.BuildOptions
+	contextDir string
+	archive    io.ReadCloser // Repository archive, set when the repository is cloned
+}
+
+// imageBuilds returns the images built by the job, the build image is left out when it's built
+// by external CI. Named images are tagged with the image name appended to the build image tag.
+func imageBuilds(
+	environment *testenvironmentv1alpha1.Environment, j *job, image string,
+) ([]*imageBuild, error) {
+	var builds []*imageBuild
+
+	if environment.Spec.ExternalImage == nil {
+		options, contextDir, err := imageBuildOptions(environment.Spec.ImageBuild, j, image)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, &imageBuild{options: options, contextDir: contextDir})
+	}
+
+	names := map[string]bool{}
+	for i := range environment.Spec.Images {
+		spec := &environment.Spec.Images[i]
+		if !validImageName.MatchString(spec.Name) || names[spec.Name] {
+			return nil, fmt.Errorf("%v: %s", ErrInvalidImageName, spec.Name)
+		}
+		names[spec.Name] = true
+
+		options, contextDir, err := imageBuildOptions(
+			&spec.ImageBuildSpec, j, fmt.Sprintf("%s-%s", image, spec.Name),
+		)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, &imageBuild{name: spec.Name, options: options, contextDir: contextDir})
+	}
+
+	// Containers and tasks can only run the configured images
+	for _, container := range environment.Spec.Containers {
+		if container.Image != "" && !names[container.Image] {
+			return nil, fmt.Errorf("%v: %s", ErrUnknownImage, container.Image)
+		}
+	}
+	for _, task := range environment.Spec.Tasks {
+		if task.Image != "" && !names[task.Image] {
+			return nil, fmt.Errorf("%v: %s", ErrUnknownImage, task.Image)
+		}
+	}
+
+	return builds, nil
+}
+
+// namedImages returns the named image references keyed by the image name
+func namedImages(builds []*imageBuild) map[string]string {
+	var images map[string]string
+	for _, build := range builds {
+		if build.name == "" {
+			continue
+		}
+		if images == nil {
+			images = map[string]string{}
+		}
+		images[build.name] = build.options.Image
+	}
+
+	return images
+}
+
+// missingImages returns the image builds not found in the registry, images already pushed for
+// the commit (rebuilds and clean builds) are reused. Images are built if the registry lookup fails.
+func (w *worker) missingImages(ctx context.Context, builds []*imageBuild, output io.Writer) []*imageBuild {
+	var missing []*imageBuild
+	for _, build := range builds {
+		exists, err := w.options.Docker.ImageExists(ctx, build.options.Image)
+		if err != nil {
+			w.logger.WithError(err).WithField("image", build.options.Image).Warn("could not check registry for image")
+		} else if exists {
+			if output != nil {
+				fmt.Fprintf(output, "Reusing %s\n", build.options.Image) // nolint: gas, errcheck
+			}
+			continue
+		}
+
+		missing = append(missing, build)
+	}
+
+	return missing
+}
+
+// addPreviousImages adds the images of the previous build as the first build cache source
+func addPreviousImages(builds []*imageBuild, previous *testenvironmentv1alpha1.Build) {
+	if previous == nil {
+		return
+	}
+
+	for _, build := range builds {
+		image := previous.Spec.Image
+		if build.name != "" {
+			image = previous.Spec.Images[build.name]
+		}
+
+		if image != "" && image != build.options.Image {
+			build.options.CacheFrom = append([]string{image}, build.options.CacheFrom...)
+		}
+	}
+}
+
+// buildImage builds an image from its repository archive, the output is prefixed with the image
+// name when several images are built in parallel
+func (w *worker) buildImage(ctx context.Context, build *imageBuild, output io.Writer, parallel bool) error {
+	if output != nil && parallel {
+		name := build.name
+		if name == "" {
+			name = "build"
+		}
+
+		prefixed := newPrefixWriter(output, fmt.Sprintf("[%s] ", name))
+		defer prefixed.flush() // nolint: gas, errcheck
+		output = prefixed
+	}
+
+	buildContext := newBuildContext(
+		build.options.Dockerfile, build.contextDir, build.archive, w.options.MaxBuildContextSize,
+	)
+	return buildContext.result(w.options.Docker.BuildImage(ctx, buildContext, build.options, output))
+}
+
+// eachImage calls f for the image builds in parallel, the first error is returned and cancels
+// the context of the remaining calls
+func eachImage(ctx context.Context, builds []*imageBuild, f func(context.Context, *imageBuild) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(builds))
+	for _, build := range builds {
+		go func(build *imageBuild) {
+			err := f(ctx, build)
+			// The error is sent before the cancel, making sure it's received before the
+			// errors caused by the cancel
+			errs <- err
+			if err != nil {
+				cancel()
+			}
+		}(build)
+	}
+
+	var err error
+	for range builds {
+		if buildErr := <-errs; buildErr != nil && err == nil {
+			err = buildErr
+		}
+	}
+
+	return err
+}
+
+// prefixWriter prefixes the lines written to the underlying writer, used to tell apart the
+// output of images built in parallel. Partial lines are held until completed or flushed.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  []byte
+	partial []byte
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		if err := p.writeLine(p.partial[:i+1]); err != nil {
+			return 0, err
+		}
+		p.partial = p.partial[i+1:]
+	}
+
+	return len(b), nil
+}
+
+// flush writes the partial line held by the writer
+func (p *prefixWriter) flush() error {
+	if len(p.partial) == 0 {
+		return nil
+	}
+
+	line := append(p.partial, '\n')
+	p.partial = nil
+
+	return p.writeLine(line)
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	_, err := p.w.Write(append(append([]byte{}, p.prefix...), line...))
+	return err
+}
@@ -0,0 +1,83 @@
+package builder
+
+import (
+	"bytes"
+	"testing"
+
+	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
+	"github.com/kolonialno/pr-deployment-controller/pkg/docker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidReportedImage(t *testing.T) {
+	expected := "registry/owner/repo:abc"
+	assert.True(t, validReportedImage(expected, expected))
+	assert.True(t, validReportedImage(expected+"@sha256:123", expected))
+	assert.False(t, validReportedImage("registry/owner/other:abc", expected))
+	assert.False(t, validReportedImage("registry/owner/repo:def", expected))
+	assert.False(t, validReportedImage("registry/owner/repo:abcdef", expected))
+}
+
+func TestImageBuilds(t *testing.T) {
+	environment := &testenvironmentv1alpha1.Environment{}
+	environment.Spec.Images = []testenvironmentv1alpha1.NamedImageSpec{
+		{Name: "frontend", ImageBuildSpec: testenvironmentv1alpha1.ImageBuildSpec{Context: "frontend"}},
+	}
+	environment.Spec.Containers = []testenvironmentv1alpha1.ContainerSpec{{Name: "web", Image: "frontend"}}
+	j := &job{owner: "kolonialno", repository: "test", ref: "abc"}
+
+	builds, err := imageBuilds(environment, j, "registry/kolonialno/test:abc")
+	assert.NoError(t, err)
+	assert.Len(t, builds, 2)
+	assert.Equal(t, "frontend", builds[1].contextDir)
+	assert.Equal(t, map[string]string{"frontend": "registry/kolonialno/test:abc-frontend"}, namedImages(builds))
+
+	// The build image is left out when it's built by external CI
+	environment.Spec.ExternalImage = &testenvironmentv1alpha1.ExternalImageSpec{}
+	builds, err = imageBuilds(environment, j, "registry/kolonialno/test:abc")
+	assert.NoError(t, err)
+	assert.Len(t, builds, 1)
+
+	environment.Spec.Tasks = []testenvironmentv1alpha1.TaskSpec{{Name: "migrate", Image: "api"}}
+	_, err = imageBuilds(environment, j, "registry/kolonialno/test:abc")
+	assert.Error(t, err)
+
+	environment.Spec.Images[0].Name = "Frontend"
+	_, err = imageBuilds(environment, j, "registry/kolonialno/test:abc")
+	assert.Error(t, err)
+}
+
+func TestPrefixWriter(t *testing.T) {
+	buff := &bytes.Buffer{}
+	w := newPrefixWriter(buff, "[api] ")
+
+	_, err := w.Write([]byte("Step 1/2\nStep"))
+	assert.NoError(t, err)
+	assert.Equal(t, "[api] Step 1/2\n", buff.String())
+
+	_, err = w.Write([]byte(" 2/2"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.flush())
+	assert.Equal(t, "[api] Step 1/2\n[api] Step 2/2\n", buff.String())
+}
+
+func TestAddPreviousImages(t *testing.T) {
+	builds := []*imageBuild{
+		{options: &docker.BuildOptions{
+			Image:     "registry/kolonialno/test:def",
+			CacheFrom: []string{"registry/kolonialno/test:master"},
+		}},
+		{name: "frontend", options: &docker.BuildOptions{Image: "registry/kolonialno/test:def-frontend"}},
+		{name: "api", options: &docker.BuildOptions{Image: "registry/kolonialno/test:def-api"}},
+	}
+	previous := &testenvironmentv1alpha1.Build{}
+	previous.Spec.Image = "registry/kolonialno/test:abc"
+	previous.Spec.Images = map[string]string{"frontend": "registry/kolonialno/test:abc-frontend"}
+
+	addPreviousImages(builds, previous)
+	assert.Equal(
+		t, []string{"registry/kolonialno/test:abc", "registry/kolonialno/test:master"}, builds[0].options.CacheFrom,
+	)
+	assert.Equal(t, []string{"registry/kolonialno/test:abc-frontend"}, builds[1].options.CacheFrom)
+	assert.Empty(t, builds[2].options.CacheFrom)
+}
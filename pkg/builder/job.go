@@ -1,23 +1,72 @@
 package builder
 
-import "time"
+import (
+	"fmt"
+	"time"
+
+	"github.com/kolonialno/pr-deployment-controller/pkg/internal"
+)
 
 // nolint: maligned
 type job struct {
 	id                int64
+	request           string // Name of the BuildRequest persisting the job
 	owner             string
 	repository        string
 	deleteEnvironment bool
+	// conditional deletions are skipped if the PR still is allowed to be deployed
+	conditional bool
 
 	pullRequestNumber int64
+	branch            string
 	ref               string
 	user              string
+	author            string
+	fork              bool
+	labels            []string
+	draft             bool
 	firstRun          bool
 	clean             bool
 	force             bool
 
 	createTime *time.Time
 	startTime  *time.Time
+
+	// checkRun is set when the job reports through the GitHub Checks API
+	checkRun *checkRun
+	// deploymentID references the GitHub deployment tracking the job
+	deploymentID int64
+	// superseded is set when the job is cancelled by a newer job for the build
+	superseded bool
+	// leaseLost is closed when another operator instance resumed the job
+	leaseLost chan struct{}
+	// abandoned is set when the job repeatedly stopped the operator, the job is only reported
+	abandoned bool
+
+	// State persisted by jobs parked while waiting for an image built by external CI
+	waitingSince  *time.Time
+	reportedImage string
+	checkRunID    int64
+}
+
+// lost returns true if another operator instance resumed the job
+func (j *job) lost() bool {
+	select {
+	case <-j.leaseLost:
+		return true
+	default:
+		return false
+	}
+}
+
+// identifier returns the value identifying the build inside the repository
+func (j *job) identifier() string {
+	return internal.BuildIdentifier(j.pullRequestNumber, j.branch)
+}
+
+// key returns the key used to order jobs for the same build
+func (j *job) key() string {
+	return fmt.Sprintf("%s/%s-%s", j.owner, j.repository, j.identifier())
 }
 
 type jobResult struct {
@@ -0,0 +1,26 @@
+package builder
+
+import "fmt"
+
+// labelsAllowDeploy checks the PR labels against the environment label rules,
+// the reason is returned if the PR shouldn't be deployed
+func labelsAllowDeploy(deployLabel, excludeLabel string, labels []string) (bool, string) {
+	hasLabel := func(label string) bool {
+		for _, l := range labels {
+			if l == label {
+				return true
+			}
+		}
+		return false
+	}
+
+	if excludeLabel != "" && hasLabel(excludeLabel) {
+		return false, fmt.Sprintf("PR labeled %s", excludeLabel)
+	}
+
+	if deployLabel != "" && !hasLabel(deployLabel) {
+		return false, fmt.Sprintf("PR not labeled %s", deployLabel)
+	}
+
+	return true, ""
+}
@@ -0,0 +1,23 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelsAllowDeploy(t *testing.T) {
+	allowed, _ := labelsAllowDeploy("", "", nil)
+	assert.True(t, allowed)
+
+	allowed, _ = labelsAllowDeploy("deploy-preview", "", []string{"bug", "deploy-preview"})
+	assert.True(t, allowed)
+
+	allowed, reason := labelsAllowDeploy("deploy-preview", "", []string{"bug"})
+	assert.False(t, allowed)
+	assert.Equal(t, "PR not labeled deploy-preview", reason)
+
+	allowed, reason = labelsAllowDeploy("deploy-preview", "no-preview", []string{"deploy-preview", "no-preview"})
+	assert.False(t, allowed)
+	assert.Equal(t, "PR labeled no-preview", reason)
+}
@@ -6,6 +6,8 @@ import (
 	"reflect"
 
 	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
+	"github.com/kolonialno/pr-deployment-controller/pkg/internal"
+	"github.com/kolonialno/pr-deployment-controller/pkg/k8s"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -27,6 +29,23 @@ func (w *worker) getEnvironmentManifest(
 	return environment, err
 }
 
+// DeployLabel returns the label required to deploy the repository, empty if the environment
+// doesn't exist or doesn't require a label
+func (b *baseBuilder) DeployLabel(ctx context.Context, owner, repository string) (string, error) {
+	environment := &testenvironmentv1alpha1.Environment{}
+	err := b.options.K8s.Get(ctx, types.NamespacedName{
+		Name:      environmentName(owner, repository),
+		Namespace: b.options.K8s.Namespace,
+	}, environment)
+	if errors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return environment.Spec.DeployLabel, nil
+}
+
 // buildExists returns true if the build already exists in the cluster
 func (w *worker) buildExists(ctx context.Context, j *job) (bool, error) {
 	found := &testenvironmentv1alpha1.Build{}
@@ -34,7 +53,7 @@ func (w *worker) buildExists(ctx context.Context, j *job) (bool, error) {
 	err := w.options.K8s.Get(
 		ctx,
 		types.NamespacedName{
-			Name:      environmentBuildName(j.owner, j.repository, j.pullRequestNumber),
+			Name:      internal.GenerateBuildName(j.owner, j.repository, j.identifier()),
 			Namespace: w.options.K8s.Namespace,
 		},
 		found,
@@ -48,26 +67,39 @@ func (w *worker) buildExists(ctx context.Context, j *job) (bool, error) {
 	return true, nil
 }
 
+// getBuildManifest returns the existing build manifest, nil if not found
+func (w *worker) getBuildManifest(ctx context.Context, j *job) (*testenvironmentv1alpha1.Build, error) {
+	return lookupBuildManifest(ctx, w.options.K8s, j.owner, j.repository, j.identifier())
+}
+
 // create a build manifest, used to create/update a test environment
 func (w *worker) createBuildManifest(
 	ctx context.Context,
 	env string,
 	j *job,
 	imageName string,
+	images map[string]string,
 ) error {
+	deployedAt := metav1.Now()
+
 	build := &testenvironmentv1alpha1.Build{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      environmentBuildName(j.owner, j.repository, j.pullRequestNumber),
+			Name:      internal.GenerateBuildName(j.owner, j.repository, j.identifier()),
 			Namespace: w.options.K8s.Namespace,
 		},
 		Spec: testenvironmentv1alpha1.BuildSpec{
-			Environment: env,
-			Image:       imageName,
+			Environment:  env,
+			Image:        imageName,
+			Images:       images,
+			DeploymentID: j.deploymentID,
+			DeployedAt:   &deployedAt,
 			Git: &testenvironmentv1alpha1.GitSpec{
 				Owner:             j.owner,
 				Repository:        j.repository,
 				Ref:               j.ref,
 				PullRequestNumber: j.pullRequestNumber,
+				Branch:            j.branch,
+				Provider:          w.options.SCM.Name(),
 			},
 		},
 	}
@@ -97,7 +129,7 @@ func (w *worker) createBuildManifest(
 func (w *worker) deleteBuildManifest(ctx context.Context, j *job) error {
 	err := w.options.K8s.Delete(ctx, &testenvironmentv1alpha1.Build{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      environmentBuildName(j.owner, j.repository, j.pullRequestNumber),
+			Name:      internal.GenerateBuildName(j.owner, j.repository, j.identifier()),
 			Namespace: w.options.K8s.Namespace,
 		},
 	})
@@ -108,12 +140,34 @@ func (w *worker) deleteBuildManifest(ctx context.Context, j *job) error {
 	return err
 }
 
-// Get the environment name based on the git owner/repository values
-func environmentName(owner, repository string) string {
-	return fmt.Sprintf("%s-%s", owner, repository)
+// lookupBuildManifest returns the build manifest for a build identifier, nil if not found
+func lookupBuildManifest(
+	ctx context.Context,
+	k8sEnv *k8s.Environment,
+	owner,
+	repository,
+	identifier string,
+) (*testenvironmentv1alpha1.Build, error) {
+	found := &testenvironmentv1alpha1.Build{}
+
+	err := k8sEnv.Get(
+		ctx,
+		types.NamespacedName{
+			Name:      internal.GenerateBuildName(owner, repository, identifier),
+			Namespace: k8sEnv.Namespace,
+		},
+		found,
+	)
+	if err != nil && errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return found, nil
 }
 
-// Get the build name based on the git owner/repository values
-func environmentBuildName(owner, repository string, pullRequestNumber int64) string {
-	return fmt.Sprintf("%s-%s-%d", owner, repository, pullRequestNumber)
+// Get the environment name based on the git owner/repository values
+func environmentName(owner, repository string) string {
+	return fmt.Sprintf("%s-%s", internal.OwnerName(owner), repository)
 }
@@ -0,0 +1,345 @@
+package builder
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
+	"github.com/kolonialno/pr-deployment-controller/pkg/k8s"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups=testenvironment.kolonial.no,resources=buildrequests,verbs=get;list;watch;create;update;delete
+
+// jobQueue persists the build jobs as BuildRequest resources. Workers claim the requests
+// with a lease that is renewed while the job runs, requests held by a stopped operator
+// instance are resumed by another worker when the lease expires.
+type jobQueue struct {
+	logger   *log.Entry
+	k8s      *k8s.Environment
+	provider string
+	identity string
+
+	// lock serialises the claims made by the local workers
+	lock sync.Mutex
+	// wakeup signals the local workers when a job is queued
+	wakeup chan struct{}
+}
+
+// newJobQueue returns a queue for the jobs of a source control provider
+func newJobQueue(logger *log.Entry, k8sEnv *k8s.Environment, provider, identity string) *jobQueue {
+	return &jobQueue{
+		logger:   logger,
+		k8s:      k8sEnv,
+		provider: provider,
+		identity: identity,
+
+		wakeup: make(chan struct{}, 1),
+	}
+}
+
+// add persists a job and wakes a local worker
+func (q *jobQueue) add(ctx context.Context, j *job) error {
+	request := &testenvironmentv1alpha1.BuildRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "build-request-",
+			Namespace:    q.k8s.Namespace,
+			Labels:       map[string]string{LabelProvider: q.provider},
+		},
+		Spec: requestSpec(q.provider, j),
+	}
+
+	if err := q.k8s.Create(ctx, request); err != nil {
+		return err
+	}
+	j.request = request.Name
+
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+		// A wakeup is already pending
+	}
+
+	return nil
+}
+
+// claim leases the oldest claimable job, nil is returned if no job is claimable. The observe
+// function is called with every queued job, used to restore the scheduler after a restart.
+func (q *jobQueue) claim(ctx context.Context, now time.Time, observe func(*job)) (*job, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	list, err := q.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := queuedRequests(list.Items, q.provider)
+	for _, request := range requests {
+		observe(requestJob(request))
+	}
+
+	for _, request := range requests {
+		if !claimable(request, now) {
+			continue
+		}
+
+		// Jobs that repeatedly stopped the operator are claimed to be reported as abandoned
+		abandoned := request.Status.Attempts >= MaxJobAttempts
+
+		request = request.DeepCopy()
+		request.Status.Holder = q.identity
+		request.Status.LeaseExpiresAt = &metav1.Time{Time: now.Add(JobLeaseDuration)}
+		request.Status.Attempts++
+
+		// Conflicts and missing requests means that another worker claimed or finished the job
+		err := q.k8s.Update(ctx, request)
+		if err != nil && (errors.IsConflict(err) || errors.IsNotFound(err)) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		j := requestJob(request)
+		j.abandoned = abandoned
+
+		return j, nil
+	}
+
+	return nil, nil
+}
+
+// park releases a job waiting for an external image, the job is claimed again after the given
+// time or when the image is reported. The claim isn't counted as an attempt.
+func (q *jobQueue) park(ctx context.Context, j *job, now, notBefore time.Time) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		request := &testenvironmentv1alpha1.BuildRequest{}
+		if err := q.k8s.Get(ctx, types.NamespacedName{Name: j.request, Namespace: q.k8s.Namespace}, request); err != nil {
+			return err
+		}
+		if request.Status.Holder != q.identity {
+			return ErrLeaseLost
+		}
+
+		request.Status.Holder = ""
+		request.Status.LeaseExpiresAt = nil
+		request.Status.Attempts--
+		request.Status.NotBefore = &metav1.Time{Time: notBefore}
+		if request.Status.WaitingSince == nil {
+			request.Status.WaitingSince = &metav1.Time{Time: now}
+		}
+		request.Status.CheckRunID = j.checkRunID
+		// The job only parks after rejecting the reported image, newer reports are kept
+		if request.Status.ReportedImage == j.reportedImage {
+			request.Status.ReportedImage = ""
+		}
+
+		return q.k8s.Update(ctx, request)
+	})
+}
+
+// reportImage records the image reported by external CI on the jobs building the commit and
+// makes them claimable right away, an empty image only wakes the jobs
+func (q *jobQueue) reportImage(ctx context.Context, owner, repository, sha, image string) error {
+	list, err := q.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, request := range queuedRequests(list.Items, q.provider) {
+		if request.Spec.Owner != owner || request.Spec.Repository != repository ||
+			request.Spec.Ref != sha || request.Spec.Delete {
+			continue
+		}
+
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			current := &testenvironmentv1alpha1.BuildRequest{}
+			key := types.NamespacedName{Name: request.Name, Namespace: q.k8s.Namespace}
+			if err := q.k8s.Get(ctx, key, current); err != nil {
+				return err
+			}
+
+			current.Status.NotBefore = nil
+			if image != "" {
+				current.Status.ReportedImage = image
+			}
+
+			return q.k8s.Update(ctx, current)
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+		// A wakeup is already pending
+	}
+
+	return nil
+}
+
+// setCheckRun stores the check run of a claimed job, used to report the job if it's resumed
+// by another worker
+func (q *jobQueue) setCheckRun(ctx context.Context, name string, checkRunID int64) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		request := &testenvironmentv1alpha1.BuildRequest{}
+		if err := q.k8s.Get(ctx, types.NamespacedName{Name: name, Namespace: q.k8s.Namespace}, request); err != nil {
+			return err
+		}
+		if request.Status.Holder != q.identity {
+			return ErrLeaseLost
+		}
+
+		request.Status.CheckRunID = checkRunID
+		return q.k8s.Update(ctx, request)
+	})
+}
+
+// renew extends the lease of a claimed job, ErrLeaseLost is returned if another operator
+// instance resumed the job
+func (q *jobQueue) renew(ctx context.Context, name string, now time.Time) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		request := &testenvironmentv1alpha1.BuildRequest{}
+		if err := q.k8s.Get(ctx, types.NamespacedName{Name: name, Namespace: q.k8s.Namespace}, request); err != nil {
+			return err
+		}
+		if request.Status.Holder != q.identity {
+			return ErrLeaseLost
+		}
+
+		request.Status.LeaseExpiresAt = &metav1.Time{Time: now.Add(JobLeaseDuration)}
+		return q.k8s.Update(ctx, request)
+	})
+}
+
+// list returns the requests of the queue provider. The requests are selected by the provider
+// label and served from the shared informer cache of the manager client.
+func (q *jobQueue) list(ctx context.Context) (*testenvironmentv1alpha1.BuildRequestList, error) {
+	list := &testenvironmentv1alpha1.BuildRequestList{}
+	err := q.k8s.List(ctx, &client.ListOptions{
+		Namespace:     q.k8s.Namespace,
+		LabelSelector: labels.SelectorFromSet(labels.Set{LabelProvider: q.provider}),
+	}, list)
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// finish removes a processed job from the queue
+func (q *jobQueue) finish(ctx context.Context, name string) error {
+	err := q.k8s.Delete(ctx, &testenvironmentv1alpha1.BuildRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: q.k8s.Namespace,
+		},
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// queuedRequests returns the requests of a provider, ordered by the job ID
+func queuedRequests(
+	items []testenvironmentv1alpha1.BuildRequest, provider string,
+) []*testenvironmentv1alpha1.BuildRequest {
+	var requests []*testenvironmentv1alpha1.BuildRequest
+	for i := range items {
+		if items[i].Spec.Provider == provider {
+			requests = append(requests, &items[i])
+		}
+	}
+
+	sort.SliceStable(requests, func(i, j int) bool {
+		return requests[i].Spec.ID < requests[j].Spec.ID
+	})
+
+	return requests
+}
+
+// claimable returns true if the request isn't held by a worker or the lease expired, parked
+// requests are claimable when the parking time is over
+func claimable(request *testenvironmentv1alpha1.BuildRequest, now time.Time) bool {
+	if request.Status.NotBefore != nil && request.Status.NotBefore.Time.After(now) {
+		return false
+	}
+
+	return request.Status.Holder == "" ||
+		request.Status.LeaseExpiresAt == nil ||
+		request.Status.LeaseExpiresAt.Time.Before(now)
+}
+
+// requestSpec returns the persisted representation of a job
+func requestSpec(provider string, j *job) testenvironmentv1alpha1.BuildRequestSpec {
+	return testenvironmentv1alpha1.BuildRequestSpec{
+		ID:                j.id,
+		Provider:          provider,
+		Owner:             j.owner,
+		Repository:        j.repository,
+		PullRequestNumber: j.pullRequestNumber,
+		Branch:            j.branch,
+		Ref:               j.ref,
+		User:              j.user,
+		Author:            j.author,
+		Labels:            j.labels,
+		Fork:              j.fork,
+		Draft:             j.draft,
+		FirstRun:          j.firstRun,
+		Clean:             j.clean,
+		Force:             j.force,
+		Delete:            j.deleteEnvironment,
+		Conditional:       j.conditional,
+		DeploymentID:      j.deploymentID,
+	}
+}
+
+// requestJob returns the job persisted in a request
+func requestJob(request *testenvironmentv1alpha1.BuildRequest) *job {
+	createTime := time.Unix(0, request.Spec.ID)
+
+	var waitingSince *time.Time
+	if request.Status.WaitingSince != nil {
+		waitingSince = &request.Status.WaitingSince.Time
+	}
+
+	return &job{
+		id:                request.Spec.ID,
+		request:           request.Name,
+		owner:             request.Spec.Owner,
+		repository:        request.Spec.Repository,
+		deleteEnvironment: request.Spec.Delete,
+		conditional:       request.Spec.Conditional,
+
+		pullRequestNumber: request.Spec.PullRequestNumber,
+		branch:            request.Spec.Branch,
+		ref:               request.Spec.Ref,
+		user:              request.Spec.User,
+		author:            request.Spec.Author,
+		fork:              request.Spec.Fork,
+		labels:            request.Spec.Labels,
+		draft:             request.Spec.Draft,
+		firstRun:          request.Spec.FirstRun,
+		clean:             request.Spec.Clean,
+		force:             request.Spec.Force,
+		deploymentID:      request.Spec.DeploymentID,
+
+		createTime: &createTime,
+
+		waitingSince:  waitingSince,
+		reportedImage: request.Status.ReportedImage,
+		checkRunID:    request.Status.CheckRunID,
+	}
+}
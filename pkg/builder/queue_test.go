@@ -0,0 +1,73 @@
+package builder
+
+import (
+	"testing"
+	"time"
+
+	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestQueuedRequests(t *testing.T) {
+	items := []testenvironmentv1alpha1.BuildRequest{
+		{Spec: testenvironmentv1alpha1.BuildRequestSpec{ID: 3, Provider: "github"}},
+		{Spec: testenvironmentv1alpha1.BuildRequestSpec{ID: 1, Provider: "github"}},
+		{Spec: testenvironmentv1alpha1.BuildRequestSpec{ID: 2, Provider: "gitlab"}},
+	}
+
+	requests := queuedRequests(items, "github")
+	assert.Len(t, requests, 2)
+	assert.Equal(t, int64(1), requests[0].Spec.ID)
+	assert.Equal(t, int64(3), requests[1].Spec.ID)
+}
+
+func TestClaimable(t *testing.T) {
+	now := time.Now()
+	request := &testenvironmentv1alpha1.BuildRequest{}
+	assert.True(t, claimable(request, now), "unclaimed")
+
+	request.Status.Holder = "operator-1"
+	request.Status.LeaseExpiresAt = &metav1.Time{Time: now.Add(time.Minute)}
+	assert.False(t, claimable(request, now), "leased")
+
+	request.Status.LeaseExpiresAt = &metav1.Time{Time: now.Add(-time.Minute)}
+	assert.True(t, claimable(request, now), "lease expired")
+
+	parked := &testenvironmentv1alpha1.BuildRequest{}
+	parked.Status.NotBefore = &metav1.Time{Time: now.Add(time.Minute)}
+	assert.False(t, claimable(parked, now), "parked")
+	assert.True(t, claimable(parked, now.Add(2*time.Minute)), "parking over")
+}
+
+func TestRequestJobRoundTrip(t *testing.T) {
+	createTime := time.Now()
+	j := &job{
+		id:                createTime.UnixNano(),
+		owner:             "kolonialno",
+		repository:        "test",
+		pullRequestNumber: 3,
+		ref:               "abc",
+		user:              "user",
+		author:            "author",
+		labels:            []string{"deploy"},
+		fork:              true,
+		draft:             true,
+		clean:             true,
+		deploymentID:      42,
+		createTime:        &createTime,
+	}
+
+	request := &testenvironmentv1alpha1.BuildRequest{Spec: requestSpec("github", j)}
+	request.Name = "build-request-x"
+	restored := requestJob(request)
+
+	assert.Equal(t, "github", request.Spec.Provider)
+	assert.Equal(t, "build-request-x", restored.request)
+	assert.Equal(t, j.key(), restored.key())
+	assert.Equal(t, j.id, restored.id)
+	assert.Equal(t, j.labels, restored.labels)
+	assert.True(t, restored.fork && restored.draft && restored.clean)
+	assert.Equal(t, int64(42), restored.deploymentID)
+	assert.Equal(t, createTime.UnixNano(), restored.createTime.UnixNano())
+}
@@ -1,33 +1,60 @@
 package builder
 
 import (
+	"context"
 	"sync"
+	"time"
 )
 
+// JobStatus describes the state of the latest job for a build
+type JobStatus struct {
+	ID         int64      `json:"id"`
+	Owner      string     `json:"owner"`
+	Repository string     `json:"repository"`
+	Identifier string     `json:"identifier"` // Pull request number or branch identifier
+	Ref        string     `json:"ref,omitempty"`
+	Delete     bool       `json:"delete"`
+	State      JobState   `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
 // scheduler is responsible for blocking jobs if a newer job already is running
 type scheduler struct {
-	nextJobID int64
+	lastJobID int64
 	lock      *sync.Mutex
 
 	jobs map[string]int64
+	// statuses stores the status of the latest queued job for each build
+	statuses map[string]*JobStatus
+	// running stores the cancel functions of the running build jobs, the jobs are cancelled
+	// when a newer job is queued for the build
+	running map[string]map[int64]context.CancelFunc
 }
 
 func newScheduler() *scheduler {
 	return &scheduler{
-		nextJobID: 1,
-		lock:      &sync.Mutex{},
+		lock: &sync.Mutex{},
 
-		jobs: map[string]int64{},
+		jobs:     map[string]int64{},
+		statuses: map[string]*JobStatus{},
+		running:  map[string]map[int64]context.CancelFunc{},
 	}
 }
 
-// getNextJobID increases the nex jobID value and returns the value
+// getNextJobID returns a job ID based on the current time, the IDs are increasing inside
+// the process and keeps the job order across operator restarts
 func (s *scheduler) getNextJobID() int64 {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	nextJobID := s.nextJobID
-	s.nextJobID = nextJobID + 1
+	nextJobID := time.Now().UnixNano()
+	if nextJobID <= s.lastJobID {
+		nextJobID = s.lastJobID + 1
+	}
+	s.lastJobID = nextJobID
 
 	return nextJobID
 }
@@ -51,6 +78,135 @@ func (s *scheduler) scheduleJob(name string, id int64) error {
 
 	// New id higher than the prevoius id, store id and return
 	s.jobs[name] = id
+	s.supersede(name, id)
 
 	return nil
 }
+
+// supersede cancels the running jobs older than the job ID, the caller holds the lock
+func (s *scheduler) supersede(name string, id int64) {
+	for runningID, cancel := range s.running[name] {
+		if runningID < id {
+			cancel()
+		}
+	}
+}
+
+// outdated returns true if a job with an higher ID is queued for the build
+func (s *scheduler) outdated(name string, id int64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.jobs[name] > id
+}
+
+// trackJob stores the status of a queued job, replacing the status of previous jobs for the build
+func (s *scheduler) trackJob(j *job) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.statuses[j.key()] = newJobStatus(j)
+}
+
+// observeJob restores the order and status of a persisted job, previously queued by this
+// or another operator instance. Statuses of newer jobs are kept.
+func (s *scheduler) observeJob(j *job) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.jobs[j.key()] < j.id {
+		s.jobs[j.key()] = j.id
+		s.supersede(j.key(), j.id)
+	}
+	if status, ok := s.statuses[j.key()]; !ok || status.ID < j.id {
+		s.statuses[j.key()] = newJobStatus(j)
+	}
+}
+
+// newJobStatus returns the status of a queued job
+func newJobStatus(j *job) *JobStatus {
+	return &JobStatus{
+		ID:         j.id,
+		Owner:      j.owner,
+		Repository: j.repository,
+		Identifier: j.identifier(),
+		Ref:        j.ref,
+		Delete:     j.deleteEnvironment,
+		State:      QueuedJob,
+		CreatedAt:  *j.createTime,
+	}
+}
+
+// startJob marks a job as running, the cancel function is called when a newer job is queued
+// for the build. Jobs that already are outdated are cancelled right away.
+func (s *scheduler) startJob(j *job, startTime time.Time, cancel context.CancelFunc) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if cancel != nil {
+		if _, ok := s.running[j.key()]; !ok {
+			s.running[j.key()] = map[int64]context.CancelFunc{}
+		}
+		s.running[j.key()][j.id] = cancel
+
+		if s.jobs[j.key()] > j.id {
+			cancel()
+		}
+	}
+
+	if status, ok := s.statuses[j.key()]; ok && status.ID == j.id {
+		status.State = RunningJob
+		status.StartedAt = &startTime
+	}
+}
+
+// parkJob marks a job as waiting, the job is started again when claimed
+func (s *scheduler) parkJob(j *job) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.running[j.key()], j.id)
+	if len(s.running[j.key()]) == 0 {
+		delete(s.running, j.key())
+	}
+
+	if status, ok := s.statuses[j.key()]; ok && status.ID == j.id {
+		status.State = WaitingJob
+	}
+}
+
+// finishJob stores the result of a job
+func (s *scheduler) finishJob(j *job, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.running[j.key()], j.id)
+	if len(s.running[j.key()]) == 0 {
+		delete(s.running, j.key())
+	}
+
+	if status, ok := s.statuses[j.key()]; ok && status.ID == j.id {
+		finishTime := time.Now()
+
+		status.State = SucceededJob
+		status.FinishedAt = &finishTime
+		if err != nil {
+			status.State = FailedJob
+			status.Error = err.Error()
+		}
+	}
+}
+
+// jobStatus returns a copy of the status of the latest job for a build
+func (s *scheduler) jobStatus(key string) (*JobStatus, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	status, ok := s.statuses[key]
+	if !ok {
+		return nil, false
+	}
+
+	result := *status
+	return &result, true
+}
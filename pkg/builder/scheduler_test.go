@@ -1,27 +1,86 @@
 package builder
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func TestGetNextJobID(t *testing.T) {
+func TestJobStatusTracking(t *testing.T) {
 	s := newScheduler()
+	createTime := time.Now()
 
-	assert.Equal(t, int64(1), s.getNextJobID())
-	assert.Equal(t, int64(2), s.getNextJobID())
-	assert.Equal(t, int64(3), s.getNextJobID())
+	first := &job{id: 1, owner: "kolonialno", repository: "test", pullRequestNumber: 3, createTime: &createTime}
+	second := &job{id: 2, owner: "kolonialno", repository: "test", pullRequestNumber: 3, createTime: &createTime}
 
-	assert.Equal(t, int64(4), s.nextJobID)
+	s.trackJob(first)
+	status, ok := s.jobStatus(first.key())
+	assert.True(t, ok)
+	assert.Equal(t, QueuedJob, status.State)
+
+	// Results of replaced jobs are ignored
+	s.trackJob(second)
+	s.startJob(first, time.Now(), nil)
+	s.finishJob(first, errors.New("outdated"))
+	status, _ = s.jobStatus(second.key())
+	assert.Equal(t, int64(2), status.ID)
+	assert.Equal(t, QueuedJob, status.State)
+
+	s.startJob(second, time.Now(), nil)
+	status, _ = s.jobStatus(second.key())
+	assert.Equal(t, RunningJob, status.State)
+
+	s.finishJob(second, errors.New("no dockerfile"))
+	status, _ = s.jobStatus(second.key())
+	assert.Equal(t, FailedJob, status.State)
+	assert.Equal(t, "no dockerfile", status.Error)
+	assert.NotNil(t, status.FinishedAt)
+
+	_, ok = s.jobStatus("kolonialno/test-4")
+	assert.False(t, ok)
 }
 
-func TestScheduleJob(t *testing.T) {
+func TestObserveJob(t *testing.T) {
 	s := newScheduler()
+	createTime := time.Now()
+
+	newJob := func() *job {
+		return &job{
+			id: s.getNextJobID(), owner: "kolonialno", repository: "test", pullRequestNumber: 3, createTime: &createTime,
+		}
+	}
+	older, newer := newJob(), newJob()
+	assert.True(t, newer.id > older.id)
+
+	// Jobs restored after a restart keeps the order of the persisted IDs
+	s.observeJob(newer)
+	s.observeJob(older)
+	assert.True(t, s.outdated(older.key(), older.id))
+	assert.Equal(t, ErrJobOutdated, s.scheduleJob(older.key(), older.id))
+
+	status, _ := s.jobStatus(newer.key())
+	assert.Equal(t, newer.id, status.ID)
+}
+
+func TestSupersedeRunningJob(t *testing.T) {
+	s := newScheduler()
+	createTime := time.Now()
+
+	older := &job{id: 1, owner: "kolonialno", repository: "test", pullRequestNumber: 3, createTime: &createTime}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s.startJob(older, time.Now(), cancel)
+	assert.NoError(t, s.scheduleJob(older.key(), 1))
+	assert.NoError(t, ctx.Err())
+
+	// Queueing a newer job cancels the running job
+	assert.NoError(t, s.scheduleJob(older.key(), 2))
+	assert.Equal(t, context.Canceled, ctx.Err())
 
-	assert.Nil(t, s.scheduleJob("test", 1))
-	assert.Nil(t, s.scheduleJob("test", 5))
-	assert.Equal(t, ErrJobOutdated, s.scheduleJob("test", 2))
-	assert.Nil(t, s.scheduleJob("test", 6))
-	assert.Nil(t, s.scheduleJob("test", 6))
+	s.finishJob(older, ErrJobSuperseded)
+	assert.Empty(t, s.running)
 }
@@ -0,0 +1,202 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
+	"github.com/kolonialno/pr-deployment-controller/pkg/cleanup"
+	"github.com/kolonialno/pr-deployment-controller/pkg/internal"
+	"github.com/kolonialno/pr-deployment-controller/pkg/scm"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PodSummary describes a pod running in a build environment
+type PodSummary struct {
+	Name     string
+	Phase    string
+	Ready    bool
+	Restarts int32
+}
+
+// BuildSummary describes the state of a build environment
+type BuildSummary struct {
+	Name        string
+	Environment string
+	Image       string
+	Images      map[string]string
+	Ref         string
+	DeployedAt  *time.Time
+	ExpiresAt   time.Time
+
+	Pods     []PodSummary
+	Database string
+	Routes   []string
+}
+
+// BuildInfo describes a build manifest
+type BuildInfo struct {
+	Name              string     `json:"name"`
+	Provider          string     `json:"provider"`
+	Owner             string     `json:"owner"`
+	Repository        string     `json:"repository"`
+	PullRequestNumber int64      `json:"pullRequestNumber,omitempty"`
+	Branch            string     `json:"branch,omitempty"`
+	Ref               string     `json:"ref"`
+	Environment       string     `json:"environment"`
+	DeployedAt        *time.Time `json:"deployedAt,omitempty"`
+	ExpiresAt         time.Time  `json:"expiresAt"`
+}
+
+// ListBuilds returns the build manifests of the builder provider, optionally filtered by owner and repository
+func (b *baseBuilder) ListBuilds(ctx context.Context, owner, repository string) ([]BuildInfo, error) {
+	builds := &testenvironmentv1alpha1.BuildList{}
+	if err := b.options.K8s.List(ctx, &client.ListOptions{Namespace: b.options.K8s.Namespace}, builds); err != nil {
+		return nil, err
+	}
+
+	result := []BuildInfo{}
+	for i := range builds.Items {
+		build := &builds.Items[i]
+		git := build.Spec.Git
+		if git == nil || (owner != "" && git.Owner != owner) || (repository != "" && git.Repository != repository) {
+			continue
+		}
+
+		info := BuildInfo{
+			Name:              build.Name,
+			Provider:          git.Provider,
+			Owner:             git.Owner,
+			Repository:        git.Repository,
+			PullRequestNumber: git.PullRequestNumber,
+			Branch:            git.Branch,
+			Ref:               git.Ref,
+			Environment:       build.Spec.Environment,
+			ExpiresAt:         cleanup.BuildExpiry(build),
+		}
+		if info.Provider == "" {
+			info.Provider = scm.GitHubProvider
+		}
+		if info.Provider != b.options.SCM.Name() {
+			continue
+		}
+		if build.Spec.DeployedAt != nil {
+			info.DeployedAt = &build.Spec.DeployedAt.Time
+		}
+
+		result = append(result, info)
+	}
+
+	return result, nil
+}
+
+// BuildSummary returns a summary of the build deployed for a pull request
+func (b *baseBuilder) BuildSummary(
+	ctx context.Context, owner, repository string, number int64,
+) (*BuildSummary, error) {
+	build, err := lookupBuildManifest(
+		ctx, b.options.K8s, owner, repository, internal.BuildIdentifier(number, ""),
+	)
+	if err != nil {
+		return nil, err
+	} else if build == nil {
+		return nil, ErrBuildNotFound
+	}
+
+	environment := &testenvironmentv1alpha1.Environment{}
+	if err = b.options.K8s.Get(ctx, types.NamespacedName{
+		Name:      build.Spec.Environment,
+		Namespace: b.options.K8s.Namespace,
+	}, environment); err != nil {
+		return nil, err
+	}
+
+	summary := &BuildSummary{
+		Name:        build.Name,
+		Environment: build.Spec.Environment,
+		Image:       build.Spec.Image,
+		Images:      build.Spec.Images,
+		Ref:         build.Spec.Git.Ref,
+		ExpiresAt:   cleanup.BuildExpiry(build),
+	}
+	if build.Spec.DeployedAt != nil {
+		summary.DeployedAt = &build.Spec.DeployedAt.Time
+	}
+
+	// Pods running in the build namespace
+	pods := &corev1.PodList{}
+	if err = b.options.K8s.List(
+		ctx,
+		&client.ListOptions{Namespace: fmt.Sprintf("%s%s", b.options.BuildPrefix, build.Name)},
+		pods,
+	); err != nil {
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		podSummary := PodSummary{
+			Name:  pod.Name,
+			Phase: string(pod.Status.Phase),
+			Ready: len(pod.Status.ContainerStatuses) > 0,
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			podSummary.Ready = podSummary.Ready && status.Ready
+			podSummary.Restarts += status.RestartCount
+		}
+		summary.Pods = append(summary.Pods, podSummary)
+	}
+
+	// Claimed database
+	if summary.Database, err = claimedDatabase(ctx, b.options.K8s, environment, build.Name); err != nil {
+		return nil, err
+	}
+
+	// Routes exposed by the environment
+	buildURL := internal.GenerateBuildURL(
+		owner, repository, internal.BuildIdentifier(number, ""), b.options.ClusterDomain,
+	)
+	for _, route := range environment.Spec.Routing {
+		summary.Routes = append(summary.Routes, fmt.Sprintf(
+			"https://%s%s -> %s:%d", buildURL, route.URLPrefix, route.ContainerName, route.Port,
+		))
+	}
+
+	return summary, nil
+}
+
+// ExtendBuild postpones the removal of the build deployed for a pull request, the
+// new expiry time is returned
+func (b *baseBuilder) ExtendBuild(
+	ctx context.Context, owner, repository string, number int64, duration time.Duration,
+) (time.Time, error) {
+	build, err := lookupBuildManifest(
+		ctx, b.options.K8s, owner, repository, internal.BuildIdentifier(number, ""),
+	)
+	if err != nil {
+		return time.Time{}, err
+	} else if build == nil {
+		return time.Time{}, ErrBuildNotFound
+	}
+
+	now := time.Now()
+
+	// Extend from the current expiry time, or from now if the build already is expired
+	expiry := cleanup.BuildExpiry(build)
+	if expiry.Before(now) {
+		expiry = now
+	}
+	expiry = expiry.Add(duration)
+
+	if latest := now.Add(MaxBuildExtension); expiry.After(latest) {
+		expiry = latest
+	}
+
+	if build.Annotations == nil {
+		build.Annotations = map[string]string{}
+	}
+	build.Annotations[testenvironmentv1alpha1.ExpiresAnnotation] = expiry.UTC().Format(time.RFC3339)
+
+	return expiry, b.options.K8s.Update(ctx, build)
+}
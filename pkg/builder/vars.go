@@ -1,42 +1,93 @@
 package builder
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 const (
 	// WorkerPoolSize defines the number of concurrent build workers
 	WorkerPoolSize = 4
+	// CheckRunName defines the name of the check run created for each build job
+	CheckRunName = "test-environment"
+	// MaxBuildExtension defines how far into the future a build removal can be postponed
+	MaxBuildExtension = 14 * 24 * time.Hour
+	// ApprovalRetention defines how long a build approval is stored
+	ApprovalRetention = 30 * 24 * time.Hour
+	// ExternalImageTimeout defines how long a job waits for an image built by external CI
+	ExternalImageTimeout = 30 * time.Minute
+	// ExternalImagePollInterval defines how long a job waiting for an external image is parked
+	// between registry checks, reported images resumes the job right away
+	ExternalImagePollInterval = time.Minute
+	// JobLeaseDuration defines how long a claimed job is held without a lease renewal
+	JobLeaseDuration = 2 * time.Minute
+	// JobLeaseRenewInterval defines how often the lease of a running job is renewed
+	JobLeaseRenewInterval = 30 * time.Second
+	// JobPollInterval defines how often idle workers look for queued jobs
+	JobPollInterval = 10 * time.Second
+	// MaxJobAttempts defines how many times a job is claimed before it is abandoned
+	MaxJobAttempts = 3
+	// MaxBuildContextSize defines the default max size of a build context in bytes
+	MaxBuildContextSize = 2 << 30
+	// BuildContextMemoryBuffer defines how much of the repository archive is buffered in memory
+	// before it is spilled to disk, used when the docker daemon reads slower than the archive is served
+	BuildContextMemoryBuffer = 32 << 20
+	// LabelProvider defines the label name used to select the build requests of a source control provider
+	LabelProvider = "testenvironment.kolonial.no/provider"
 )
 
 var (
 	// ErrWorkerClosed Error
 	ErrWorkerClosed = errors.New("worker closed, cannot accept new jobs")
+	// ErrMissingProvider Error
+	ErrMissingProvider = errors.New("a source control provider is required")
+	// ErrMissingApprovalConfigMap Error
+	ErrMissingApprovalConfigMap = errors.New("an approval configmap name is required")
 	// ErrNoDockerfileFound Error
 	ErrNoDockerfileFound = errors.New("no dockerfile found in repository")
+	// ErrBuildContextTooLarge Error
+	ErrBuildContextTooLarge = errors.New("build context exceeds the max size")
+	// ErrInvalidImageName Error
+	ErrInvalidImageName = errors.New("invalid or duplicate image name")
+	// ErrUnknownImage Error
+	ErrUnknownImage = errors.New("image not configured on the environment")
 
+	// ErrLeaseLost Error
+	ErrLeaseLost = errors.New("job lease held by another worker")
 	// ErrJobOutdated Error
 	ErrJobOutdated = errors.New("job ID outdated")
+	// ErrJobSuperseded Error
+	ErrJobSuperseded = errors.New("job superseded by a newer job")
+	// ErrJobAbandoned Error
+	ErrJobAbandoned = errors.New("job abandoned after repeated attempts")
+	// ErrJobParked Error
+	ErrJobParked = errors.New("job parked while waiting for the external image")
 	// ErrJobIgnored Error
 	ErrJobIgnored = errors.New("job ignored")
+	// ErrExternalImageTimeout Error
+	ErrExternalImageTimeout = errors.New("timed out waiting for the external image")
+	// ErrBuildNotFound Error
+	ErrBuildNotFound = errors.New("no build found for the pull request")
+	// ErrJobNotFound Error
+	ErrJobNotFound = errors.New("no job found for the pull request")
+)
 
-	// CommentTemplate contains the template used to render the environment information
-	// nolint: lll
-	CommentTemplate = `☁️ Find your changes in the cloud! ☁️
-
-{{if .OnDemand}}<b>We don't deploy this build automatically, comment ` + "`/rebuild`" + ` to deploy this branch to the test environment.</b>{{end}}
-
-- Environment URL: https://{{.BuildURL}}
-- Logs: [https://{{.LoggingURLReadable}}](https://{{.LoggingURL}})
-{{.Extra}}
-
----
-
-<details>
-<summary>test-environment commands</summary>
-<br />
+// JobState defines the type that represents the job states
+type JobState string
 
-You can trigger test-environment actions by commenting on this PR:
-- ` + "`/rebuild`" + ` will issue a new deployment to the test-environment based on the latest commit.
-- ` + "`/clean`" + ` will remove the current test-environment build if exists and issue a new deployment based on the latest commit.
-</details>
-`
+var (
+	// QueuedJob for jobs waiting on a worker
+	QueuedJob JobState = "queued"
+	// RunningJob for jobs being processed
+	RunningJob JobState = "running"
+	// WaitingJob for jobs parked while waiting for an image built by external CI
+	WaitingJob JobState = "waiting"
+	// SucceededJob for completed jobs
+	SucceededJob JobState = "succeeded"
+	// FailedJob for jobs that returned an error
+	FailedJob JobState = "failed"
 )
+
+func (s *JobState) String() string {
+	return string(*s)
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
+	"github.com/kolonialno/pr-deployment-controller/pkg/comment"
 	"github.com/kolonialno/pr-deployment-controller/pkg/github"
 	"github.com/kolonialno/pr-deployment-controller/pkg/internal"
 	log "github.com/sirupsen/logrus"
@@ -18,10 +19,12 @@ type worker struct {
 	id     int
 	logger *log.Entry
 
-	jobs    <-chan *job
+	queue   *jobQueue
 	results chan<- *jobResult
+	stop    <-chan struct{}
 
 	scheduler *scheduler
+	approvals *approvalStore
 	options   *Options
 }
 
@@ -29,63 +32,169 @@ type worker struct {
 func newWorker(
 	id int,
 	logger *log.Entry,
-	jobs <-chan *job,
+	queue *jobQueue,
 	results chan<- *jobResult,
+	stop <-chan struct{},
 	scheduler *scheduler,
+	approvals *approvalStore,
 	options *Options,
 ) (*worker, error) {
 	return &worker{
 		id:     id,
 		logger: logger,
 
-		jobs:    jobs,
+		queue:   queue,
 		results: results,
+		stop:    stop,
 
 		scheduler: scheduler,
+		approvals: approvals,
 		options:   options,
 	}, nil
 }
 
-// run starts to consume and process jobs
+// run claims and processes queued jobs until the builder is stopped
 func (w *worker) run() {
 	for {
-		j, more := <-w.jobs
-		if more {
-			err := w.processJob(j)
-			w.results <- &jobResult{job: j, err: err}
-		} else {
-			w.logger.Info("received all jobs, closing worker")
+		select {
+		case <-w.stop:
+			w.logger.Info("builder stopped, closing worker")
 			return
+		default:
 		}
+
+		j, err := w.queue.claim(context.Background(), time.Now(), w.scheduler.observeJob)
+		if err != nil {
+			w.logger.WithError(err).Warn("could not claim job")
+		}
+
+		// Wait for new jobs if the queue is empty
+		if j == nil {
+			select {
+			case <-w.stop:
+				w.logger.Info("builder stopped, closing worker")
+				return
+			case <-w.queue.wakeup:
+			case <-time.After(JobPollInterval):
+			}
+			continue
+		}
+
+		err = w.processClaimedJob(j)
+		if err == ErrJobParked || err == ErrLeaseLost {
+			// Parked jobs are resumed when the external image is pushed, lost jobs are reported
+			// by the operator instance that resumed them
+			continue
+		}
+		w.results <- &jobResult{job: j, err: err}
 	}
 }
 
-func (w *worker) processJob(j *job) error {
-	ctx := context.Background()
+// processClaimedJob processes a job while renewing the job lease, the job is removed from
+// the queue when processed. The job is stopped if the lease is lost, the request is then left
+// to the operator instance that resumed it.
+func (w *worker) processClaimedJob(j *job) error {
+	logger := w.logger.WithFields(log.Fields{"job_id": j.id, "request": j.request})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	j.leaseLost = make(chan struct{})
+
+	renewCtx, stopRenew := context.WithCancel(context.Background())
+	renewDone := make(chan struct{})
+	go func() {
+		defer close(renewDone)
+
+		ticker := time.NewTicker(JobLeaseRenewInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-renewCtx.Done():
+				return
+			case <-ticker.C:
+				err := w.queue.renew(renewCtx, j.request, time.Now())
+				if err == ErrLeaseLost {
+					logger.Warn("job resumed by another operator instance, stopping job")
+					close(j.leaseLost)
+					cancel()
+					return
+				} else if err != nil {
+					logger.WithError(err).Warn("could not renew job lease")
+				}
+			}
+		}
+	}()
+
+	err := w.processJob(ctx, j)
+	stopRenew()
+	<-renewDone
+
+	if j.lost() {
+		w.scheduler.finishJob(j, ErrLeaseLost)
+		return ErrLeaseLost
+	}
+
+	// Jobs waiting for an external image are released, the request is claimed again later
+	if err == ErrJobParked {
+		w.scheduler.parkJob(j)
+
+		now := time.Now()
+		if parkErr := w.queue.park(context.Background(), j, now, now.Add(ExternalImagePollInterval)); parkErr != nil {
+			logger.WithError(parkErr).Warn("could not park job, the job is resumed when the lease expires")
+		}
+		return err
+	}
+
+	w.scheduler.finishJob(j, err)
 
+	if finishErr := w.queue.finish(context.Background(), j.request); finishErr != nil {
+		logger.WithError(finishErr).Warn("could not remove processed job from the queue")
+	}
+
+	return err
+}
+
+func (w *worker) processJob(ctx context.Context, j *job) error {
 	var currentTime = time.Now()
 	j.startTime = &currentTime
 
+	// Build jobs are cancelled when a newer job is queued for the build, deletions are only
+	// cancelled when the job lease is lost
+	var cancel context.CancelFunc
+	if !j.deleteEnvironment {
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+	}
+	w.scheduler.startJob(j, currentTime, cancel)
+
 	// Track queue delay
 	w.options.RuntimeSummary.WithLabelValues(
 		j.owner,
 		j.repository,
-		strconv.FormatInt(j.pullRequestNumber, 10),
+		j.identifier(),
 		strconv.FormatInt(j.id, 10),
 		"queue_delay",
 	).Observe(currentTime.Sub(*j.createTime).Seconds())
 
+	if j.abandoned {
+		return w.reportAbandoned(ctx, j)
+	}
+
 	if j.deleteEnvironment {
 		return w.deleteBuild(ctx, j)
 	}
 
-	return w.createBuild(ctx, j)
+	err := w.createBuild(ctx, j)
+	if j.superseded {
+		return ErrJobSuperseded
+	}
+
+	return err
 }
 
 // nolint: gocyclo
 func (w *worker) createBuild(ctx context.Context, j *job) error {
-	// Dockerfile path inside the build context
-	dockerFile := "Dockerfile"
 	// imageName to use then building an pushing the docker image, contains the full registry path
 	imageName := w.options.Docker.ImageName(j.owner, j.repository, j.ref)
 	// Initialize logger for the createBuild task
@@ -94,14 +203,61 @@ func (w *worker) createBuild(ctx context.Context, j *job) error {
 		"owner":               j.owner,
 		"repository":          j.repository,
 		"pull_request_number": j.pullRequestNumber,
+		"branch":              j.branch,
 		"ref":                 j.ref,
 		"user":                j.user,
+		"author":              j.author,
+		"fork":                j.fork,
 		"firstRun":            j.firstRun,
 		"clean":               j.clean,
 		"force":               j.force,
 	})
 	logger.Info("creating build")
 
+	// Skip jobs replaced by a newer job while waiting in the queue
+	if ctx.Err() != nil {
+		logger.Info("job superseded by a newer job, skipping build")
+		w.reportSuperseded(j)
+		return nil
+	}
+
+	// Skip branch builds without reporting a status if the branch doesn't match the environment
+	if j.branch != "" {
+		matched, err := w.branchMatches(ctx, j)
+		if err != nil {
+			logger.WithError(err).Warn("could not match branch against the environment")
+			return nil
+		} else if !matched {
+			logger.Info("branch not configured for deployment, skipping build")
+			return nil
+		}
+	}
+
+	// Capture the job output, the log is linked from the commit status while the job runs
+	var output io.Writer
+	var logsURL string
+	if w.options.BuildLogs != nil {
+		buildLog := w.options.BuildLogs.Start(
+			internal.GenerateBuildName(j.owner, j.repository, j.identifier()), j.id,
+		)
+		defer func() {
+			buildLog.Finish(time.Now())
+		}()
+
+		output = buildLog
+		logsURL = w.buildLogsURL(j)
+	}
+
+	// Report the build progress through a check run if enabled, commit statuses are used as a fallback
+	if w.options.Checks && w.options.GitHub != nil {
+		if err := w.createCheckRun(ctx, j); err != nil {
+			logger.WithError(err).Warn("could not create check run, reporting with commit statuses")
+		}
+	}
+
+	// environment is set when the environment manifest is found
+	var environment *testenvironmentv1alpha1.Environment
+
 	//
 	// Helper methods for processing a build job
 	//
@@ -111,9 +267,14 @@ func (w *worker) createBuild(ctx context.Context, j *job) error {
 		// Log event to stdout
 		logger.WithField("operation", operation).Info(strings.ToLower(description))
 
+		// Add the operation to the build log
+		if output != nil {
+			fmt.Fprintf(output, "==> %s\n", description) // nolint: gas, errcheck
+		}
+
 		// Notify Github about the operation
 		w.updateBuildStatus( // nolint: gas, errcheck
-			ctx, j, github.PendingState, description, "",
+			ctx, j, github.PendingState, description, logsURL,
 		)
 
 		// Execute f and track runtime
@@ -121,6 +282,11 @@ func (w *worker) createBuild(ctx context.Context, j *job) error {
 		err := f()
 		w.trackTask(j, operation, startTime)
 
+		// Add the operation to the check run summary
+		if j.checkRun != nil {
+			j.checkRun.addStep(description, time.Since(startTime), err)
+		}
+
 		// Return the function err
 		return err
 	}
@@ -128,13 +294,40 @@ func (w *worker) createBuild(ctx context.Context, j *job) error {
 	// checkError functions as a helper for checking an error and update commit
 	// status / log error message if something is wrong
 	checkError := func(err error, errorMessage string) bool {
+		// The job context is cancelled when a newer job is queued for the build
+		if err != nil && ctx.Err() != nil {
+			logger.WithError(err).Info("job superseded by a newer job, stopping build")
+			w.reportSuperseded(j)
+			return true
+		}
+
 		if err != nil {
 			// Log error to stdout
 			logger.WithError(err).Error(strings.ToLower(errorMessage))
 
+			// Add the error to the build log
+			if output != nil {
+				fmt.Fprintf(output, "==> %s: %s\n", errorMessage, err.Error()) // nolint: gas, errcheck
+			}
+
+			// Include the error details in the check run output
+			if j.checkRun != nil {
+				j.checkRun.fail(err)
+			}
+
+			// Mark the deployment as failed
+			w.updateDeploymentStatus( // nolint: gas, errcheck
+				ctx, j, github.DeploymentFailure, errorMessage,
+			)
+
+			// Present the failure in the environment comment
+			if environment != nil {
+				w.updateEnvironmentComment(ctx, j, environment, comment.FailedState) // nolint: gas, errcheck
+			}
+
 			//Update commit status
 			w.updateBuildStatus( // nolint: gas, errcheck
-				ctx, j, github.ErrorState, errorMessage, "",
+				ctx, j, github.ErrorState, errorMessage, logsURL,
 			)
 
 			// Error observed, return true
@@ -149,14 +342,14 @@ func (w *worker) createBuild(ctx context.Context, j *job) error {
 	//
 
 	var err error
-	var repositoryArchive io.ReadCloser
-	var buildContext io.Reader
-	var environment *testenvironmentv1alpha1.Environment
+	var builds []*imageBuild
 
 	// Cleanup after return
 	defer func() {
-		if repositoryArchive != nil {
-			repositoryArchive.Close() // nolint: errcheck
+		for _, build := range builds {
+			if build.archive != nil {
+				build.archive.Close() // nolint: errcheck
+			}
 		}
 	}()
 
@@ -194,64 +387,197 @@ func (w *worker) createBuild(ctx context.Context, j *job) error {
 		w.updateBuildStatus( // nolint: gas, errcheck
 			ctx, j, github.SuccessState, "Build ignored (ignoring commits from this user)", "",
 		)
+		w.updateDeploymentStatus(ctx, j, github.DeploymentInactive, "Build ignored") // nolint: gas, errcheck
 
 		return nil
 	} else if checkError(err, "Could not lookup ignored users") {
 		return err
 	}
 
-	// Clone repository
-	err = executeFunction(func() error {
-		repositoryArchive, err = w.options.GitHub.CloneBuild(ctx, j.owner, j.repository, j.ref)
-		return err
-	}, "cloneRepository", "Cloning repository")
-	if checkError(err, "Could not clone repository") {
-		return err
+	// Skip the build if the PR labels or draft state doesn't match the environment
+	// policies, an existing build is removed. Forced builds ignores the policies.
+	if allowed, reason := deployAllowed(environment, j); !allowed && !j.force {
+		logger.WithField("reason", reason).Warn("Job ignored, deployment not allowed")
+
+		if err = w.deleteBuild(ctx, j); checkError(err, "Could not delete build") {
+			return err
+		}
+
+		//Update commit status
+		w.updateBuildStatus( // nolint: gas, errcheck
+			ctx, j, github.SuccessState, fmt.Sprintf("Build skipped (%s)", reason), "",
+		)
+		w.updateDeploymentStatus(ctx, j, github.DeploymentInactive, "Build skipped") // nolint: gas, errcheck
+
+		return nil
 	}
 
-	// Process build context
-	err = executeFunction(func() error {
-		buildContext, err = w.processBuildContext(j, dockerFile, repositoryArchive)
-		return err
-	}, "processingBuildContext", "Processing build context")
-	if checkError(err, "Could not process build context") {
-		return err
+	// Wait for approval if the PR is from a fork or an untrusted author, the approval
+	// covers a single commit. Forced builds are not exempt.
+	if required, reason := approvalRequired(environment, j); required {
+		approved, err := w.approvals.approved(
+			ctx, approvalKey(w.options.SCM.Name(), j.owner, j.repository, j.pullRequestNumber), j.ref,
+		)
+		if checkError(err, "Could not lookup build approval") {
+			return err
+		}
+
+		if !approved {
+			logger.WithField("reason", reason).Warn("Job waiting for approval")
+
+			ref := j.ref
+			if len(ref) > 7 {
+				ref = ref[:7]
+			}
+
+			//Update commit status
+			w.updateBuildStatus( // nolint: gas, errcheck
+				ctx, j, github.PendingState,
+				fmt.Sprintf("Waiting for approval (%s), comment /approve %s to build this commit", reason, ref), "",
+			)
+			w.updateDeploymentStatus(ctx, j, github.DeploymentInactive, "Waiting for approval") // nolint: gas, errcheck
+
+			return nil
+		}
 	}
 
-	// Building image
-	err = executeFunction(func() error {
-		return w.options.Docker.BuildImage(ctx, buildContext, imageName, dockerFile)
-	}, "buildImage", "Building image")
-	if checkError(err, "Could not build image") {
+	// Images built by the job, the build image is left out when it's built by external CI
+	builds, err = imageBuilds(environment, j, imageName)
+	if checkError(err, "Invalid image build configuration") {
 		return err
 	}
 
-	// Pushing image
-	err = executeFunction(func() error {
-		return w.options.Docker.PushImage(ctx, imageName)
-	}, "pushImage", "Pushing image to remote registry")
-	if checkError(err, "Could not push image to remote registry") {
-		return err
+	// Check for the image built by external CI, cloning and building the build image is skipped.
+	// The job is parked until the image is pushed instead of holding the worker.
+	if environment.Spec.ExternalImage != nil {
+		imageName = w.externalImageName(environment.Spec.ExternalImage, j)
+
+		var ready bool
+		err = executeFunction(func() error {
+			imageName, ready, err = w.externalImage(
+				ctx, environment.Spec.ExternalImage, j, imageName, output, time.Now(),
+			)
+			return err
+		}, "checkExternalImage", "Checking the registry for the image built by external CI")
+		if checkError(err, "Image not found in remote registry") {
+			return err
+		}
+
+		if !ready {
+			logger.Info("Job waiting for the image built by external CI")
+
+			//Update commit status
+			w.updateBuildStatus( // nolint: gas, errcheck
+				ctx, j, github.PendingState, "Waiting for the image built by external CI", logsURL,
+			)
+
+			return ErrJobParked
+		}
 	}
 
-	// Skip build if the environment is configured as an on demand environment
-	// Continue if this is a forced build
+	// Skip deployment if the environment is configured as an on demand environment
+	// Continue if this is a forced build or the build already is deployed
 	buildExists, err := w.buildExists(ctx, j)
 	if checkError(err, "Could not lookup existing build manifest") {
 		return err
 	}
-	if environment.Spec.OnDemand && !j.force && !buildExists {
-		// Comment on the PR (Post test-environment information)
-		if j.firstRun {
-			w.commentEnvironmentInformation(ctx, j, environment) // nolint: gas, errcheck
+	buildOnly := draftBuildOnly(environment, j) && !j.force
+	deploy := !buildOnly && (!environment.Spec.OnDemand || j.force || buildExists)
+
+	// Track the environment with a GitHub deployment, pull request deployments are created when
+	// the job is queued and branch deployments once the branch matched
+	if deploy {
+		if j.deploymentID == 0 {
+			if err = createDeployment(ctx, w.options, j); err != nil {
+				logger.WithError(err).Warn("could not create deployment")
+			}
+		}
+
+		// Notify GitHub about the started deployment
+		w.updateDeploymentStatus( // nolint: gas, errcheck
+			ctx, j, github.DeploymentInProgress, "Building image",
+		)
+	} else {
+		// The queued deployment is closed, the image is built without being deployed
+		w.updateDeploymentStatus( // nolint: gas, errcheck
+			ctx, j, github.DeploymentInactive, "Build not deployed",
+		)
+	}
+
+	// Present the running build in the environment comment
+	w.updateEnvironmentComment(ctx, j, environment, comment.BuildingState) // nolint: gas, errcheck
+
+	// Images already pushed for the commit are reused, the missing images are built
+	var pending []*imageBuild
+	if len(builds) > 0 {
+		executeFunction(func() error { // nolint: gas, errcheck
+			pending = w.missingImages(ctx, builds, output)
+			return nil
+		}, "checkExistingImages", "Checking registry for existing images")
+	}
+
+	if len(pending) > 0 {
+		// The images of the previous build are used as build cache
+		previous, lookupErr := w.getBuildManifest(ctx, j)
+		if lookupErr != nil {
+			logger.WithError(lookupErr).Warn("could not lookup previous build, building without its images as cache")
 		}
+		addPreviousImages(pending, previous)
+
+		// Clone repository, every image build streams its own repository archive
+		err = executeFunction(func() error {
+			for _, build := range pending {
+				build.archive, err = w.options.SCM.CloneBuild(ctx, j.owner, j.repository, j.ref)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}, "cloneRepository", "Cloning repository")
+		if checkError(err, "Could not clone repository") {
+			return err
+		}
+
+		// Building images in parallel, the build contexts are streamed from the repository archives
+		err = executeFunction(func() error {
+			return eachImage(ctx, pending, func(ctx context.Context, build *imageBuild) error {
+				return w.buildImage(ctx, build, output, len(pending) > 1)
+			})
+		}, "buildImage", "Building image")
+		if err == ErrNoDockerfileFound || err == ErrBuildContextTooLarge {
+			if checkError(err, "Could not process build context") {
+				return err
+			}
+		} else if checkError(err, "Could not build image") {
+			return err
+		}
+
+		// Pushing images
+		err = executeFunction(func() error {
+			return eachImage(ctx, pending, func(ctx context.Context, build *imageBuild) error {
+				return w.options.Docker.PushImage(ctx, build.options.Image)
+			})
+		}, "pushImage", "Pushing image to remote registry")
+		if checkError(err, "Could not push image to remote registry") {
+			return err
+		}
+	}
+
+	// Skip build manifest creation, the environment is deployed on demand
+	if !deploy {
+		// Present the build in the environment comment
+		w.updateEnvironmentComment(ctx, j, environment, comment.ReadyState) // nolint: gas, errcheck
 
 		// The build finished successfully, post success state to Github
+		description := "Build ready, comment /rebuild to deploy the latest commit"
+		if buildOnly {
+			description = "Build ready, mark the PR as ready for review to deploy"
+		}
 		w.updateBuildStatus( // nolint: gas, errcheck
 			ctx,
 			j,
 			github.SuccessState,
-			"Build ready, comment /rebuild to deploy the latest commit",
+			description,
 			"",
 		)
 
@@ -259,7 +585,7 @@ func (w *worker) createBuild(ctx context.Context, j *job) error {
 	}
 
 	// Make sure the job ID is higher than the previous job for this repository
-	err = w.scheduler.scheduleJob(fmt.Sprintf("%s/%s-%d", j.owner, j.repository, j.pullRequestNumber), j.id)
+	err = w.scheduler.scheduleJob(j.key(), j.id)
 	if checkError(err, "Build outdated") {
 		return err
 	}
@@ -276,16 +602,14 @@ func (w *worker) createBuild(ctx context.Context, j *job) error {
 
 	// Create build manifest
 	err = executeFunction(func() error {
-		return w.createBuildManifest(ctx, environment.ObjectMeta.Name, j, imageName)
+		return w.createBuildManifest(ctx, environment.ObjectMeta.Name, j, imageName, namedImages(builds))
 	}, "createBuildManifest", "Creating build manifest")
 	if checkError(err, "Could not create build manifest") {
 		return err
 	}
 
-	// Comment on the PR (Post test-environment information)
-	if j.firstRun {
-		w.commentEnvironmentInformation(ctx, j, environment) // nolint: gas, errcheck
-	}
+	// Present the deployed build in the environment comment
+	w.updateEnvironmentComment(ctx, j, environment, comment.DeployedState) // nolint: gas, errcheck
 
 	// The build finished successfully, post success state to Github
 	w.updateBuildStatus( // nolint: gas, errcheck
@@ -294,7 +618,7 @@ func (w *worker) createBuild(ctx context.Context, j *job) error {
 		github.SuccessState,
 		"Build finished",
 		fmt.Sprintf("https://%s", internal.GenerateBuildURL(
-			j.owner, j.repository, j.pullRequestNumber, w.options.ClusterDomain,
+			j.owner, j.repository, j.identifier(), w.options.ClusterDomain,
 		)),
 	)
 
@@ -306,13 +630,45 @@ func (w *worker) deleteBuild(ctx context.Context, j *job) error {
 	startTime := time.Now()
 	defer w.trackTask(j, "manifest_deletion", startTime)
 
-	w.logger.WithFields(log.Fields{
+	logger := w.logger.WithFields(log.Fields{
 		"job_id":              j.id,
 		"owner":               j.owner,
 		"repository":          j.repository,
 		"pull_request_number": j.pullRequestNumber,
-	}).Info("deleting build")
+	})
+	logger.Info("deleting build")
+
+	// Lookup the deployment tracking the build before it is removed
+	build, err := w.getBuildManifest(ctx, j)
+	if err != nil || build == nil {
+		return err
+	}
+
+	// Keep the build if the PR labels and draft state still allows the deployment
+	if j.conditional {
+		environment, err := w.getEnvironmentManifest(ctx, j.owner, j.repository)
+		if err != nil {
+			return err
+		}
+
+		if allowed, _ := deployAllowed(environment, j); allowed && !draftBuildOnly(environment, j) {
+			logger.Info("deployment still allowed, keeping build")
+			return nil
+		}
+	}
 
 	// Call the deleteBuildManifest method implemented in operator.go
-	return w.deleteBuildManifest(ctx, j)
+	if err = w.deleteBuildManifest(ctx, j); err != nil {
+		return err
+	}
+
+	// Mark the deployment as inactive
+	if build.Spec.DeploymentID != 0 {
+		j.deploymentID = build.Spec.DeploymentID
+		if err = w.updateDeploymentStatus(ctx, j, github.DeploymentInactive, "Environment removed"); err != nil {
+			logger.WithError(err).Warn("could not mark deployment as inactive")
+		}
+	}
+
+	return nil
 }
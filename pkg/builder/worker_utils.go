@@ -1,204 +1,154 @@
 package builder
 
 import (
-	"archive/tar"
-	"bytes"
-	"compress/gzip"
 	"context"
 	"fmt"
-	"io"
-	"io/ioutil"
+	"path"
 	"strconv"
 	"strings"
-	"text/template"
 	"time"
 
 	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
 	"github.com/kolonialno/pr-deployment-controller/pkg/github"
 	"github.com/kolonialno/pr-deployment-controller/pkg/internal"
+	"k8s.io/apimachinery/pkg/api/errors"
 )
 
 //
-// Github interaction
+// SCM interaction
 //
 
-// commentEnvironmentInformation comments the environment information on the pull request
-func (w *worker) commentEnvironmentInformation(
-	ctx context.Context, j *job, environment *testenvironmentv1alpha1.Environment,
+// updateBuildStatus updates the build status based on the job value, the
+// check run is updated instead of the commit status if the job has one
+func (w *worker) updateBuildStatus(
+	ctx context.Context, j *job, state github.State, description, url string,
 ) error {
-	buildURL := internal.GenerateBuildURL(
-		j.owner, j.repository, j.pullRequestNumber, w.options.ClusterDomain,
-	)
-
-	var extra []string
-
-	// Remote teminal entrypoints
-	for _, container := range environment.Spec.Containers {
-		for _, terminal := range container.RemoteTerminal {
-			extra = append(extra, fmt.Sprintf(
-				"- %s %s [Click here](https://%s/term/%s-%s-%d/%s/%s/)",
-				container.Name,
-				terminal.Name,
-				buildURL,
-				j.owner,
-				j.repository,
-				j.pullRequestNumber,
-				container.Name,
-				terminal.Name,
-			))
-		}
+	if j.checkRun != nil {
+		return w.updateCheckRun(ctx, j, state, description, url)
 	}
 
-	// Environment links (Supports template values)
-	type props struct {
-		BuildURL string
-	}
-	p := props{
-		BuildURL: buildURL,
+	return w.options.SCM.PostBuildStatus(
+		ctx,
+		j.owner,
+		j.repository,
+		j.ref,
+		state,
+		description,
+		url,
+	)
+}
+
+// buildLogsURL returns the status server page streaming the job output, empty if the status
+// server URL isn't configured
+func (w *worker) buildLogsURL(j *job) string {
+	if w.options.StatusURL == "" {
+		return ""
 	}
-	for _, link := range environment.Spec.Links {
-		tmpl, err := template.New("link").Parse(link.URL)
-		if err != nil {
-			return err
-		}
 
-		buff := bytes.NewBufferString("")
+	return fmt.Sprintf(
+		"%s/builds/%s/logs/",
+		strings.TrimRight(w.options.StatusURL, "/"),
+		internal.GenerateBuildName(j.owner, j.repository, j.identifier()),
+	)
+}
 
-		err = tmpl.Execute(buff, p)
-		if err != nil {
-			return err
-		}
+// reportAbandoned reports a job given up after repeatedly stopping the operator, the job
+// isn't run again
+func (w *worker) reportAbandoned(ctx context.Context, j *job) error {
+	w.logger.WithField("job_id", j.id).Error("abandoning job after repeated attempts")
 
-		var value []byte
+	if j.deleteEnvironment {
+		return ErrJobAbandoned
+	}
 
-		value, err = ioutil.ReadAll(buff)
-		if err != nil {
-			return err
+	// Conclude the check run created by the previous attempts
+	if w.options.Checks && w.options.GitHub != nil {
+		if err := w.createCheckRun(ctx, j); err != nil {
+			w.logger.WithError(err).Warn("could not create check run, reporting with commit statuses")
 		}
-
-		extra = append(extra, fmt.Sprintf(
-			"- %s: %s",
-			link.Title,
-			string(value),
-		))
 	}
 
-	tmpl, err := template.New("comment").Parse(CommentTemplate)
-	if err != nil {
-		return err
-	}
+	//Update commit status
+	w.updateBuildStatus( // nolint: gas, errcheck
+		ctx, j, github.ErrorState, "Build abandoned after repeated operator failures", "",
+	)
+	w.updateDeploymentStatus( // nolint: gas, errcheck
+		ctx, j, github.DeploymentFailure, "Build abandoned after repeated operator failures",
+	)
 
-	data := map[string]interface{}{
-		"OnDemand":           environment.Spec.OnDemand,
-		"BuildURL":           buildURL,
-		"LoggingURLReadable": fmt.Sprintf("kibana.%s", w.options.ClusterDomain),
-		"LoggingURL": internal.GenerateLogsURL(
-			w.options.BuildPrefix,
-			j.owner,
-			j.repository,
-			j.pullRequestNumber,
-			fmt.Sprintf("kibana.%s", w.options.ClusterDomain),
-		),
-		"Extra": strings.Join(extra, "\n"),
-	}
+	return ErrJobAbandoned
+}
 
-	var commentBuffer bytes.Buffer
+// reportSuperseded reports a job cancelled by a newer job for the build. The job context is
+// cancelled, the updates are sent without it. The commit status is left to the newer job
+// if both jobs builds the same commit.
+func (w *worker) reportSuperseded(j *job) {
+	ctx := context.Background()
+	j.superseded = true
 
-	if err = tmpl.Execute(&commentBuffer, data); err != nil {
-		return err
+	// The job is reported by the operator instance that resumed it
+	if j.lost() {
+		return
 	}
 
-	return w.options.GitHub.PRComment(
-		ctx,
-		j.owner,
-		j.repository,
-		j.pullRequestNumber,
-		commentBuffer.String(),
+	// Mark the deployment as replaced
+	w.updateDeploymentStatus( // nolint: gas, errcheck
+		ctx, j, github.DeploymentInactive, "Superseded by a newer build",
 	)
-}
 
-// updateBuildStatus updates the build status based on the job value
-func (w *worker) updateBuildStatus(
-	ctx context.Context, j *job, state github.State, description, url string,
-) error {
-	return w.options.GitHub.PostBuildStatus(
-		ctx,
-		j.owner,
-		j.repository,
-		j.ref,
-		state,
-		description,
-		url,
+	if j.checkRun == nil {
+		if status, ok := w.scheduler.jobStatus(j.key()); ok && status.ID != j.id && status.Ref == j.ref {
+			return
+		}
+	}
+
+	//Update commit status
+	w.updateBuildStatus( // nolint: gas, errcheck
+		ctx, j, github.ErrorState, "Build superseded by a newer job", "",
 	)
 }
 
 //
-// Docker interaction
+// Deployment policies
 //
 
-// processBuildContext removes the root folder inside the repository
-// archive (place repository files in the archive root)
-// nolint: gocyclo
-func (w *worker) processBuildContext(
-	j *job,
-	dockerFile string,
-	r io.Reader,
-) (io.Reader, error) {
-	// Read gzip compressed file
-	gzipReader, err := gzip.NewReader(r)
-	if err != nil {
-		return nil, err
+// deployAllowed checks the job against the environment label rules and draft policy,
+// the reason is returned if the PR shouldn't be deployed
+func deployAllowed(environment *testenvironmentv1alpha1.Environment, j *job) (bool, string) {
+	if allowed, reason := labelsAllowDeploy(
+		environment.Spec.DeployLabel, environment.Spec.ExcludeLabel, j.labels,
+	); !allowed {
+		return false, reason
 	}
-	defer gzipReader.Close() // nolint: gas, errcheck
-
-	// Read tar archive
-	tarReader := tar.NewReader(gzipReader)
-
-	// Initialize resulting tar buffer
-	resultBuffer := bytes.NewBuffer([]byte{})
-	resultWriter := tar.NewWriter(resultBuffer)
-	defer resultWriter.Close() // nolint: gas, errcheck
-
-	baseFolder := fmt.Sprintf("%s-%s-%s/", j.owner, j.repository, j.ref)
-	dockerFileFound := false
-
-	for {
-		header, err := tarReader.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
-		if header == nil {
-			break
-		}
 
-		// Skip the first folder (root folder inside the archive)
-		if header.Name == baseFolder {
-			continue
-		}
+	if j.draft && environment.Spec.DraftPolicy == testenvironmentv1alpha1.DraftPolicySkip {
+		return false, "draft PR"
+	}
 
-		// Write to new tar (files at root level)
-		header.Name = strings.TrimPrefix(header.Name, baseFolder)
-		if header.Name == dockerFile {
-			dockerFileFound = true
-		}
-		if err := resultWriter.WriteHeader(header); err != nil {
-			return nil, err
-		}
+	return true, ""
+}
 
-		// Copy content
-		if _, err := io.Copy(resultWriter, tarReader); err != nil {
-			return nil, err
-		}
+// branchMatches returns true if the job branch matches one of the environment branch patterns
+func (w *worker) branchMatches(ctx context.Context, j *job) (bool, error) {
+	environment, err := w.getEnvironmentManifest(ctx, j.owner, j.repository)
+	if err != nil && errors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
 	}
 
-	if !dockerFileFound {
-		return nil, ErrNoDockerfileFound
+	for _, pattern := range environment.Spec.Branches {
+		if matched, err := path.Match(pattern, j.branch); err == nil && matched {
+			return true, nil
+		}
 	}
 
-	return resultBuffer, nil
+	return false, nil
+}
+
+// draftBuildOnly returns true if only the image should be built for the job
+func draftBuildOnly(environment *testenvironmentv1alpha1.Environment, j *job) bool {
+	return j.draft && environment.Spec.DraftPolicy == testenvironmentv1alpha1.DraftPolicyBuild
 }
 
 //
@@ -210,7 +160,7 @@ func (w *worker) trackTask(j *job, name string, startTime time.Time) {
 	w.options.RuntimeSummary.WithLabelValues(
 		j.owner,
 		j.repository,
-		strconv.FormatInt(j.pullRequestNumber, 10),
+		j.identifier(),
 		strconv.FormatInt(j.id, 10),
 		name,
 	).Observe(time.Since(startTime).Seconds())
@@ -0,0 +1,159 @@
+package buildlog
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// Store keeps the output of the latest job for each build in memory, logs of finished
+// jobs are removed after the retention period
+type Store struct {
+	lock sync.Mutex
+	logs map[string]*Log
+}
+
+// New returns an empty build log store
+func New() *Store {
+	return &Store{
+		logs: map[string]*Log{},
+	}
+}
+
+// Start creates the log of a job, replacing the log of the previous job for the build
+func (s *Store) Start(build string, jobID int64) *Log {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.prune(time.Now())
+
+	log := &Log{
+		JobID:   jobID,
+		changed: make(chan struct{}),
+	}
+	s.logs[build] = log
+
+	return log
+}
+
+// Get returns the log of the latest job for the build
+func (s *Store) Get(build string) (*Log, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.prune(time.Now())
+
+	log, ok := s.logs[build]
+	return log, ok
+}
+
+// prune removes the logs of jobs finished before the retention period, the caller holds the lock
+func (s *Store) prune(now time.Time) {
+	for build, log := range s.logs {
+		if finishedAt := log.finishedAt(); finishedAt != nil && now.Sub(*finishedAt) > Retention {
+			delete(s.logs, build)
+		}
+	}
+}
+
+// Log stores the output of a job, readers are notified about new lines
+type Log struct {
+	JobID int64
+
+	lock     sync.Mutex
+	lines    []string
+	dropped  int    // Number of lines removed from the start of the log
+	partial  []byte // Output written after the last newline
+	finished *time.Time
+	// changed is closed and replaced when the log is updated
+	changed chan struct{}
+}
+
+// Write appends output to the log, the output is split into lines
+func (l *Log) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.finished != nil {
+		return len(p), nil
+	}
+
+	data := append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		l.appendLine(string(data[:i]))
+		data = data[i+1:]
+	}
+	l.partial = append([]byte(nil), data...)
+
+	l.notify()
+
+	return len(p), nil
+}
+
+// Finish flushes the remaining output and marks the log as complete
+func (l *Log) Finish(now time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.finished != nil {
+		return
+	}
+
+	if len(l.partial) > 0 {
+		l.appendLine(string(l.partial))
+		l.partial = nil
+	}
+	l.finished = &now
+
+	l.notify()
+}
+
+// Read returns the lines written from the offset, the offset of the next line, true if the
+// log is complete and a channel closed when the log is updated
+func (l *Log) Read(offset int) ([]string, int, bool, <-chan struct{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Lines dropped from the log are skipped
+	if offset < l.dropped {
+		offset = l.dropped
+	}
+
+	var lines []string
+	if start := offset - l.dropped; start < len(l.lines) {
+		lines = append(lines, l.lines[start:]...)
+	}
+
+	return lines, l.dropped + len(l.lines), l.finished != nil, l.changed
+}
+
+// appendLine adds a line to the log, the caller holds the lock
+func (l *Log) appendLine(line string) {
+	if len(line) > MaxLineLength {
+		line = line[:MaxLineLength]
+	}
+
+	l.lines = append(l.lines, line)
+	if len(l.lines) > MaxLines {
+		l.dropped += len(l.lines) - MaxLines
+		l.lines = l.lines[len(l.lines)-MaxLines:]
+	}
+}
+
+// notify wakes the readers waiting for updates, the caller holds the lock
+func (l *Log) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// finishedAt returns the time the job finished, nil if the job is running
+func (l *Log) finishedAt() *time.Time {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.finished
+}
@@ -0,0 +1,57 @@
+package buildlog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+	s := New()
+	log := s.Start("kolonialno-test-3", 1)
+
+	_, _, _, changed := log.Read(0)
+	fmt.Fprint(log, "Step 1/2 : FROM alpine\nStep 2/2")
+
+	// Readers are notified about new output, partial lines are held back
+	<-changed
+	lines, _, _, _ := log.Read(0)
+	assert.Equal(t, []string{"Step 1/2 : FROM alpine"}, lines)
+
+	log.Finish(time.Now())
+	lines, next, finished, _ := log.Read(1)
+	assert.Equal(t, []string{"Step 2/2"}, lines)
+	assert.Equal(t, 2, next)
+	assert.True(t, finished)
+
+	found, ok := s.Get("kolonialno-test-3")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), found.JobID)
+}
+
+func TestLogDropsOldestLines(t *testing.T) {
+	log := New().Start("kolonialno-test-3", 1)
+
+	for i := 0; i < MaxLines+10; i++ {
+		fmt.Fprintf(log, "line %d\n", i)
+	}
+
+	lines, next, _, _ := log.Read(0)
+	assert.Len(t, lines, MaxLines)
+	assert.Equal(t, "line 10", lines[0])
+	assert.Equal(t, MaxLines+10, next)
+}
+
+func TestStorePrunesFinishedLogs(t *testing.T) {
+	s := New()
+
+	s.Start("kolonialno-test-3", 1).Finish(time.Now().Add(-Retention - time.Minute))
+	s.Start("kolonialno-test-4", 2)
+
+	_, ok := s.Get("kolonialno-test-3")
+	assert.False(t, ok)
+	_, ok = s.Get("kolonialno-test-4")
+	assert.True(t, ok)
+}
@@ -0,0 +1,14 @@
+package buildlog
+
+import (
+	"time"
+)
+
+var (
+	// MaxLines defines the number of lines kept for each build log, the oldest lines are dropped
+	MaxLines = 5000
+	// MaxLineLength defines the max length of a stored line, longer lines are truncated
+	MaxLineLength = 4096
+	// Retention defines how long the log of a finished job is kept
+	Retention = 24 * time.Hour
+)
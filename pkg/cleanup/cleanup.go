@@ -8,6 +8,7 @@ import (
 	"github.com/kolonialno/pr-deployment-controller/pkg/github"
 	"github.com/kolonialno/pr-deployment-controller/pkg/internal"
 	"github.com/kolonialno/pr-deployment-controller/pkg/k8s"
+	"github.com/kolonialno/pr-deployment-controller/pkg/scm"
 	"github.com/sirupsen/logrus"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -23,16 +24,18 @@ type baseCleanup struct {
 	logger *logrus.Entry
 	k8s    *k8s.Environment
 	github github.Github
+	gitlab scm.Provider // Optional, required to report on GitLab builds
 }
 
-// New creates a new instance of the cleanup worker
-func New(logger *logrus.Entry, k8s *k8s.Environment, github github.Github) (Cleanup, error) {
+// New creates a new instance of the cleanup worker, gitlab is nil if GitLab isn't configured
+func New(logger *logrus.Entry, k8s *k8s.Environment, github github.Github, gitlab scm.Provider) (Cleanup, error) {
 	cleanup := &baseCleanup{
 		stop: make(chan struct{}, 1),
 
 		logger: logger,
 		k8s:    k8s,
 		github: github,
+		gitlab: gitlab,
 	}
 
 	return cleanup, nil
@@ -67,7 +70,7 @@ func (c *baseCleanup) Stop(err error) {
 }
 
 // cleanup loops over the build instances inside the operator namespace and
-// deletes resources older than EnvironmentLifetime
+// deletes resources older than EnvironmentLifetime, unless the build is extended
 func (c *baseCleanup) cleanup() error {
 	ctx := context.TODO()
 
@@ -84,10 +87,14 @@ func (c *baseCleanup) cleanup() error {
 	for _, build := range builds.Items {
 		build := build
 		created := build.ObjectMeta.CreationTimestamp.Time
-		oldest := time.Now().Add(-EnvironmentLifetime)
 
-		// Delete evironment if the creation time is before the oldest allowed time
-		if created.Before(oldest) {
+		// Builds without Git info are not managed by the builder, branch builds are persistent
+		if build.Spec.Git == nil || build.Spec.Git.Branch != "" {
+			continue
+		}
+
+		// Delete evironment if the build is expired
+		if time.Now().After(BuildExpiry(&build)) {
 			logger := c.logger.WithFields(logrus.Fields{
 				"build":       build.Name,
 				"environment": build.Spec.Environment,
@@ -104,18 +111,56 @@ func (c *baseCleanup) cleanup() error {
 				continue
 			}
 
-			// Update github status
-			c.github.PostBuildStatus( // nolint: errcheck, gas
-				ctx,
-				build.Spec.Git.Owner,
-				build.Spec.Git.Repository,
-				build.Spec.Git.Ref,
-				github.SuccessState,
-				"Environment closed (no activity last 48h)",
-				"",
-			)
+			// Update the commit status
+			if provider := c.provider(&build); provider != nil {
+				provider.PostBuildStatus( // nolint: errcheck, gas
+					ctx,
+					build.Spec.Git.Owner,
+					build.Spec.Git.Repository,
+					build.Spec.Git.Ref,
+					scm.SuccessState,
+					"Environment closed (no activity last 48h)",
+					"",
+				)
+			}
+
+			// Mark the deployment as inactive, deployments are only created on GitHub
+			if build.Spec.DeploymentID != 0 && build.Spec.Git.Provider != scm.GitLabProvider {
+				c.github.CreateDeploymentStatus( // nolint: errcheck, gas
+					ctx,
+					build.Spec.Git.Owner,
+					build.Spec.Git.Repository,
+					build.Spec.DeploymentID,
+					github.DeploymentInactive,
+					"Environment closed (no activity last 48h)",
+					"",
+				)
+			}
 		}
 	}
 
 	return nil
 }
+
+// provider returns the source control provider the build was created from, nil if not configured
+func (c *baseCleanup) provider(build *testenvironmentv1alpha1.Build) scm.Provider {
+	if build.Spec.Git.Provider == scm.GitLabProvider {
+		return c.gitlab
+	}
+
+	return c.github
+}
+
+// BuildExpiry returns the time a build is removed, the EnvironmentLifetime
+// can be extended with the ExpiresAnnotation
+func BuildExpiry(build *testenvironmentv1alpha1.Build) time.Time {
+	expiry := build.ObjectMeta.CreationTimestamp.Time.Add(EnvironmentLifetime)
+
+	if value, ok := build.Annotations[testenvironmentv1alpha1.ExpiresAnnotation]; ok {
+		if extended, err := time.Parse(time.RFC3339, value); err == nil && extended.After(expiry) {
+			expiry = extended
+		}
+	}
+
+	return expiry
+}
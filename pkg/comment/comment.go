@@ -0,0 +1,137 @@
+package comment
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+
+	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
+	"github.com/kolonialno/pr-deployment-controller/pkg/internal"
+)
+
+// Info contains the build values presented in the environment comment
+type Info struct {
+	Owner             string
+	Repository        string
+	PullRequestNumber int64
+	Ref               string
+
+	State      State
+	DeployedAt *time.Time
+	Database   string
+}
+
+// Render renders the environment comment, the comment is prefixed with the Marker
+func Render(
+	environment *testenvironmentv1alpha1.Environment, info *Info, buildPrefix, clusterDomain string,
+) (string, error) {
+	identifier := internal.BuildIdentifier(info.PullRequestNumber, "")
+	buildURL := internal.GenerateBuildURL(info.Owner, info.Repository, identifier, clusterDomain)
+
+	extra, err := links(environment, info, buildURL)
+	if err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.New("comment").Parse(Template)
+	if err != nil {
+		return "", err
+	}
+
+	ref := info.Ref
+	if len(ref) > 7 {
+		ref = ref[:7]
+	}
+
+	var deployedAt string
+	if info.DeployedAt != nil {
+		deployedAt = info.DeployedAt.UTC().Format(TimeFormat)
+	}
+
+	data := map[string]interface{}{
+		"OnDemand":           environment.Spec.OnDemand,
+		"State":              info.State,
+		"Ref":                ref,
+		"DeployedAt":         deployedAt,
+		"Database":           info.Database,
+		"BuildURL":           buildURL,
+		"LoggingURLReadable": fmt.Sprintf("kibana.%s", clusterDomain),
+		"LoggingURL": internal.GenerateLogsURL(
+			buildPrefix,
+			info.Owner,
+			info.Repository,
+			identifier,
+			fmt.Sprintf("kibana.%s", clusterDomain),
+		),
+		"Extra": strings.Join(extra, "\n"),
+	}
+
+	var commentBuffer bytes.Buffer
+
+	if err = tmpl.Execute(&commentBuffer, data); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s\n%s", Marker, commentBuffer.String()), nil
+}
+
+// links renders the remote terminal entrypoints and environment links
+func links(environment *testenvironmentv1alpha1.Environment, info *Info, buildURL string) ([]string, error) {
+	var extra []string
+
+	// Remote teminal entrypoints
+	for _, container := range environment.Spec.Containers {
+		for _, terminal := range container.RemoteTerminal {
+			extra = append(extra, fmt.Sprintf(
+				"- %s %s [Click here](https://%s/term/%s/%s/%s/)",
+				container.Name,
+				terminal.Name,
+				buildURL,
+				internal.GenerateBuildName(
+					info.Owner, info.Repository, internal.BuildIdentifier(info.PullRequestNumber, ""),
+				),
+				container.Name,
+				terminal.Name,
+			))
+		}
+	}
+
+	// Environment links (Supports template values)
+	type props struct {
+		BuildURL string
+	}
+	p := props{
+		BuildURL: buildURL,
+	}
+	for _, link := range environment.Spec.Links {
+		tmpl, err := template.New("link").Parse(link.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		buff := bytes.NewBufferString("")
+
+		err = tmpl.Execute(buff, p)
+		if err != nil {
+			return nil, err
+		}
+
+		var value []byte
+
+		value, err = ioutil.ReadAll(buff)
+		if err != nil {
+			return nil, err
+		}
+
+		extra = append(extra, fmt.Sprintf(
+			"- %s: %s",
+			link.Title,
+			string(value),
+		))
+	}
+
+	return extra, nil
+}
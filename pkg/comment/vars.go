@@ -0,0 +1,55 @@
+package comment
+
+// State describes the build state presented in the environment comment
+type State string
+
+const (
+	// Marker identifies the environment comment, used to find and update the comment in place
+	Marker = "<!-- test-environment -->"
+
+	// BuildingState is presented while the build job is running
+	BuildingState State = "🔨 Building"
+	// FailedState is presented if the build job failed
+	FailedState State = "❌ Build failed"
+	// ReadyState is presented if an on demand build is ready, but not deployed
+	ReadyState State = "⏸️ Build ready, not deployed"
+	// DeployedState is presented if the build is deployed to the environment
+	DeployedState State = "🚀 Deployed"
+
+	// TimeFormat defines the format used to present timestamps
+	TimeFormat = "2006-01-02 15:04:05 MST"
+)
+
+var (
+	// Template contains the template used to render the environment information
+	// nolint: lll
+	Template = `☁️ Find your changes in the cloud! ☁️
+
+{{if .OnDemand}}<b>We don't deploy this build automatically, comment ` + "`/rebuild`" + ` to deploy this branch to the test environment.</b>{{end}}
+
+- State: {{.State}}
+- Commit: ` + "`{{.Ref}}`" + `
+- Last deploy: {{if .DeployedAt}}{{.DeployedAt}}{{else}}never{{end}}
+- Deployment: {{if .OnDemand}}on demand{{else}}automatic{{end}}{{if .Database}}
+- Database: ` + "`{{.Database}}`" + `{{end}}
+
+- Environment URL: https://{{.BuildURL}}
+- Logs: [https://{{.LoggingURLReadable}}](https://{{.LoggingURL}})
+{{.Extra}}
+
+---
+
+<details>
+<summary>test-environment commands</summary>
+<br />
+
+You can trigger test-environment actions by commenting on this PR:
+- ` + "`/rebuild`" + ` will issue a new deployment to the test-environment based on the latest commit.
+- ` + "`/clean`" + ` will remove the current test-environment build if exists and issue a new deployment based on the latest commit.
+- ` + "`/destroy`" + ` will remove the test-environment while the PR stays open.
+- ` + "`/extend 72h`" + ` will postpone the automatic removal of the test-environment.
+- ` + "`/status`" + ` will reply with a summary of the test-environment.
+- ` + "`/help`" + ` will list the available commands.
+</details>
+`
+)
@@ -8,6 +8,8 @@ import (
 	"github.com/kolonialno/pr-deployment-controller/pkg/apis/networking/v1alpha3"
 	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
 	"github.com/kolonialno/pr-deployment-controller/pkg/github"
+	"github.com/kolonialno/pr-deployment-controller/pkg/internal"
+	"github.com/kolonialno/pr-deployment-controller/pkg/scm"
 	log "github.com/sirupsen/logrus"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -30,6 +32,7 @@ type Options struct {
 	BuildPrefix       string
 	ClusterDomain     string
 	GitHub            github.Github
+	GitLab            scm.Provider // Optional, required to comment on GitLab builds
 	IstioNamespace    string
 	IstioGateway      string
 	BuildClusterRole  string
@@ -53,6 +56,7 @@ type buildReconciler struct {
 
 	build              *testenvironmentv1alpha1.Build
 	environment        *testenvironmentv1alpha1.Environment
+	identifier         string
 	namespace          string
 	serviceAccountName string
 
@@ -227,12 +231,11 @@ func (r *ReconcileBuild) Reconcile(request reconcile.Request) (result reconcile.
 	}
 
 	// Generate values used during the reconciliation
+	identifier := internal.BuildIdentifier(build.Spec.Git.PullRequestNumber, build.Spec.Git.Branch)
 	namespace := fmt.Sprintf(
-		"%s%s-%s-%d",
+		"%s%s",
 		options.BuildPrefix,
-		build.Spec.Git.Owner,
-		build.Spec.Git.Repository,
-		build.Spec.Git.PullRequestNumber,
+		internal.GenerateBuildName(build.Spec.Git.Owner, build.Spec.Git.Repository, identifier),
 	)
 	serviceAccountName := "test-environment"
 
@@ -245,6 +248,7 @@ func (r *ReconcileBuild) Reconcile(request reconcile.Request) (result reconcile.
 
 		build:              build,
 		environment:        environment,
+		identifier:         identifier,
 		namespace:          namespace,
 		serviceAccountName: serviceAccountName,
 
@@ -315,5 +319,12 @@ func (r *ReconcileBuild) Reconcile(request reconcile.Request) (result reconcile.
 		return reconcile.Result{}, err
 	}
 
+	// Report the rollout to the GitHub deployment
+	err = br.reconcileDeploymentStatus()
+	if err != nil {
+		logger.WithError(err).Error("could not reconcile deployment status")
+		return reconcile.Result{}, err
+	}
+
 	return reconcile.Result{}, nil
 }
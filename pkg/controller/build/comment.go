@@ -0,0 +1,49 @@
+package build
+
+import (
+	"github.com/kolonialno/pr-deployment-controller/pkg/comment"
+	"github.com/kolonialno/pr-deployment-controller/pkg/scm"
+)
+
+// updateEnvironmentComment presents the deployed build and claimed database in the environment comment
+func (br *buildReconciler) updateEnvironmentComment(database string) error {
+	info := &comment.Info{
+		Owner:             br.build.Spec.Git.Owner,
+		Repository:        br.build.Spec.Git.Repository,
+		PullRequestNumber: br.build.Spec.Git.PullRequestNumber,
+		Ref:               br.build.Spec.Git.Ref,
+		State:             comment.DeployedState,
+		Database:          database,
+	}
+	if br.build.Spec.DeployedAt != nil {
+		info.DeployedAt = &br.build.Spec.DeployedAt.Time
+	}
+
+	body, err := comment.Render(br.environment, info, br.options.BuildPrefix, br.options.ClusterDomain)
+	if err != nil {
+		return err
+	}
+
+	provider := br.provider()
+	if provider == nil {
+		return nil
+	}
+
+	return provider.UpsertPRComment(
+		br.ctx,
+		info.Owner,
+		info.Repository,
+		info.PullRequestNumber,
+		comment.Marker,
+		body,
+	)
+}
+
+// provider returns the source control provider the build was created from, nil if not configured
+func (br *buildReconciler) provider() scm.Provider {
+	if br.build.Spec.Git.Provider == scm.GitLabProvider {
+		return br.options.GitLab
+	}
+
+	return br.options.GitHub
+}
@@ -55,7 +55,7 @@ func (br *buildReconciler) reconcileContainerDeployment(
 					Containers: []corev1.Container{
 						{
 							Name:            service.Name,
-							Image:           br.build.Spec.Image,
+							Image:           containerImage(br.build, service.Image),
 							ImagePullPolicy: corev1.PullIfNotPresent,
 							Args:            service.Args,
 							EnvFrom: []corev1.EnvFromSource{
@@ -92,7 +92,7 @@ func (br *buildReconciler) reconcileContainerDeployment(
 
 	// Only update deployment if the current running image is wrong
 	if len(found.Spec.Template.Spec.Containers) != 1 ||
-		found.Spec.Template.Spec.Containers[0].Image != br.build.Spec.Image {
+		found.Spec.Template.Spec.Containers[0].Image != containerImage(br.build, service.Image) {
 		logger.Info("updating container")
 		found.Spec.Template.Spec = deploy.Spec.Template.Spec
 		return br.r.Update(br.ctx, found)
@@ -25,6 +25,9 @@ type claimeddatabase struct {
 	Password string
 	Host     string
 	Port     int64
+
+	// Claimed is true if the database was claimed by this claim call
+	Claimed bool
 }
 
 func newDatabaseClaim(br *buildReconciler) (*databaseclaim, error) {
@@ -60,7 +63,10 @@ func (cd *databaseclaim) claim(ctx context.Context) (*claimeddatabase, error) {
 		return nil, err
 	}
 
-	return cd.dbToClaim(db), nil
+	claimed := cd.dbToClaim(db)
+	claimed.Claimed = true
+
+	return claimed, nil
 }
 
 func (cd *databaseclaim) dbToClaim(db *testenvironmentv1alpha1.Database) *claimeddatabase {
@@ -0,0 +1,83 @@
+package build
+
+import (
+	"fmt"
+
+	"github.com/kolonialno/pr-deployment-controller/pkg/github"
+	"github.com/kolonialno/pr-deployment-controller/pkg/internal"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// reconcileDeploymentStatus marks the GitHub deployment as successful when all containers are rolled out
+func (br *buildReconciler) reconcileDeploymentStatus() error {
+	deploymentID := br.build.Spec.DeploymentID
+	if deploymentID == 0 {
+		return nil
+	}
+
+	// The state is only reported once for each deployment
+	if br.build.Status.DeploymentID == deploymentID &&
+		br.build.Status.DeploymentState == github.DeploymentSuccess.String() {
+		return nil
+	}
+
+	for _, container := range br.environment.Spec.Containers {
+		rolledOut, err := br.isRolledOut(
+			fmt.Sprintf("%s-container", container.Name), containerImage(br.build, container.Image),
+		)
+		if err != nil || !rolledOut {
+			return err
+		}
+	}
+
+	br.logger.WithField("deployment_id", deploymentID).Info("reporting successful deployment")
+
+	if err := br.options.GitHub.CreateDeploymentStatus(
+		br.ctx,
+		br.build.Spec.Git.Owner,
+		br.build.Spec.Git.Repository,
+		deploymentID,
+		github.DeploymentSuccess,
+		"Environment deployed",
+		fmt.Sprintf("https://%s", internal.GenerateBuildURL(
+			br.build.Spec.Git.Owner,
+			br.build.Spec.Git.Repository,
+			br.identifier,
+			br.options.ClusterDomain,
+		)),
+	); err != nil {
+		return err
+	}
+
+	br.build.Status.DeploymentID = deploymentID
+	br.build.Status.DeploymentState = github.DeploymentSuccess.String()
+
+	return br.r.Update(br.ctx, br.build)
+}
+
+// isRolledOut returns true if the deployment runs the image on all replicas
+func (br *buildReconciler) isRolledOut(name, image string) (bool, error) {
+	found := &appsv1.Deployment{}
+	err := br.r.Get(br.ctx, types.NamespacedName{Name: name, Namespace: br.namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if len(found.Spec.Template.Spec.Containers) != 1 ||
+		found.Spec.Template.Spec.Containers[0].Image != image {
+		return false, nil
+	}
+
+	var replicas int32 = 1
+	if found.Spec.Replicas != nil {
+		replicas = *found.Spec.Replicas
+	}
+
+	return found.Status.ObservedGeneration >= found.Generation &&
+		found.Status.UpdatedReplicas == replicas &&
+		found.Status.AvailableReplicas >= found.Status.UpdatedReplicas, nil
+}
@@ -27,7 +27,9 @@ func (br *buildReconciler) reconcileSharedEnv() error {
 		Owner             string
 		Repository        string
 		PullRequestNumber int64
+		Branch            string
 		Image             string
+		Images            map[string]string
 		ServerDomain      string
 		Namespace         string
 		Version           string
@@ -44,21 +46,17 @@ func (br *buildReconciler) reconcileSharedEnv() error {
 		Owner:             br.build.Spec.Git.Owner,
 		Repository:        br.build.Spec.Git.Repository,
 		PullRequestNumber: br.build.Spec.Git.PullRequestNumber,
+		Branch:            br.build.Spec.Git.Branch,
 		Image:             br.build.Spec.Image,
+		Images:            br.build.Spec.Images,
 		ServerDomain: internal.GenerateBuildURL(
 			br.build.Spec.Git.Owner,
 			br.build.Spec.Git.Repository,
-			br.build.Spec.Git.PullRequestNumber,
+			br.identifier,
 			options.ClusterDomain,
 		),
-		Namespace: fmt.Sprintf(
-			"%s%s-%s-%d",
-			options.BuildPrefix,
-			br.build.Spec.Git.Owner,
-			br.build.Spec.Git.Repository,
-			br.build.Spec.Git.PullRequestNumber,
-		),
-		Version: br.build.Spec.Git.Ref,
+		Namespace: br.namespace,
+		Version:   br.build.Spec.Git.Ref,
 	}
 
 	// Retrieve database claim
@@ -77,6 +75,13 @@ func (br *buildReconciler) reconcileSharedEnv() error {
 		p.DatabasePassword = dbopts.Password
 		p.DatabaseHost = dbopts.Host
 		p.DatabasePort = strconv.Itoa(int(dbopts.Port))
+
+		// Present the claimed database in the environment comment (pull request builds only)
+		if dbopts.Claimed && br.build.Spec.Git.Branch == "" {
+			if err = br.updateEnvironmentComment(dbopts.Name); err != nil {
+				logger.WithError(err).Warn("could not update environment comment")
+			}
+		}
 	}
 
 	data := map[string]string{}
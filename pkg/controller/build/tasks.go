@@ -48,7 +48,7 @@ func (br *buildReconciler) reconcileTask(task testenvironmentv1alpha1.TaskSpec,
 					Containers: []corev1.Container{
 						{
 							Name:  task.Name,
-							Image: br.build.Spec.Image,
+							Image: containerImage(br.build, task.Image),
 							Args:  task.Args,
 							EnvFrom: []corev1.EnvFromSource{
 								{
@@ -25,7 +25,7 @@ func (br *buildReconciler) hasImageChanged() (bool, error) {
 		if err := br.r.Get(
 			br.ctx, types.NamespacedName{Name: deploymentName, Namespace: br.namespace}, found,
 		); err == nil {
-			imageChanged := found.Spec.Template.Spec.Containers[0].Image != br.build.Spec.Image
+			imageChanged := found.Spec.Template.Spec.Containers[0].Image != containerImage(br.build, container.Image)
 			br.logger.WithField("changed", imageChanged).Info("image detected")
 			return imageChanged, nil
 		} else if !errors.IsNotFound(err) {
@@ -38,6 +38,16 @@ func (br *buildReconciler) hasImageChanged() (bool, error) {
 	return false, nil
 }
 
+// containerImage returns the image run by a container or task, named images missing in the
+// build (added to the environment after the build) falls back to the build image
+func containerImage(b *testenvironmentv1alpha1.Build, name string) string {
+	if image, ok := b.Spec.Images[name]; ok && name != "" {
+		return image
+	}
+
+	return b.Spec.Image
+}
+
 func convertContainerPorts(ports []testenvironmentv1alpha1.PortSpec) (result []corev1.ContainerPort) {
 	for _, port := range ports {
 		result = append(result, corev1.ContainerPort{
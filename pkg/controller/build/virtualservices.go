@@ -16,17 +16,16 @@ import (
 
 // nolint: gocyclo
 func (br *buildReconciler) reconcileViritualServices() error {
-	buildName := fmt.Sprintf(
-		"%s-%s-%d",
+	buildName := internal.GenerateBuildName(
 		br.build.Spec.Git.Owner,
 		br.build.Spec.Git.Repository,
-		br.build.Spec.Git.PullRequestNumber,
+		br.identifier,
 	)
 
 	buildURL := internal.GenerateBuildURL(
 		br.build.Spec.Git.Owner,
 		br.build.Spec.Git.Repository,
-		br.build.Spec.Git.PullRequestNumber,
+		br.identifier,
 		options.ClusterDomain,
 	)
 
@@ -0,0 +1,160 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/kolonialno/pr-deployment-controller/pkg/k8s"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// +kubebuilder:rbac:groups=,resources=configmaps,verbs=get;list;watch;create;update
+
+// configMapStore stores the deliveries in a ConfigMap in the operator namespace, the
+// delivery records are stored as json and the compressed payloads as binary data
+type configMapStore struct {
+	logger *logrus.Entry
+	k8s    *k8s.Environment
+	name   string
+
+	lock      sync.Mutex
+	configMap *corev1.ConfigMap // nil until loaded, replaced after each write
+	log       *deliveryLog
+}
+
+// New returns a delivery store backed by a ConfigMap
+func New(logger *logrus.Entry, k8sEnv *k8s.Environment, configMapName string) (Store, error) {
+	return &configMapStore{
+		logger: logger,
+		k8s:    k8sEnv,
+		name:   configMapName,
+	}, nil
+}
+
+// Record stores a delivery and its payload
+func (s *configMapStore) Record(ctx context.Context, delivery *Delivery, payload []byte) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.load(ctx); err != nil {
+		return false, err
+	}
+
+	duplicate, err := s.log.record(delivery, payload, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	return duplicate, s.save(ctx)
+}
+
+// Complete stores the outcome of a delivery
+func (s *configMapStore) Complete(ctx context.Context, id string, replay bool, err error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if loadErr := s.load(ctx); loadErr != nil {
+		return loadErr
+	}
+
+	if completeErr := s.log.complete(id, replay, err); completeErr != nil {
+		return completeErr
+	}
+
+	return s.save(ctx)
+}
+
+// List returns the stored deliveries, newest first
+func (s *configMapStore) List(ctx context.Context) ([]Delivery, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.log.list(), nil
+}
+
+// Get returns a delivery and its payload
+func (s *configMapStore) Get(ctx context.Context, id string) (*Delivery, []byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.load(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	return s.log.get(id)
+}
+
+// load reads the ConfigMap on first use, the in-memory log is used afterwards
+func (s *configMapStore) load(ctx context.Context) error {
+	if s.log != nil {
+		return nil
+	}
+
+	log := &deliveryLog{payloads: map[string][]byte{}}
+
+	found := &corev1.ConfigMap{}
+	err := s.k8s.Get(ctx, types.NamespacedName{Name: s.name, Namespace: s.k8s.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	} else if err == nil {
+		if data, ok := found.Data[deliveriesKey]; ok {
+			if err = json.Unmarshal([]byte(data), &log.deliveries); err != nil {
+				s.logger.WithError(err).Warn("could not read stored deliveries, starting with an empty store")
+				log.deliveries = nil
+			}
+		}
+		for id, payload := range found.BinaryData {
+			log.payloads[id] = payload
+		}
+		s.configMap = found
+	}
+
+	s.log = log
+	return nil
+}
+
+// save writes the in-memory log to the ConfigMap, the log is reloaded on the next
+// operation if the write fails
+func (s *configMapStore) save(ctx context.Context) error {
+	data, err := json.Marshal(s.log.deliveries)
+	if err != nil {
+		return err
+	}
+
+	configMap := s.configMap
+	if configMap == nil {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.name,
+				Namespace: s.k8s.Namespace,
+			},
+		}
+	}
+
+	configMap.Data = map[string]string{deliveriesKey: string(data)}
+	configMap.BinaryData = s.log.payloads
+
+	if s.configMap == nil {
+		err = s.k8s.Create(ctx, configMap)
+	} else {
+		err = s.k8s.Update(ctx, configMap)
+	}
+	if err != nil {
+		s.configMap = nil
+		s.log = nil
+		return err
+	}
+
+	s.configMap = configMap
+	return nil
+}
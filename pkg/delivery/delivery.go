@@ -0,0 +1,182 @@
+package delivery
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"time"
+)
+
+// Delivery contains a received webhook delivery
+type Delivery struct {
+	ID                string    `json:"id"`
+	Event             string    `json:"event"`
+	Action            string    `json:"action,omitempty"`
+	Repository        string    `json:"repository,omitempty"` // owner/repository
+	PullRequestNumber int64     `json:"pullRequestNumber,omitempty"`
+	ReceivedAt        time.Time `json:"receivedAt"`
+
+	Outcome    Outcome `json:"outcome"`
+	Error      string  `json:"error,omitempty"`
+	Duplicates int     `json:"duplicates,omitempty"` // Redeliveries ignored within the duplicate window
+	Replays    int     `json:"replays,omitempty"`
+}
+
+// Store defines the interface used to record webhook deliveries
+type Store interface {
+	// Record stores a delivery and its payload, true is returned if the delivery is a duplicate
+	// of a pending or accepted delivery received within the duplicate window (the duplicate is
+	// not stored)
+	Record(ctx context.Context, delivery *Delivery, payload []byte) (bool, error)
+	// Complete stores the outcome of a delivery, replays are counted
+	Complete(ctx context.Context, id string, replay bool, err error) error
+	// List returns the stored deliveries, newest first
+	List(ctx context.Context) ([]Delivery, error)
+	// Get returns a delivery and its payload
+	Get(ctx context.Context, id string) (*Delivery, []byte, error)
+}
+
+// deliveryLog is the in-memory representation of the stored deliveries, the deliveries are kept
+// for the duplicate window
+type deliveryLog struct {
+	deliveries []*Delivery // newest first
+	payloads   map[string][]byte
+}
+
+// record adds a delivery to the log, true is returned if the delivery is a duplicate. Redeliveries
+// of failed deliveries are handled again.
+func (l *deliveryLog) record(delivery *Delivery, payload []byte, now time.Time) (bool, error) {
+	if existing := l.find(delivery.ID); existing != nil {
+		if existing.Outcome != FailedOutcome && now.Sub(existing.ReceivedAt) < DuplicateWindow {
+			existing.Duplicates++
+			return true, nil
+		}
+		l.remove(delivery.ID)
+	}
+
+	compressed, err := compress(payload)
+	if err != nil {
+		return false, err
+	}
+	if len(compressed) <= MaxPayloadSize {
+		l.payloads[delivery.ID] = compressed
+	}
+
+	l.deliveries = append([]*Delivery{delivery}, l.deliveries...)
+
+	// Remove the deliveries received before the duplicate window
+	for len(l.deliveries) > 0 {
+		oldest := l.deliveries[len(l.deliveries)-1]
+		if len(l.deliveries) <= MaxDeliveries && now.Sub(oldest.ReceivedAt) < DuplicateWindow {
+			break
+		}
+		l.remove(oldest.ID)
+	}
+
+	// Only the newest payloads are kept for replays
+	if len(l.deliveries) > MaxPayloads {
+		for _, old := range l.deliveries[MaxPayloads:] {
+			delete(l.payloads, old.ID)
+		}
+	}
+
+	return false, nil
+}
+
+// complete stores the outcome of a delivery
+func (l *deliveryLog) complete(id string, replay bool, err error) error {
+	delivery := l.find(id)
+	if delivery == nil {
+		return ErrDeliveryNotFound
+	}
+
+	delivery.Outcome = AcceptedOutcome
+	delivery.Error = ""
+	if err != nil {
+		delivery.Outcome = FailedOutcome
+		delivery.Error = err.Error()
+	}
+	if replay {
+		delivery.Replays++
+	}
+
+	return nil
+}
+
+// get returns a copy of a delivery and the uncompressed payload
+func (l *deliveryLog) get(id string) (*Delivery, []byte, error) {
+	delivery := l.find(id)
+	if delivery == nil {
+		return nil, nil, ErrDeliveryNotFound
+	}
+
+	compressed, ok := l.payloads[id]
+	if !ok {
+		return nil, nil, ErrPayloadNotStored
+	}
+
+	payload, err := decompress(compressed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := *delivery
+	return &result, payload, nil
+}
+
+// list returns a copy of the deliveries, newest first
+func (l *deliveryLog) list() []Delivery {
+	deliveries := make([]Delivery, 0, len(l.deliveries))
+	for _, delivery := range l.deliveries {
+		deliveries = append(deliveries, *delivery)
+	}
+
+	return deliveries
+}
+
+func (l *deliveryLog) find(id string) *Delivery {
+	for _, delivery := range l.deliveries {
+		if delivery.ID == id {
+			return delivery
+		}
+	}
+
+	return nil
+}
+
+func (l *deliveryLog) remove(id string) {
+	for i, delivery := range l.deliveries {
+		if delivery.ID == id {
+			l.deliveries = append(l.deliveries[:i], l.deliveries[i+1:]...)
+			break
+		}
+	}
+	delete(l.payloads, id)
+}
+
+// compress gzips a payload
+func compress(payload []byte) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+
+	writer := gzip.NewWriter(buffer)
+	if _, err := writer.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// decompress reads a gzipped payload
+func decompress(compressed []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close() // nolint: errcheck, gas
+
+	return ioutil.ReadAll(reader)
+}
@@ -0,0 +1,93 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newLog() *deliveryLog {
+	return &deliveryLog{payloads: map[string][]byte{}}
+}
+
+func TestRecordDuplicates(t *testing.T) {
+	l := newLog()
+	now := time.Now()
+
+	duplicate, err := l.record(&Delivery{ID: "a", ReceivedAt: now}, []byte(`{"action": "opened"}`), now)
+	assert.Nil(t, err)
+	assert.False(t, duplicate)
+
+	duplicate, err = l.record(&Delivery{ID: "a", ReceivedAt: now}, []byte(`{}`), now.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.True(t, duplicate)
+	assert.Equal(t, 1, l.list()[0].Duplicates)
+
+	// Redeliveries of failed deliveries are handled again
+	assert.Nil(t, l.complete("a", false, errors.New("worker closed")))
+	duplicate, err = l.record(&Delivery{ID: "a", ReceivedAt: now}, []byte(`{}`), now.Add(2*time.Minute))
+	assert.Nil(t, err)
+	assert.False(t, duplicate)
+	assert.Len(t, l.list(), 1)
+	assert.Equal(t, 0, l.list()[0].Duplicates)
+
+	// Deliveries outside the duplicate window are handled again
+	later := now.Add(DuplicateWindow)
+	duplicate, err = l.record(&Delivery{ID: "a", ReceivedAt: later}, []byte(`{}`), later)
+	assert.Nil(t, err)
+	assert.False(t, duplicate)
+	assert.Len(t, l.list(), 1)
+}
+
+func TestRecordBounded(t *testing.T) {
+	l := newLog()
+	now := time.Now()
+
+	for i := 0; i < MaxPayloads+5; i++ {
+		_, err := l.record(&Delivery{ID: fmt.Sprintf("%d", i), ReceivedAt: now}, []byte(`{}`), now)
+		assert.Nil(t, err)
+	}
+
+	// The deliveries are kept for the duplicate window, the payloads of the oldest are dropped
+	deliveries := l.list()
+	assert.Len(t, deliveries, MaxPayloads+5)
+	assert.Len(t, l.payloads, MaxPayloads)
+	assert.Equal(t, fmt.Sprintf("%d", MaxPayloads+4), deliveries[0].ID)
+
+	_, _, err := l.get("0")
+	assert.Equal(t, ErrPayloadNotStored, err)
+
+	duplicate, err := l.record(&Delivery{ID: "0", ReceivedAt: now}, []byte(`{}`), now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.True(t, duplicate)
+
+	// Deliveries received before the duplicate window are removed
+	later := now.Add(DuplicateWindow)
+	_, err = l.record(&Delivery{ID: "new", ReceivedAt: later}, []byte(`{}`), later)
+	assert.Nil(t, err)
+	assert.Len(t, l.list(), 1)
+	assert.Len(t, l.payloads, 1)
+}
+
+func TestCompleteAndGet(t *testing.T) {
+	l := newLog()
+	now := time.Now()
+
+	_, err := l.record(&Delivery{ID: "a", ReceivedAt: now, Outcome: PendingOutcome}, []byte(`{"n": 1}`), now)
+	assert.Nil(t, err)
+
+	assert.Nil(t, l.complete("a", false, errors.New("worker closed")))
+	assert.Nil(t, l.complete("a", true, nil))
+
+	delivery, payload, err := l.get("a")
+	assert.Nil(t, err)
+	assert.Equal(t, AcceptedOutcome, delivery.Outcome)
+	assert.Empty(t, delivery.Error)
+	assert.Equal(t, 1, delivery.Replays)
+	assert.Equal(t, `{"n": 1}`, string(payload))
+
+	assert.Equal(t, ErrDeliveryNotFound, l.complete("b", false, nil))
+}
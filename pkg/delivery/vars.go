@@ -0,0 +1,46 @@
+package delivery
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// DuplicateWindow defines how long a delivery is kept, redeliveries are ignored within the window
+	DuplicateWindow = 24 * time.Hour
+	// MaxDeliveries caps the deliveries kept within the duplicate window to fit the ConfigMap, the
+	// oldest deliveries are dropped early if more deliveries are received in the window
+	MaxDeliveries = 1000
+	// MaxPayloads defines the number of payloads kept for replays, older deliveries can't be replayed
+	MaxPayloads = 30
+	// MaxPayloadSize defines the max size of a stored (compressed) payload, larger payloads can't be replayed
+	MaxPayloadSize = 16 * 1024
+)
+
+const (
+	// deliveriesKey stores the delivery records in the ConfigMap
+	deliveriesKey = "deliveries.json"
+)
+
+var (
+	// ErrDeliveryNotFound Error
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	// ErrPayloadNotStored Error
+	ErrPayloadNotStored = errors.New("delivery payload not stored")
+)
+
+// Outcome defines the type that represents the result of handling a delivery
+type Outcome string
+
+var (
+	// PendingOutcome for deliveries being handled
+	PendingOutcome Outcome = "pending"
+	// AcceptedOutcome for handled deliveries
+	AcceptedOutcome Outcome = "accepted"
+	// FailedOutcome for deliveries that could not be handled
+	FailedOutcome Outcome = "failed"
+)
+
+func (o *Outcome) String() string {
+	return string(*o)
+}
@@ -6,33 +6,50 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
+	"github.com/kolonialno/pr-deployment-controller/pkg/internal"
 	"github.com/sirupsen/logrus"
 )
 
 // Docker defines the interface used to talk with Docker.
 type Docker interface {
-	// BuildImage uploads the build context and requests an image build
-	BuildImage(ctx context.Context, buildContext io.Reader, image, dockerfile string) error
+	// BuildImage uploads the build context and requests an image build, the build output is
+	// written to the output writer if set
+	BuildImage(ctx context.Context, buildContext io.Reader, options *BuildOptions, output io.Writer) error
 	// PushImage pushes an image to a remote repository
 	PushImage(ctx context.Context, image string) error
+	// ImageExists checks if an image is pushed to the remote registry
+	ImageExists(ctx context.Context, image string) (bool, error)
 	// RegistryName generates a image name
 	ImageName(owner, repository, ref string) string
 }
 
+// BuildOptions defines the parameters of an image build
+type BuildOptions struct {
+	Image      string            // Image tag, including the registry path
+	Dockerfile string            // Dockerfile path inside the build context
+	Target     string            // Build stage, the last stage is built if empty
+	Args       map[string]string // Build args
+	Labels     map[string]string // Image labels
+	CacheFrom  []string          // Images used as build cache, pulled before the build
+}
+
+// Pool defines the docker controller spreading the builds across a pool of docker daemons, the
+// service runs the daemon health checks
+type Pool interface {
+	Docker
+	internal.Service
+}
+
 // Config stores the config for the docker controller
 type Config struct {
-	Host       string
+	Daemons    []DaemonConfig
 	APIVersion string
 
-	CertFile   string
-	KeyFile    string
-	CACertFile string
-
 	Registry             string
 	RegistryUsername     string
 	RegistryPassword     string
@@ -40,103 +57,129 @@ type Config struct {
 }
 
 type baseDocker struct {
+	*registryClient
+
+	stop   chan struct{}
 	logger *logrus.Entry
-	c      *client.Client
 
-	registry string
-	username string
-	password string
+	lock         sync.Mutex
+	daemons      []*daemon
+	repositories map[string]*daemon     // Daemon that last built each repository
+	images       map[string]*builtImage // Daemon storing each built image until it's pushed
 }
 
-// New creates a new Docker controller
-func New(logger *logrus.Entry, config *Config) (Docker, error) {
-	// Setup the http client
-	httpClient, err := newHTTPClient(config.CertFile, config.KeyFile, config.CACertFile)
-	if err != nil {
-		return nil, err
+// New creates a new Docker controller, builds are spread across the configured daemons
+func New(logger *logrus.Entry, config *Config) (Pool, error) {
+	if len(config.Daemons) == 0 {
+		return nil, ErrNoDaemons
 	}
 
-	// Setup the docker client
-	c, err := client.NewClient(
-		config.Host,
-		config.APIVersion,
-		httpClient,
-		nil,
-	)
+	registryClient, err := newRegistryClient(config)
 	if err != nil {
 		return nil, err
 	}
 
-	var registryPassword string
+	d := &baseDocker{
+		registryClient: registryClient,
 
-	if config.RegistryPassword != "" {
-		registryPassword = config.RegistryPassword
-	} else if config.RegistryPasswordFile != "" {
-		// Open password file
-		f, err := os.Open(config.RegistryPasswordFile)
-		if err != nil {
-			return nil, err
-		}
+		stop:   make(chan struct{}, 1),
+		logger: logger,
+
+		repositories: map[string]*daemon{},
+		images:       map[string]*builtImage{},
+	}
 
-		// Read file
-		content, err := ioutil.ReadAll(f)
+	for i := range config.Daemons {
+		daemon, err := newDaemon(&config.Daemons[i], config.APIVersion)
 		if err != nil {
 			return nil, err
 		}
-
-		registryPassword = string(content)
+		d.daemons = append(d.daemons, daemon)
 	}
 
-	return &baseDocker{
-		logger:   logger,
-		c:        c,
-		registry: config.Registry,
-		username: config.RegistryUsername,
-		password: registryPassword,
-	}, nil
+	return d, nil
 }
 
 // BuildImage sends a build context to the docker daemon and instructs the daemon to build an image
 func (d *baseDocker) BuildImage(
 	ctx context.Context,
 	buildContext io.Reader,
-	image,
-	dockerfile string,
+	options *BuildOptions,
+	output io.Writer,
 ) error {
-	d.logger.WithField("image", image).Infof("building image")
+	daemon, err := d.acquire(options.Image)
+	if err != nil {
+		return err
+	}
+	defer d.release(daemon)
+
+	d.logger.WithFields(logrus.Fields{
+		"image":      options.Image,
+		"dockerfile": options.Dockerfile,
+		"target":     options.Target,
+		"daemon":     daemon.host,
+	}).Infof("building image")
+
+	// The daemon only uses local images as cache, the cache images are pulled before the
+	// build. Missing images are skipped.
+	var cacheFrom []string
+	for _, image := range options.CacheFrom {
+		if err := d.pullImage(ctx, daemon, image); err != nil {
+			d.logger.WithError(err).WithField("image", image).Warn("could not pull cache image")
+			continue
+		}
+		cacheFrom = append(cacheFrom, image)
+	}
+
+	buildArgs := map[string]*string{}
+	for name, value := range options.Args {
+		value := value
+		buildArgs[name] = &value
+	}
 
-	resp, err := d.c.ImageBuild(ctx, buildContext, types.ImageBuildOptions{
-		Tags:       []string{image},
-		Dockerfile: dockerfile,
+	resp, err := daemon.c.ImageBuild(withBuildTarget(ctx, options.Target), buildContext, types.ImageBuildOptions{
+		Tags:       []string{options.Image},
+		Dockerfile: options.Dockerfile,
+		BuildArgs:  buildArgs,
+		Labels:     options.Labels,
+		CacheFrom:  cacheFrom,
 	})
 	if err != nil {
 		return err
 	}
 
-	return checkResponse(resp.Body)
+	if err := checkResponse(resp.Body, output); err != nil {
+		return err
+	}
+
+	// The image is only stored by the daemon, it's pushed from the same daemon
+	d.addImage(options.Image, daemon, time.Now())
+
+	return nil
 }
 
-// PushImage instructs the docker daemon to push an image to an external registry
+// PushImage instructs the docker daemon that built the image to push it to an external registry
 // nolint: gocyclo
 func (d *baseDocker) PushImage(ctx context.Context, image string) error {
 	options := types.ImagePushOptions{}
 
-	logger := d.logger.WithField("image", image)
-
-	// Create base64 encoded auth credentials
-	if d.username != "" && d.password != "" {
-		authConfig := types.AuthConfig{
-			Username: d.username,
-			Password: d.password,
-		}
+	daemon, err := d.acquireImage(image)
+	if err != nil {
+		return err
+	}
+	defer d.release(daemon)
 
-		encodedJSON, err := json.Marshal(authConfig)
-		if err != nil {
-			return err
-		}
+	logger := d.logger.WithFields(logrus.Fields{
+		"image":  image,
+		"daemon": daemon.host,
+	})
 
-		options.RegistryAuth = base64.URLEncoding.EncodeToString(encodedJSON)
+	// Create base64 encoded auth credentials
+	registryAuth, err := d.registryAuth()
+	if err != nil {
+		return err
 	}
+	options.RegistryAuth = registryAuth
 
 	if options.RegistryAuth != "" {
 		logger.Info("pushing image with credentials")
@@ -144,16 +187,66 @@ func (d *baseDocker) PushImage(ctx context.Context, image string) error {
 		logger.Infof("pushing image")
 	}
 
-	resp, err := d.c.ImagePush(ctx, image, options)
+	resp, err := daemon.c.ImagePush(ctx, image, options)
 	if err != nil {
 		return err
 	}
 
-	return checkResponse(resp)
+	if err := checkResponse(resp, nil); err != nil {
+		return err
+	}
+
+	d.removeImage(image)
+
+	return nil
+}
+
+// pullImage instructs a docker daemon to pull an image, the registry credentials are only
+// sent with images in the controller registry
+func (d *baseDocker) pullImage(ctx context.Context, daemon *daemon, image string) error {
+	options := types.ImagePullOptions{}
+
+	if d.registry != "" && strings.HasPrefix(image, d.registry+"/") {
+		registryAuth, err := d.registryAuth()
+		if err != nil {
+			return err
+		}
+		options.RegistryAuth = registryAuth
+	}
+
+	d.logger.WithFields(logrus.Fields{
+		"image":  image,
+		"daemon": daemon.host,
+	}).Info("pulling image")
+
+	resp, err := daemon.c.ImagePull(ctx, image, options)
+	if err != nil {
+		return err
+	}
+
+	return checkResponse(resp, nil)
+}
+
+// registryAuth returns the base64 encoded registry credentials passed to the docker daemon,
+// empty if no credentials are configured
+func (d *baseDocker) registryAuth() (string, error) {
+	if d.username == "" || d.password == "" {
+		return "", nil
+	}
+
+	encodedJSON, err := json.Marshal(types.AuthConfig{
+		Username: d.username,
+		Password: d.password,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.URLEncoding.EncodeToString(encodedJSON), nil
 }
 
 // ImageName returns the docker image name based on repository, owner and ref
-func (d *baseDocker) ImageName(owner, repository, ref string) string {
+func (d *registryClient) ImageName(owner, repository, ref string) string {
 	if d.registry != "" {
 		return fmt.Sprintf("%s/%s/%s:%s", d.registry, owner, repository, ref)
 	}
@@ -1,10 +1,12 @@
 package docker
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"io/ioutil"
 	"net/http"
+	"strings"
 )
 
 // newHTTPClient creates a new http client with client certificates
@@ -36,3 +38,39 @@ func newHTTPClient(certFile, keyFile, caCertFile string) (*http.Client, error) {
 		Timeout:   Timeout,
 	}, nil
 }
+
+// buildTargetKey stores the build stage in the request context
+type buildTargetKey struct{}
+
+// withBuildTarget returns a context selecting the build stage of image build requests
+func withBuildTarget(ctx context.Context, target string) context.Context {
+	if target == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, buildTargetKey{}, target)
+}
+
+// targetTransport adds the build stage to image build requests, the docker client predates
+// multi-stage builds and doesn't send the target option
+type targetTransport struct {
+	http.RoundTripper
+}
+
+func (t *targetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, ok := req.Context().Value(buildTargetKey{}).(string)
+	if !ok || !strings.HasSuffix(req.URL.Path, "/build") {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	// Round trippers must not modify the request
+	targetReq := new(http.Request)
+	*targetReq = *req
+	targetURL := *req.URL
+	query := targetURL.Query()
+	query.Set("target", target)
+	targetURL.RawQuery = query.Encode()
+	targetReq.URL = &targetURL
+
+	return t.RoundTripper.RoundTrip(targetReq)
+}
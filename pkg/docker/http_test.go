@@ -0,0 +1,36 @@
+package docker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTargetTransport(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &targetTransport{RoundTripper: http.DefaultTransport}}
+	send := func(ctx context.Context, path string) {
+		req, err := http.NewRequest(http.MethodPost, server.URL+path+"?t=image", nil)
+		assert.NoError(t, err)
+		resp, err := client.Do(req.WithContext(ctx))
+		assert.NoError(t, err)
+		resp.Body.Close() // nolint: errcheck
+	}
+
+	send(withBuildTarget(context.Background(), "preview"), "/v1.39/build")
+	assert.Equal(t, "t=image&target=preview", query)
+
+	// Other requests and builds without a target are sent unchanged
+	send(withBuildTarget(context.Background(), "preview"), "/v1.39/images/create")
+	assert.Equal(t, "t=image", query)
+	send(context.Background(), "/v1.39/build")
+	assert.Equal(t, "t=image", query)
+}
@@ -0,0 +1,442 @@
+package docker
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=,resources=pods/attach,verbs=create
+
+// kanikoContainer is the name of the build container in the kaniko jobs
+const kanikoContainer = "kaniko"
+
+// KanikoConfig stores the config for the kaniko build backend. The build jobs run the RUN steps of
+// the built repositories next to the registry secret, the namespace should only hold the build jobs.
+type KanikoConfig struct {
+	Namespace       string // Namespace the build jobs run in
+	Image           string // Kaniko executor image
+	RegistrySecret  string // Secret of type kubernetes.io/dockerconfigjson used by the jobs to push images
+	CacheRepository string // Repository storing the cached layers, defaults to <image repository>/cache
+	CPULimit        string // CPU limit of the build jobs
+	MemoryLimit     string // Memory limit of the build jobs
+}
+
+// kaniko builds images with kaniko jobs in the cluster, the jobs push the images when the build
+// finishes. The docker daemon isn't used.
+type kaniko struct {
+	*registryClient
+
+	logger     *logrus.Entry
+	config     *KanikoConfig
+	limits     corev1.ResourceList
+	restconfig *rest.Config
+	core       *corev1client.CoreV1Client
+	batch      *batchv1client.BatchV1Client
+}
+
+// NewKaniko creates a docker controller running the image builds as kaniko jobs, the registry
+// settings are used to name the images and lookup pushed images
+func NewKaniko(
+	logger *logrus.Entry, config *Config, kanikoConfig *KanikoConfig, restconfig *rest.Config,
+) (Docker, error) {
+	registryClient, err := newRegistryClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	cpuLimit, err := resource.ParseQuantity(kanikoConfig.CPULimit)
+	if err != nil {
+		return nil, err
+	}
+	memoryLimit, err := resource.ParseQuantity(kanikoConfig.MemoryLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	core, err := corev1client.NewForConfig(restconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	batch, err := batchv1client.NewForConfig(restconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kaniko{
+		registryClient: registryClient,
+
+		logger: logger,
+		config: kanikoConfig,
+		limits: corev1.ResourceList{
+			corev1.ResourceCPU:    cpuLimit,
+			corev1.ResourceMemory: memoryLimit,
+		},
+		restconfig: restconfig,
+		core:       core,
+		batch:      batch,
+	}, nil
+}
+
+// BuildImage runs a kaniko build job, the build context is streamed to the job through the
+// container stdin and the job output is written to the output writer if set
+func (k *kaniko) BuildImage(
+	ctx context.Context,
+	buildContext io.Reader,
+	options *BuildOptions,
+	output io.Writer,
+) error {
+	job, err := k.batch.Jobs(k.config.Namespace).Create(k.buildJob(options))
+	if err != nil {
+		return err
+	}
+
+	logger := k.logger.WithFields(logrus.Fields{
+		"image": options.Image,
+		"job":   job.Name,
+	})
+	logger.Info("building image")
+
+	// The job is removed when the build finishes, or right away if the build is cancelled as
+	// the attached stream only ends when the build container stops
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			k.deleteJob(logger, job.Name)
+		case <-done:
+		}
+	}()
+	defer k.deleteJob(logger, job.Name)
+
+	pod, err := k.waitForPod(ctx, job.Name, KanikoStartTimeout, containerStarted)
+	if err != nil {
+		return err
+	}
+
+	if output == nil {
+		output = ioutil.Discard
+	}
+	excerpt := &logExcerpt{}
+	output = &lockedWriter{w: io.MultiWriter(output, excerpt)}
+
+	if err = k.attach(pod.Name, buildContext, output); err != nil {
+		return err
+	}
+
+	pod, err = k.waitForPod(ctx, job.Name, Timeout, containerTerminated)
+	if err != nil {
+		return err
+	}
+
+	// The container is terminated when the wait returns
+	terminated := kanikoContainerStatus(pod).State.Terminated
+	if terminated.ExitCode != 0 {
+		return &BuildError{
+			Message: fmt.Sprintf("%v: exit code %d", ErrBuildJobFailed, terminated.ExitCode),
+			Log:     excerpt.lines(),
+		}
+	}
+
+	return nil
+}
+
+// PushImage checks that the build job pushed the image, the image is pushed by kaniko when
+// the build finishes
+func (k *kaniko) PushImage(ctx context.Context, image string) error {
+	exists, err := k.ImageExists(ctx, image)
+	if err != nil {
+		return err
+	} else if !exists {
+		return ErrImageNotPushed
+	}
+
+	return nil
+}
+
+// buildJob returns the job building the image, the build context is read from stdin
+func (k *kaniko) buildJob(options *BuildOptions) *batchv1.Job {
+	args := []string{
+		"--context=tar://stdin",
+		fmt.Sprintf("--dockerfile=%s", options.Dockerfile),
+		fmt.Sprintf("--destination=%s", options.Image),
+	}
+	if options.Target != "" {
+		args = append(args, fmt.Sprintf("--target=%s", options.Target))
+	}
+	for _, arg := range sortedPairs(options.Args) {
+		args = append(args, fmt.Sprintf("--build-arg=%s", arg))
+	}
+	for _, label := range sortedPairs(options.Labels) {
+		args = append(args, fmt.Sprintf("--label=%s", label))
+	}
+	// Kaniko can't use images as build cache, the layers are cached in a registry repository
+	// instead. Kaniko caches in <image repository>/cache unless the cache repository is set.
+	switch {
+	case k.config.CacheRepository != "":
+		args = append(args, "--cache=true", fmt.Sprintf("--cache-repo=%s", k.config.CacheRepository))
+	case len(options.CacheFrom) > 0:
+		args = append(args, "--cache=true")
+	}
+
+	container := corev1.Container{
+		Name:      kanikoContainer,
+		Image:     k.config.Image,
+		Args:      args,
+		Stdin:     true,
+		StdinOnce: true,
+		Resources: corev1.ResourceRequirements{Limits: k.limits},
+	}
+
+	// The RUN steps of the Dockerfile are untrusted, the build doesn't get access to the cluster
+	automountServiceAccountToken := false
+	podSpec := corev1.PodSpec{
+		RestartPolicy:                corev1.RestartPolicyNever,
+		AutomountServiceAccountToken: &automountServiceAccountToken,
+	}
+
+	// Registry credentials used to push the image, readable by the RUN steps of the Dockerfile
+	if k.config.RegistrySecret != "" {
+		container.VolumeMounts = []corev1.VolumeMount{
+			{Name: "docker-config", MountPath: "/kaniko/.docker"},
+		}
+		podSpec.Volumes = []corev1.Volume{
+			{
+				Name: "docker-config",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName: k.config.RegistrySecret,
+						Items: []corev1.KeyToPath{
+							{Key: corev1.DockerConfigJsonKey, Path: "config.json"},
+						},
+					},
+				},
+			},
+		}
+	}
+	podSpec.Containers = []corev1.Container{container}
+
+	var backoffLimit int32
+	activeDeadlineSeconds := int64((KanikoStartTimeout + Timeout).Seconds())
+	labels := map[string]string{"app": "kaniko-build"}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kaniko-build-",
+			Namespace:    k.config.Namespace,
+			Labels:       labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &activeDeadlineSeconds,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       podSpec,
+			},
+		},
+	}
+}
+
+// waitForPod polls the pod of a build job until the condition is met or the timeout expires,
+// the condition returns an error if the pod can't reach the awaited state
+func (k *kaniko) waitForPod(
+	ctx context.Context, job string, timeout time.Duration, condition func(*corev1.Pod) (bool, error),
+) (*corev1.Pod, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(KanikoPollInterval)
+	defer ticker.Stop()
+
+	for {
+		pods, err := k.core.Pods(k.config.Namespace).List(metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("job-name=%s", job),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range pods.Items {
+			if done, err := condition(&pods.Items[i]); err != nil {
+				return nil, err
+			} else if done {
+				return &pods.Items[i], nil
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			return nil, ErrBuildJobTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// attach streams the gzip compressed build context to the container stdin and copies the
+// container output until the container stops
+func (k *kaniko) attach(pod string, buildContext io.Reader, output io.Writer) error {
+	req := k.core.RESTClient().
+		Post().
+		Namespace(k.config.Namespace).
+		Resource("pods").
+		Name(pod).
+		SubResource("attach").
+		VersionedParams(&corev1.PodAttachOptions{
+			Container: kanikoContainer,
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(k.restconfig, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+
+	// The compression stops when the stream ends
+	stdin, stdinWriter := io.Pipe()
+	defer stdin.Close() // nolint: errcheck
+	go func() {
+		gzipWriter := gzip.NewWriter(stdinWriter)
+		_, err := io.Copy(gzipWriter, buildContext)
+		if err == nil {
+			err = gzipWriter.Close()
+		}
+		stdinWriter.CloseWithError(err) // nolint: errcheck
+	}()
+
+	return exec.Stream(remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: output,
+		Stderr: output,
+	})
+}
+
+// deleteJob removes a build job and its pod
+func (k *kaniko) deleteJob(logger *logrus.Entry, name string) {
+	propagationPolicy := metav1.DeletePropagationBackground
+	err := k.batch.Jobs(k.config.Namespace).Delete(name, &metav1.DeleteOptions{
+		PropagationPolicy: &propagationPolicy,
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		logger.WithError(err).Warn("could not delete build job")
+	}
+}
+
+// kanikoContainerStatus returns the status of the build container, nil if not reported
+func kanikoContainerStatus(pod *corev1.Pod) *corev1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == kanikoContainer {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+
+	return nil
+}
+
+// containerStarted returns true when the build container runs or stopped, containers that
+// can't be created are reported as failed
+func containerStarted(pod *corev1.Pod) (bool, error) {
+	status := kanikoContainerStatus(pod)
+	if status == nil {
+		return false, nil
+	}
+
+	if waiting := status.State.Waiting; waiting != nil {
+		switch waiting.Reason {
+		case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerConfigError":
+			return false, fmt.Errorf("%v: %s %s", ErrBuildJobFailed, waiting.Reason, waiting.Message)
+		}
+	}
+
+	return status.State.Running != nil || status.State.Terminated != nil, nil
+}
+
+// containerTerminated returns true when the build container stopped
+func containerTerminated(pod *corev1.Pod) (bool, error) {
+	status := kanikoContainerStatus(pod)
+	return status != nil && status.State.Terminated != nil, nil
+}
+
+// sortedPairs returns the key=value pairs of a map sorted by key
+func sortedPairs(values map[string]string) []string {
+	pairs := make([]string, 0, len(values))
+	for key, value := range values {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(pairs)
+
+	return pairs
+}
+
+// lockedWriter serialises the writes of the attached stdout and stderr streams
+type lockedWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.w.Write(p)
+}
+
+// logExcerpt keeps the last lines of the build output, included in the returned error
+type logExcerpt struct {
+	log     []string
+	partial string
+}
+
+func (e *logExcerpt) Write(p []byte) (int, error) {
+	lines := strings.Split(e.partial+string(p), "\n")
+	e.partial = lines[len(lines)-1]
+
+	for _, line := range lines[:len(lines)-1] {
+		if line = strings.TrimRight(line, "\r"); line == "" {
+			continue
+		}
+		e.log = append(e.log, line)
+		if len(e.log) > LogExcerptLines {
+			e.log = e.log[1:]
+		}
+	}
+
+	return len(p), nil
+}
+
+// lines returns the kept lines, including a trailing partial line
+func (e *logExcerpt) lines() []string {
+	log := e.log
+	if e.partial != "" {
+		log = append(log, e.partial)
+	}
+	if len(log) > LogExcerptLines {
+		log = log[len(log)-LogExcerptLines:]
+	}
+
+	return log
+}
@@ -0,0 +1,78 @@
+package docker
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestKanikoBuildJob(t *testing.T) {
+	k := &kaniko{config: &KanikoConfig{
+		Namespace:      "builds",
+		Image:          "gcr.io/kaniko-project/executor",
+		RegistrySecret: "registry",
+	}}
+
+	job := k.buildJob(&BuildOptions{
+		Image:      "registry/owner/repo:abc",
+		Dockerfile: "docker/Dockerfile",
+		Target:     "preview",
+		Args:       map[string]string{"VERSION": "abc", "COMMIT": "abc"},
+	})
+	assert.Equal(t, "builds", job.Namespace)
+
+	podSpec := job.Spec.Template.Spec
+	assert.Equal(t, corev1.RestartPolicyNever, podSpec.RestartPolicy)
+	assert.False(t, *podSpec.AutomountServiceAccountToken)
+	assert.Equal(t, "registry", podSpec.Volumes[0].Secret.SecretName)
+	assert.Equal(t, []string{
+		"--context=tar://stdin",
+		"--dockerfile=docker/Dockerfile",
+		"--destination=registry/owner/repo:abc",
+		"--target=preview",
+		"--build-arg=COMMIT=abc",
+		"--build-arg=VERSION=abc",
+	}, podSpec.Containers[0].Args)
+	assert.True(t, podSpec.Containers[0].StdinOnce)
+
+	// Layers are cached in the registry when the image has cache images
+	job = k.buildJob(&BuildOptions{
+		Image:      "registry/owner/repo:abc",
+		Dockerfile: "Dockerfile",
+		CacheFrom:  []string{"registry/owner/repo:master"},
+	})
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].Args, "--cache=true")
+}
+
+func TestContainerStarted(t *testing.T) {
+	pod := &corev1.Pod{}
+	started, err := containerStarted(pod)
+	assert.NoError(t, err)
+	assert.False(t, started)
+
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:  kanikoContainer,
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+	}}
+	_, err = containerStarted(pod)
+	assert.Error(t, err)
+
+	pod.Status.ContainerStatuses[0].State = corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	started, err = containerStarted(pod)
+	assert.NoError(t, err)
+	assert.True(t, started)
+}
+
+func TestLogExcerpt(t *testing.T) {
+	e := &logExcerpt{}
+	for i := 0; i < LogExcerptLines+5; i++ {
+		fmt.Fprintf(e, "line %d\n", i) // nolint: errcheck
+	}
+	fmt.Fprint(e, "error: build failed") // nolint: errcheck
+
+	lines := e.lines()
+	assert.Len(t, lines, LogExcerptLines)
+	assert.Equal(t, "error: build failed", lines[len(lines)-1])
+}
@@ -4,7 +4,9 @@ import (
 	"bufio"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"strings"
 )
 
 type errorDetail struct {
@@ -13,6 +15,7 @@ type errorDetail struct {
 }
 
 type errorLine struct {
+	Stream      string      `json:"stream"`
 	ErrorDetail errorDetail `json:"errorDetail"`
 }
 
@@ -23,18 +26,50 @@ func (e *errorLine) Error() error {
 	return nil
 }
 
-// checkResponse parses the docker daemon response and returns an error if the response contains an error
-func checkResponse(resp io.ReadCloser) error {
+// BuildError is returned when the docker daemon reports an error, it contains
+// the last lines of output written before the error occurred.
+type BuildError struct {
+	Message string
+	Log     []string
+}
+
+func (e *BuildError) Error() string {
+	return e.Message
+}
+
+// checkResponse parses the docker daemon response and returns an error if the response contains an error,
+// the output stream is copied to the output writer if set
+func checkResponse(resp io.ReadCloser, output io.Writer) error {
 	if resp != nil {
 		defer resp.Close() // nolint: errcheck
+
+		// Keep the last lines of output, included in the returned error
+		var log []string
+
 		scanner := bufio.NewScanner(resp)
 		for scanner.Scan() {
 			var p errorLine
 			if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
 				return err
 			}
+			if output != nil && p.Stream != "" {
+				io.WriteString(output, p.Stream) // nolint: gas, errcheck
+			}
 			if err := p.Error(); err != nil {
-				return err
+				if output != nil {
+					io.WriteString(output, fmt.Sprintf("\nerror: %s\n", err.Error())) // nolint: gas, errcheck
+				}
+				return &BuildError{Message: err.Error(), Log: log}
+			}
+
+			for _, line := range strings.Split(strings.TrimRight(p.Stream, "\n"), "\n") {
+				if line == "" {
+					continue
+				}
+				log = append(log, line)
+				if len(log) > LogExcerptLines {
+					log = log[1:]
+				}
 			}
 		}
 		if err := scanner.Err(); err != nil {
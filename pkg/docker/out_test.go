@@ -15,10 +15,35 @@ func createResponse(body string) io.ReadCloser {
 
 func TestCheckResponseNoError(t *testing.T) {
 	resp := createResponse("{}\n")
-	assert.Nil(t, checkResponse(resp))
+	assert.Nil(t, checkResponse(resp, nil))
 }
 
 func TestCheckResponseWithError(t *testing.T) {
 	resp := createResponse("{\"errorDetail\": {\"message\": \"message\", \"error\": \"error\"}}\n")
-	assert.Error(t, checkResponse(resp))
+	assert.Error(t, checkResponse(resp, nil))
+}
+
+func TestCheckResponseLogExcerpt(t *testing.T) {
+	resp := createResponse(
+		"{\"stream\": \"Step 1/2 : FROM alpine\\n\"}\n" +
+			"{\"stream\": \"Step 2/2 : RUN false\\n\"}\n" +
+			"{\"errorDetail\": {\"message\": \"failed\"}}\n",
+	)
+
+	err := checkResponse(resp, nil)
+	buildErr, ok := err.(*BuildError)
+	assert.True(t, ok)
+	assert.Equal(t, "failed", buildErr.Error())
+	assert.Equal(t, []string{"Step 1/2 : FROM alpine", "Step 2/2 : RUN false"}, buildErr.Log)
+}
+
+func TestCheckResponseOutput(t *testing.T) {
+	resp := createResponse(
+		"{\"stream\": \"Step 1/2 : FROM alpine\\n\"}\n" +
+			"{\"errorDetail\": {\"message\": \"failed\"}}\n",
+	)
+
+	output := &bytes.Buffer{}
+	assert.Error(t, checkResponse(resp, output))
+	assert.Equal(t, "Step 1/2 : FROM alpine\n\nerror: failed\n", output.String())
 }
@@ -0,0 +1,257 @@
+package docker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/kolonialno/pr-deployment-controller/pkg/internal"
+)
+
+// DaemonConfig stores the address and TLS material of a docker daemon
+type DaemonConfig struct {
+	Host       string
+	CertFile   string
+	KeyFile    string
+	CACertFile string
+}
+
+// DaemonConfigs pairs the daemon hosts with the TLS files at the same position, a single
+// certificate, key or CA file is shared by all the daemons
+func DaemonConfigs(hosts, certFiles, keyFiles, caCertFiles []string) ([]DaemonConfig, error) {
+	var daemons []DaemonConfig
+	for i, host := range hosts {
+		certFile, err := daemonFile(certFiles, i, len(hosts))
+		if err != nil {
+			return nil, err
+		}
+		keyFile, err := daemonFile(keyFiles, i, len(hosts))
+		if err != nil {
+			return nil, err
+		}
+		caCertFile, err := daemonFile(caCertFiles, i, len(hosts))
+		if err != nil {
+			return nil, err
+		}
+
+		daemons = append(daemons, DaemonConfig{
+			Host:       host,
+			CertFile:   certFile,
+			KeyFile:    keyFile,
+			CACertFile: caCertFile,
+		})
+	}
+
+	return daemons, nil
+}
+
+// daemonFile returns the TLS file of the daemon at the given position
+func daemonFile(files []string, i, daemons int) (string, error) {
+	switch len(files) {
+	case 1:
+		return files[0], nil
+	case daemons:
+		return files[i], nil
+	}
+
+	return "", ErrDaemonFileCount
+}
+
+// daemon is a docker daemon of the pool, the state is guarded by the pool lock
+type daemon struct {
+	host string
+	c    *client.Client
+
+	active  int  // Builds and pushes running on the daemon
+	healthy bool // Unhealthy daemons are not selected for new builds
+}
+
+// builtImage is an image stored by the daemon that built it, tracked until it's pushed
+type builtImage struct {
+	daemon  *daemon
+	builtAt time.Time
+}
+
+// newDaemon creates the client of a docker daemon, daemons are healthy until a health check fails
+func newDaemon(config *DaemonConfig, apiVersion string) (*daemon, error) {
+	// Setup the http client
+	httpClient, err := newHTTPClient(config.CertFile, config.KeyFile, config.CACertFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Setup the docker client
+	c, err := client.NewClient(
+		config.Host,
+		apiVersion,
+		httpClient,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Requests are sent through the target transport, installed after the client is created
+	// as the client only accepts the standard transport
+	httpClient.Transport = &targetTransport{RoundTripper: httpClient.Transport}
+
+	return &daemon{
+		host:    config.Host,
+		c:       c,
+		healthy: true,
+	}, nil
+}
+
+// acquire selects the daemon building an image and counts the build as active until released
+func (d *baseDocker) acquire(image string) (*daemon, error) {
+	repository := repositoryKey(image)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	selected := selectDaemon(d.daemons, d.repositories[repository])
+	if selected == nil {
+		return nil, ErrNoHealthyDaemon
+	}
+
+	selected.active++
+	d.repositories[repository] = selected
+
+	return selected, nil
+}
+
+// acquireImage returns the daemon that built an image and counts the push as active until released
+func (d *baseDocker) acquireImage(image string) (*daemon, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	built, ok := d.images[image]
+	if !ok {
+		return nil, ErrImageNotBuilt
+	}
+
+	built.daemon.active++
+
+	return built.daemon, nil
+}
+
+// release ends a build or push on a daemon
+func (d *baseDocker) release(daemon *daemon) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	daemon.active--
+}
+
+// addImage tracks the daemon storing a built image, images never pushed (cancelled jobs) are
+// dropped after a while
+func (d *baseDocker) addImage(image string, daemon *daemon, now time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for name, built := range d.images {
+		if now.Sub(built.builtAt) > BuiltImageRetention {
+			delete(d.images, name)
+		}
+	}
+
+	d.images[image] = &builtImage{daemon: daemon, builtAt: now}
+}
+
+// removeImage stops tracking a pushed image
+func (d *baseDocker) removeImage(image string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	delete(d.images, image)
+}
+
+// selectDaemon returns the healthy daemon with the least active builds, the daemon that last built
+// the repository is preferred for its layer cache unless it's much busier. Nil is returned if no
+// daemon is healthy.
+func selectDaemon(daemons []*daemon, sticky *daemon) *daemon {
+	var selected *daemon
+	for _, daemon := range daemons {
+		if daemon.healthy && (selected == nil || daemon.active < selected.active) {
+			selected = daemon
+		}
+	}
+
+	if selected != nil && sticky != nil && sticky.healthy && sticky.active <= selected.active+StickyMaxExtraBuilds {
+		return sticky
+	}
+
+	return selected
+}
+
+// repositoryKey returns the repository of an image, used to build the repository on the same daemon
+func repositoryKey(image string) string {
+	host, repository, _, err := parseImageName(image)
+	if err != nil {
+		return image
+	}
+
+	return host + "/" + repository
+}
+
+func (d *baseDocker) Runnable() (internal.RunFunc, internal.StopFunc) {
+	return d.Run, d.Stop
+}
+
+// Run checks the health of the daemons until stopped
+func (d *baseDocker) Run() error {
+	ticker := time.NewTicker(HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		d.checkDaemons()
+
+		select {
+		case <-ticker.C:
+		case <-d.stop:
+			return nil
+		}
+	}
+}
+
+func (d *baseDocker) Stop(err error) {
+	if err != nil {
+		d.logger.WithError(err).Warn("stopping docker health checks due to error")
+	}
+
+	d.stop <- struct{}{}
+}
+
+// checkDaemons pings the daemons, failing daemons are taken out of rotation until they respond
+func (d *baseDocker) checkDaemons() {
+	var wg sync.WaitGroup
+	for i := range d.daemons {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d.checkDaemon(d.daemons[i])
+		}(i)
+	}
+	wg.Wait()
+}
+
+// checkDaemon pings a daemon and updates its health
+func (d *baseDocker) checkDaemon(daemon *daemon) {
+	ctx, cancel := context.WithTimeout(context.Background(), HealthCheckTimeout)
+	defer cancel()
+
+	_, err := daemon.c.Ping(ctx)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	logger := d.logger.WithField("daemon", daemon.host)
+	if err != nil && daemon.healthy {
+		logger.WithError(err).Warn("docker daemon failed health check, removing it from the pool")
+	} else if err == nil && !daemon.healthy {
+		logger.Info("docker daemon recovered, adding it back to the pool")
+	}
+
+	daemon.healthy = err == nil
+}
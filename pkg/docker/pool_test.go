@@ -0,0 +1,79 @@
+package docker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDaemonConfigs(t *testing.T) {
+	daemons, err := DaemonConfigs(
+		[]string{"tcp://a:2376", "tcp://b:2376"},
+		[]string{"a.crt", "b.crt"},
+		[]string{"a.key", "b.key"},
+		[]string{"ca.crt"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []DaemonConfig{
+		{Host: "tcp://a:2376", CertFile: "a.crt", KeyFile: "a.key", CACertFile: "ca.crt"},
+		{Host: "tcp://b:2376", CertFile: "b.crt", KeyFile: "b.key", CACertFile: "ca.crt"},
+	}, daemons)
+
+	_, err = DaemonConfigs(
+		[]string{"tcp://a:2376", "tcp://b:2376", "tcp://c:2376"},
+		[]string{"a.crt", "b.crt"},
+		[]string{"shared.key"},
+		[]string{"ca.crt"},
+	)
+	assert.Equal(t, ErrDaemonFileCount, err)
+}
+
+func TestSelectDaemon(t *testing.T) {
+	a := &daemon{host: "a", active: 2, healthy: true}
+	b := &daemon{host: "b", active: 0, healthy: true}
+	c := &daemon{host: "c", active: 1, healthy: true}
+	daemons := []*daemon{a, b, c}
+
+	assert.Equal(t, b, selectDaemon(daemons, nil))
+	// The daemon that last built the repository is preferred unless it's much busier
+	assert.Equal(t, c, selectDaemon(daemons, c))
+	assert.Equal(t, b, selectDaemon(daemons, a))
+
+	c.healthy = false
+	assert.Equal(t, b, selectDaemon(daemons, c))
+
+	a.healthy, b.healthy = false, false
+	assert.Nil(t, selectDaemon(daemons, nil))
+}
+
+func TestAcquireImage(t *testing.T) {
+	a := &daemon{host: "a", healthy: true}
+	b := &daemon{host: "b", healthy: true}
+	d := &baseDocker{
+		daemons:      []*daemon{a, b},
+		repositories: map[string]*daemon{},
+		images:       map[string]*builtImage{},
+	}
+
+	building, err := d.acquire("registry/owner/repo:abc")
+	assert.NoError(t, err)
+	assert.Equal(t, a, building)
+	assert.Equal(t, 1, a.active)
+
+	// Pushes run on the daemon that built the image
+	now := time.Now()
+	d.addImage("registry/owner/repo:abc", building, now)
+	d.release(building)
+	pushing, err := d.acquireImage("registry/owner/repo:abc")
+	assert.NoError(t, err)
+	assert.Equal(t, a, pushing)
+
+	_, err = d.acquireImage("registry/owner/repo:def")
+	assert.Equal(t, ErrImageNotBuilt, err)
+
+	// Images never pushed are dropped
+	d.addImage("registry/owner/repo:def", b, now.Add(BuiltImageRetention+time.Minute))
+	_, err = d.acquireImage("registry/owner/repo:abc")
+	assert.Equal(t, ErrImageNotBuilt, err)
+}
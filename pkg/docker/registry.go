@@ -0,0 +1,209 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// manifestMediaTypes lists the manifest types accepted when checking if an image exists
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// challengeParam matches the key="value" parameters in a WWW-Authenticate header
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// registryClient implements the registry lookups and image names shared by the build backends
+type registryClient struct {
+	http *http.Client
+
+	registry string
+	username string
+	password string
+}
+
+// newRegistryClient creates a registry client, the password is read from the password file if
+// not set
+func newRegistryClient(config *Config) (*registryClient, error) {
+	registryPassword := config.RegistryPassword
+	if registryPassword == "" && config.RegistryPasswordFile != "" {
+		content, err := ioutil.ReadFile(config.RegistryPasswordFile)
+		if err != nil {
+			return nil, err
+		}
+
+		registryPassword = string(content)
+	}
+
+	return &registryClient{
+		http:     &http.Client{Timeout: RegistryTimeout},
+		registry: config.Registry,
+		username: config.RegistryUsername,
+		password: registryPassword,
+	}, nil
+}
+
+// ImageExists checks if the image manifest exists in the remote registry, the registry
+// credentials are used if the registry requires authentication
+func (d *registryClient) ImageExists(ctx context.Context, image string) (bool, error) {
+	host, repository, reference, err := parseImageName(image)
+	if err != nil {
+		return false, err
+	}
+
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, repository, reference)
+
+	resp, err := d.manifestRequest(ctx, manifestURL, "")
+	if err != nil {
+		return false, err
+	}
+
+	// Authenticate with the scheme requested by the registry and retry
+	if resp.StatusCode == http.StatusUnauthorized {
+		authorization, err := d.registryAuthorization(ctx, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return false, err
+		}
+
+		if resp, err = d.manifestRequest(ctx, manifestURL, authorization); err != nil {
+			return false, err
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return false, ErrRegistryAuthFailed
+	default:
+		return false, fmt.Errorf("unexpected registry response: %s", resp.Status)
+	}
+}
+
+// manifestRequest requests the manifest headers, the response body is closed
+func (d *registryClient) manifestRequest(
+	ctx context.Context, manifestURL, authorization string,
+) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close() // nolint: errcheck
+
+	return resp, nil
+}
+
+// registryAuthorization returns the Authorization header answering a registry challenge,
+// bearer tokens are requested from the token service named in the challenge
+func (d *registryClient) registryAuthorization(ctx context.Context, challenge string) (string, error) {
+	scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0])
+
+	switch scheme {
+	case "basic":
+		if d.username == "" || d.password == "" {
+			return "", ErrRegistryAuthFailed
+		}
+
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(d.username+":"+d.password)), nil
+
+	case "bearer":
+		params := map[string]string{}
+		for _, match := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+			params[match[1]] = match[2]
+		}
+		if params["realm"] == "" {
+			return "", ErrRegistryAuthFailed
+		}
+
+		query := url.Values{}
+		for _, key := range []string{"service", "scope"} {
+			if params[key] != "" {
+				query.Set(key, params[key])
+			}
+		}
+
+		req, err := http.NewRequest(http.MethodGet, params["realm"]+"?"+query.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		req = req.WithContext(ctx)
+		if d.username != "" && d.password != "" {
+			req.SetBasicAuth(d.username, d.password)
+		}
+
+		resp, err := d.http.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close() // nolint: errcheck
+
+		if resp.StatusCode != http.StatusOK {
+			return "", ErrRegistryAuthFailed
+		}
+
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return "", err
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+
+		return "Bearer " + token.Token, nil
+
+	default:
+		return "", ErrRegistryAuthFailed
+	}
+}
+
+// parseImageName splits an image name into the registry host, the repository path
+// and the tag or digest. Images without a registry host are resolved against Docker Hub.
+func parseImageName(image string) (string, string, string, error) {
+	name, reference := image, "latest"
+
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, reference = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, reference = name[:i], name[i+1:]
+	}
+
+	if name == "" || reference == "" {
+		return "", "", "", ErrInvalidImageName
+	}
+
+	host := DockerHubRegistry
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 &&
+		(strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		host, name = parts[0], parts[1]
+	} else if !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+
+	return host, name, reference, nil
+}
@@ -0,0 +1,74 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseImageName(t *testing.T) {
+	cases := []struct {
+		image, host, repository, reference string
+	}{
+		{"registry.example.com/owner/repo:abc", "registry.example.com", "owner/repo", "abc"},
+		{"localhost:5000/repo", "localhost:5000", "repo", "latest"},
+		{"owner/repo:abc", DockerHubRegistry, "owner/repo", "abc"},
+		{"alpine", DockerHubRegistry, "library/alpine", "latest"},
+		{"gcr.io/project/repo@sha256:123", "gcr.io", "project/repo", "sha256:123"},
+	}
+
+	for _, c := range cases {
+		host, repository, reference, err := parseImageName(c.image)
+		assert.Nil(t, err, c.image)
+		assert.Equal(t, c.host, host, c.image)
+		assert.Equal(t, c.repository, repository, c.image)
+		assert.Equal(t, c.reference, reference, c.image)
+	}
+
+	_, _, _, err := parseImageName("repo:")
+	assert.Equal(t, ErrInvalidImageName, err)
+}
+
+func TestImageExistsBearerToken(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			username, password, _ := r.BasicAuth()
+			assert.Equal(t, "user", username)
+			assert.Equal(t, "secret", password)
+			assert.Equal(t, "repository:owner/repo:pull", r.URL.Query().Get("scope"))
+			rw.Write([]byte(`{"token": "abc"}`)) // nolint: errcheck
+		case r.Header.Get("Authorization") != "Bearer abc":
+			rw.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				`Bearer realm="%s/token",service="registry",scope="repository:owner/repo:pull"`, server.URL,
+			))
+			rw.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/v2/owner/repo/manifests/present":
+			rw.WriteHeader(http.StatusOK)
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	d := &registryClient{
+		http:     server.Client(),
+		username: "user",
+		password: "secret",
+	}
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	exists, err := d.ImageExists(context.Background(), host+"/owner/repo:present")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	exists, err = d.ImageExists(context.Background(), host+"/owner/repo:missing")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
@@ -1,8 +1,56 @@
 package docker
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 const (
+	// DockerBackend builds images with the docker daemon
+	DockerBackend = "docker"
+	// KanikoBackend builds images with kaniko jobs in the cluster
+	KanikoBackend = "kaniko"
+
 	// Timeout stores the timeout used by the docker client
 	Timeout = 30 * time.Minute
+	// LogExcerptLines defines the number of output lines kept when a build fails
+	LogExcerptLines = 30
+	// RegistryTimeout stores the timeout used by the registry client
+	RegistryTimeout = 30 * time.Second
+	// DockerHubRegistry defines the registry used for images without a registry host
+	DockerHubRegistry = "registry-1.docker.io"
+	// KanikoStartTimeout defines how long a kaniko build job can wait for the build container
+	KanikoStartTimeout = 5 * time.Minute
+	// KanikoPollInterval defines how often the state of a kaniko build job is checked
+	KanikoPollInterval = 2 * time.Second
+	// HealthCheckInterval defines how often the docker daemons are checked
+	HealthCheckInterval = 30 * time.Second
+	// HealthCheckTimeout stores the timeout of a docker daemon health check
+	HealthCheckTimeout = 10 * time.Second
+	// StickyMaxExtraBuilds defines how many more active builds the daemon that last built a
+	// repository can have than the least active daemon and still be selected for the repository
+	StickyMaxExtraBuilds = 1
+	// BuiltImageRetention defines how long an image built but not pushed is tracked
+	BuiltImageRetention = 2 * Timeout
+)
+
+var (
+	// ErrInvalidImageName Error
+	ErrInvalidImageName = errors.New("invalid image name")
+	// ErrRegistryAuthFailed Error
+	ErrRegistryAuthFailed = errors.New("registry authentication failed")
+	// ErrBuildJobFailed Error
+	ErrBuildJobFailed = errors.New("build job failed")
+	// ErrBuildJobTimeout Error
+	ErrBuildJobTimeout = errors.New("timed out waiting for the build job")
+	// ErrImageNotPushed Error
+	ErrImageNotPushed = errors.New("image not found in the registry after the build")
+	// ErrNoDaemons Error
+	ErrNoDaemons = errors.New("no docker daemons configured")
+	// ErrNoHealthyDaemon Error
+	ErrNoHealthyDaemon = errors.New("no healthy docker daemon available")
+	// ErrImageNotBuilt Error
+	ErrImageNotBuilt = errors.New("image not built by a docker daemon of the pool")
+	// ErrDaemonFileCount Error
+	ErrDaemonFileCount = errors.New("expected one TLS file per docker daemon or a single shared file")
 )
@@ -0,0 +1,299 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/sirupsen/logrus"
+)
+
+// installationToken stores a cached installation access token
+type installationToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// appTransport authenticates each request with an installation token for the
+// owner/repository the request targets. Tokens are minted with the app JWT and
+// refreshed before they expire.
+type appTransport struct {
+	logger *logrus.Entry
+	base   http.RoundTripper
+
+	// app is authenticated as the GitHub App (JWT), used to lookup
+	// installations and create installation tokens
+	app *github.Client
+	jwt http.RoundTripper
+
+	// installationID authenticates the requests that don't target a repository, the
+	// requests are authenticated as the app (JWT) if zero
+	installationID int64
+
+	// lock guards the maps only, the network calls are made while holding the lock of
+	// the repository or installation
+	lock          sync.Mutex
+	keyLocks      map[string]*sync.Mutex
+	installations map[string]int64
+	tokens        map[int64]*installationToken
+}
+
+// newAppTransport creates a new transport authenticated as a GitHub App installation
+func newAppTransport(
+	logger *logrus.Entry, appID int64, privateKeyFile string, installationID int64,
+) (*appTransport, error) {
+	key, err := readPrivateKey(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	jwt := &jwtTransport{appID: appID, key: key, base: http.DefaultTransport}
+	app := github.NewClient(&http.Client{
+		Transport: jwt,
+		Timeout:   Timeout,
+	})
+
+	return &appTransport{
+		logger: logger,
+		base:   http.DefaultTransport,
+		app:    app,
+		jwt:    jwt,
+
+		installationID: installationID,
+
+		keyLocks:      map[string]*sync.Mutex{},
+		installations: map[string]int64{},
+		tokens:        map[int64]*installationToken{},
+	}, nil
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var token string
+	var err error
+
+	owner, repository, ok := repositoryFromPath(req.URL.Path)
+	switch {
+	case ok:
+		token, err = t.token(req.Context(), owner, repository)
+	case t.installationID != 0:
+		token, err = t.installationToken(req.Context(), t.installationID)
+	default:
+		// Requests outside a repository are authenticated as the app
+		return t.jwt.RoundTrip(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// RoundTrippers should not modify the original request
+	r := req.WithContext(req.Context())
+	r.Header = make(http.Header, len(req.Header))
+	for key, value := range req.Header {
+		r.Header[key] = value
+	}
+	r.Header.Set("Authorization", "token "+token)
+
+	return t.base.RoundTrip(r)
+}
+
+// token returns a valid installation token for the given repository
+func (t *appTransport) token(ctx context.Context, owner, repository string) (string, error) {
+	key := strings.ToLower(fmt.Sprintf("%s/%s", owner, repository))
+
+	installationID, err := t.installation(ctx, key, owner, repository)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := t.installationToken(ctx, installationID)
+	if err != nil {
+		// The installation may have been removed, look it up again on the next request
+		t.lock.Lock()
+		delete(t.installations, key)
+		t.lock.Unlock()
+		return "", err
+	}
+
+	return token, nil
+}
+
+// installation returns the installation of a repository, concurrent lookups of the same
+// repository wait for the first lookup
+func (t *appTransport) installation(ctx context.Context, key, owner, repository string) (int64, error) {
+	lock := t.keyLock("repository:" + key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	t.lock.Lock()
+	installationID, ok := t.installations[key]
+	t.lock.Unlock()
+	if ok {
+		return installationID, nil
+	}
+
+	installation, _, err := t.app.Apps.FindRepositoryInstallation(ctx, owner, repository)
+	if err != nil {
+		return 0, err
+	}
+
+	t.lock.Lock()
+	t.installations[key] = installation.GetID()
+	t.lock.Unlock()
+
+	return installation.GetID(), nil
+}
+
+// installationToken returns a valid token for an installation, concurrent requests of the
+// same installation wait for the token to be created
+func (t *appTransport) installationToken(ctx context.Context, installationID int64) (string, error) {
+	lock := t.keyLock(fmt.Sprintf("installation:%d", installationID))
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Reuse the cached token until it is about to expire
+	t.lock.Lock()
+	cached, ok := t.tokens[installationID]
+	t.lock.Unlock()
+	if ok && time.Until(cached.expiresAt) > TokenRefreshWindow {
+		return cached.token, nil
+	}
+
+	t.logger.WithField("installation_id", installationID).Info("creating installation token")
+
+	token, _, err := t.app.Apps.CreateInstallationToken(ctx, installationID)
+	if err != nil {
+		return "", err
+	}
+
+	t.lock.Lock()
+	t.tokens[installationID] = &installationToken{
+		token:     token.GetToken(),
+		expiresAt: token.GetExpiresAt(),
+	}
+	t.lock.Unlock()
+
+	return token.GetToken(), nil
+}
+
+// keyLock returns the lock of a repository or installation
+func (t *appTransport) keyLock(key string) *sync.Mutex {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	lock, ok := t.keyLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		t.keyLocks[key] = lock
+	}
+
+	return lock
+}
+
+// jwtTransport authenticates requests as the GitHub App itself
+type jwtTransport struct {
+	appID int64
+	key   *rsa.PrivateKey
+	base  http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *jwtTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := newJWT(t.appID, t.key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	r := req.WithContext(req.Context())
+	r.Header = make(http.Header, len(req.Header))
+	for key, value := range req.Header {
+		r.Header[key] = value
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	return t.base.RoundTrip(r)
+}
+
+// newJWT creates a RS256 signed JWT used to authenticate as the GitHub App
+func newJWT(appID int64, key *rsa.PrivateKey, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	// Backdate the issue time to allow for clock drift
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-1 * time.Minute).Unix(),
+		"exp": now.Add(JWTLifetime).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hashed := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// readPrivateKey reads a PEM encoded RSA private key from disk
+func readPrivateKey(privateKeyFile string) (*rsa.PrivateKey, error) {
+	content, err := ioutil.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	// GitHub issues PKCS1 keys, accept PKCS8 keys as well
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	return rsaKey, nil
+}
+
+// repositoryFromPath extracts the owner and repository from an API path (/repos/{owner}/{repository}/...)
+func repositoryFromPath(path string) (string, string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i, part := range parts {
+		if part == "repos" && i+2 < len(parts) {
+			return parts[i+1], parts[i+2], parts[i+1] != "" && parts[i+2] != ""
+		}
+	}
+
+	return "", "", false
+}
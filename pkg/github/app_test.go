@@ -0,0 +1,95 @@
+package github
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepositoryFromPath(t *testing.T) {
+	owner, repository, ok := repositoryFromPath("/repos/kolonialno/pr-deployment-controller/statuses/abc")
+	assert.True(t, ok)
+	assert.Equal(t, "kolonialno", owner)
+	assert.Equal(t, "pr-deployment-controller", repository)
+
+	owner, repository, ok = repositoryFromPath("/api/v3/repos/kolonialno/test/pulls/1")
+	assert.True(t, ok)
+	assert.Equal(t, "kolonialno", owner)
+	assert.Equal(t, "test", repository)
+
+	_, _, ok = repositoryFromPath("/repos/kolonialno")
+	assert.False(t, ok)
+
+	_, _, ok = repositoryFromPath("/user")
+	assert.False(t, ok)
+}
+
+func TestNewJWT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	token, err := newJWT(42, key, now)
+	assert.Nil(t, err)
+
+	parts := strings.Split(token, ".")
+	assert.Len(t, parts, 3)
+
+	// Validate the signature
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.Nil(t, err)
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.Nil(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hashed[:], signature))
+
+	// Validate the claims
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.Nil(t, err)
+	var claims map[string]int64
+	assert.Nil(t, json.Unmarshal(rawClaims, &claims))
+	assert.Equal(t, int64(42), claims["iss"])
+	assert.Equal(t, now.Add(JWTLifetime).Unix(), claims["exp"])
+}
+
+func TestAppTransportOutsideRepository(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	transport := &appTransport{
+		base:   http.DefaultTransport,
+		jwt:    &jwtTransport{appID: 42, key: key, base: http.DefaultTransport},
+		tokens: map[int64]*installationToken{7: {token: "installation", expiresAt: time.Now().Add(time.Hour)}},
+
+		keyLocks: map[string]*sync.Mutex{},
+	}
+	client := &http.Client{Transport: transport}
+
+	// Requests outside a repository are authenticated as the app
+	res, err := client.Get(server.URL + "/app")
+	assert.Nil(t, err)
+	res.Body.Close() // nolint: errcheck
+	assert.True(t, strings.HasPrefix(authorization, "Bearer "))
+
+	// The configured installation is used if set
+	transport.installationID = 7
+	res, err = client.Get(server.URL + "/user")
+	assert.Nil(t, err)
+	res.Body.Close() // nolint: errcheck
+	assert.Equal(t, "token installation", authorization)
+}
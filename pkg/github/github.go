@@ -7,7 +7,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/kolonialno/pr-deployment-controller/pkg/scm"
 	"github.com/sirupsen/logrus"
 
 	"github.com/google/go-github/github"
@@ -16,36 +21,60 @@ import (
 
 // Github defines the interface used to talk with Github.
 type Github interface {
-	// CloneBuild downloads the build from GitHub
-	CloneBuild(
+	// Provider implements CloneBuild, PostBuildStatus, PRComment and UpsertPRComment
+	scm.Provider
+
+	// CreateCheckRun creates a new in progress check run on a commit and returns the check run id
+	CreateCheckRun(
 		ctx context.Context,
 		owner,
 		repository,
-		ref string,
-	) (io.ReadCloser, error)
-	// PostBuildStatus updates the build status
-	PostBuildStatus(
+		ref,
+		name string,
+	) (int64, error)
+	// UpdateCheckRun updates the state and output of a check run
+	UpdateCheckRun(
 		ctx context.Context,
 		owner,
-		repository,
-		ref string,
-		state State,
-		description,
-		url string,
+		repository string,
+		checkRunID int64,
+		name string,
+		update *CheckRunUpdate,
 	) error
-	// PRComment comments in a PR
-	PRComment(
+	// CreateDeployment creates a new deployment for a commit and returns the deployment id
+	CreateDeployment(
+		ctx context.Context,
+		owner,
+		repository,
+		ref,
+		environment,
+		description string,
+		transient bool,
+	) (int64, error)
+	// CreateDeploymentStatus updates the state of a deployment
+	CreateDeploymentStatus(
 		ctx context.Context,
 		owner,
 		repository string,
-		pullRequestNumber int64,
-		comment string,
+		deploymentID int64,
+		state DeploymentState,
+		description,
+		environmentURL string,
 	) error
 
+	// GetPermissionLevel returns the repository permission granted to a user
+	GetPermissionLevel(ctx context.Context, owner, repository, user string) (Permission, error)
+
+	// ReactToComment adds a reaction to a PR/Issue comment
+	ReactToComment(ctx context.Context, owner, repository string, commentID int64, reaction Reaction) error
+
 	//
 	// Low-level access apis
 	//
 
+	// PullRequestURL returns the API url of a pull request
+	PullRequestURL(owner, repository string, pullRequestNumber int64) string
+
 	// Get exposes a generic http get function
 	Get(ctx context.Context, url string, body interface{}, v interface{}) (*http.Response, error)
 }
@@ -55,16 +84,51 @@ type baseGithub struct {
 	http           *http.Client
 	c              *github.Client
 	downloadClient *http.Client
+
+	// app is authenticated as the GitHub App (JWT), nil when authenticated as a user
+	app *github.Client
+
+	// login of the user creating the comments, resolved on first use
+	lock  sync.Mutex
+	login string
 }
 
-// New creates a new Github controller
-func New(logger *logrus.Entry, accessToken string) (Github, error) {
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: accessToken},
-	)
+// Config stores the config for the github controller
+type Config struct {
+	// AccessToken authenticates as a user (personal access token)
+	AccessToken string
 
+	// AppID and PrivateKeyFile authenticates as a GitHub App installation
+	AppID          int64
+	PrivateKeyFile string
+	// InstallationID authenticates the requests that don't target a repository, the app is used if empty
+	InstallationID int64
+}
+
+// New creates a new Github controller
+func New(logger *logrus.Entry, config *Config) (Github, error) {
 	// Base http client with authentication
-	httpClient := oauth2.NewClient(context.Background(), ts)
+	var httpClient *http.Client
+	var app *github.Client
+
+	switch {
+	case config.AppID != 0 && config.PrivateKeyFile != "":
+		transport, err := newAppTransport(logger, config.AppID, config.PrivateKeyFile, config.InstallationID)
+		if err != nil {
+			return nil, err
+		}
+
+		httpClient = &http.Client{Transport: transport}
+		app = transport.app
+	case config.AccessToken != "":
+		ts := oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: config.AccessToken},
+		)
+
+		httpClient = oauth2.NewClient(context.Background(), ts)
+	default:
+		return nil, ErrMissingCredentials
+	}
 	httpClient.Timeout = Timeout
 
 	c := github.NewClient(httpClient)
@@ -76,9 +140,15 @@ func New(logger *logrus.Entry, accessToken string) (Github, error) {
 		downloadClient: &http.Client{
 			Timeout: Timeout,
 		},
+		app: app,
 	}, nil
 }
 
+// Name returns the provider name
+func (g *baseGithub) Name() string {
+	return scm.GitHubProvider
+}
+
 // CloneBuild fetches the archive url from the Github API and downloads the archive
 func (g *baseGithub) CloneBuild(
 	ctx context.Context,
@@ -135,6 +205,116 @@ func (g *baseGithub) PostBuildStatus(
 	return err
 }
 
+// CreateCheckRun creates a new check run on a given commit
+func (g *baseGithub) CreateCheckRun(
+	ctx context.Context,
+	owner string,
+	repository string,
+	ref string,
+	name string,
+) (int64, error) {
+	checkRun, _, err := g.c.Checks.CreateCheckRun(ctx, owner, repository, github.CreateCheckRunOptions{
+		Name:      name,
+		HeadSHA:   ref,
+		Status:    github.String(CheckRunInProgress.String()),
+		StartedAt: &github.Timestamp{Time: time.Now()},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return checkRun.GetID(), nil
+}
+
+// UpdateCheckRun updates the status and output of a check run
+func (g *baseGithub) UpdateCheckRun(
+	ctx context.Context,
+	owner string,
+	repository string,
+	checkRunID int64,
+	name string,
+	update *CheckRunUpdate,
+) error {
+	options := github.UpdateCheckRunOptions{
+		Name:   name,
+		Status: github.String(update.Status.String()),
+		Output: &github.CheckRunOutput{
+			Title:   github.String(update.Title),
+			Summary: github.String(update.Summary),
+		},
+	}
+	if update.Text != "" {
+		options.Output.Text = github.String(update.Text)
+	}
+	if update.DetailsURL != "" {
+		options.DetailsURL = github.String(update.DetailsURL)
+	}
+	if update.Status == CheckRunCompleted {
+		options.Conclusion = github.String(update.Conclusion.String())
+		options.CompletedAt = &github.Timestamp{Time: time.Now()}
+	}
+
+	_, _, err := g.c.Checks.UpdateCheckRun(ctx, owner, repository, checkRunID, options)
+	return err
+}
+
+// CreateDeployment creates a deployment for an environment, transient environments are removed with the PR
+func (g *baseGithub) CreateDeployment(
+	ctx context.Context,
+	owner string,
+	repository string,
+	ref string,
+	environment string,
+	description string,
+	transient bool,
+) (int64, error) {
+	deployment, _, err := g.c.Repositories.CreateDeployment(ctx, owner, repository, &github.DeploymentRequest{
+		Ref:         github.String(ref),
+		Environment: github.String(environment),
+		Description: github.String(description),
+		// Don't merge the base branch into the ref or wait on commit statuses,
+		// the pending build status would block the deployment
+		AutoMerge:            github.Bool(false),
+		RequiredContexts:     &[]string{},
+		TransientEnvironment: github.Bool(transient),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deployment.GetID(), nil
+}
+
+// CreateDeploymentStatus creates a new status for a deployment
+func (g *baseGithub) CreateDeploymentStatus(
+	ctx context.Context,
+	owner string,
+	repository string,
+	deploymentID int64,
+	state DeploymentState,
+	description string,
+	environmentURL string,
+) error {
+	req, err := g.c.NewRequest(
+		"POST",
+		fmt.Sprintf("repos/%s/%s/deployments/%d/statuses", owner, repository, deploymentID),
+		&github.DeploymentStatusRequest{
+			State:          github.String(state.String()),
+			Description:    github.String(description),
+			EnvironmentURL: github.String(environmentURL),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// The queued and in_progress states and environment_url requires the flash and ant-man previews
+	req.Header.Set("Accept", mediaTypeDeploymentStatusPreview)
+
+	_, err = g.c.Do(ctx, req, nil)
+	return err
+}
+
 // PRComment creates a new comment on a PR
 func (g *baseGithub) PRComment(
 	ctx context.Context,
@@ -149,6 +329,111 @@ func (g *baseGithub) PRComment(
 	return err
 }
 
+// UpsertPRComment updates the PR comment containing the marker, a new comment is created if not found
+func (g *baseGithub) UpsertPRComment(
+	ctx context.Context,
+	owner,
+	repository string,
+	pullRequestNumber int64,
+	marker,
+	comment string,
+) error {
+	// Only comments created by the controller are updated, user comments may quote the marker
+	login, err := g.authenticatedLogin(ctx)
+	if err != nil {
+		return err
+	}
+
+	opt := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	for {
+		comments, res, err := g.c.Issues.ListComments(ctx, owner, repository, int(pullRequestNumber), opt)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range comments {
+			if strings.EqualFold(c.GetUser().GetLogin(), login) && strings.Contains(c.GetBody(), marker) {
+				_, _, err = g.c.Issues.EditComment(ctx, owner, repository, c.GetID(), &github.IssueComment{
+					Body: github.String(comment),
+				})
+				return err
+			}
+		}
+
+		if res.NextPage == 0 {
+			break
+		}
+		opt.Page = res.NextPage
+	}
+
+	return g.PRComment(ctx, owner, repository, pullRequestNumber, comment)
+}
+
+// authenticatedLogin returns the login of the user creating the comments, GitHub Apps comments
+// as the "<app slug>[bot]" user
+func (g *baseGithub) authenticatedLogin(ctx context.Context) (string, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.login != "" {
+		return g.login, nil
+	}
+
+	if g.app != nil {
+		app, _, err := g.app.Apps.Get(ctx, "")
+		if err != nil {
+			return "", err
+		}
+
+		// The app slug is the last part of the app url
+		g.login = path.Base(app.GetHTMLURL()) + "[bot]"
+	} else {
+		user, _, err := g.c.Users.Get(ctx, "")
+		if err != nil {
+			return "", err
+		}
+
+		g.login = user.GetLogin()
+	}
+
+	return g.login, nil
+}
+
+// GetPermissionLevel returns the repository permission granted to a user
+func (g *baseGithub) GetPermissionLevel(
+	ctx context.Context,
+	owner,
+	repository,
+	user string,
+) (Permission, error) {
+	level, _, err := g.c.Repositories.GetPermissionLevel(ctx, owner, repository, user)
+	if err != nil {
+		return NonePermission, err
+	}
+
+	return Permission(level.GetPermission()), nil
+}
+
+// ReactToComment adds a reaction to a PR/Issue comment
+func (g *baseGithub) ReactToComment(
+	ctx context.Context,
+	owner,
+	repository string,
+	commentID int64,
+	reaction Reaction,
+) error {
+	_, _, err := g.c.Reactions.CreateIssueCommentReaction(ctx, owner, repository, commentID, reaction.String())
+	return err
+}
+
+// PullRequestURL returns the API url of a pull request
+func (g *baseGithub) PullRequestURL(owner, repository string, pullRequestNumber int64) string {
+	return fmt.Sprintf("%srepos/%s/%s/pulls/%d", g.c.BaseURL.String(), owner, repository, pullRequestNumber)
+}
+
 func (g *baseGithub) Get(ctx context.Context, url string, body interface{}, v interface{}) (*http.Response, error) {
 	req, err := g.newRequest("GET", url, body)
 	if err != nil {
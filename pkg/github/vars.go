@@ -1,26 +1,153 @@
 package github
 
-import "time"
+import (
+	"errors"
+	"time"
+
+	"github.com/kolonialno/pr-deployment-controller/pkg/scm"
+)
 
 const (
+	// mediaTypeDeploymentStatusPreview enables the extended deployment statuses
+	mediaTypeDeploymentStatusPreview = "application/vnd.github.flash-preview+json, " +
+		"application/vnd.github.ant-man-preview+json"
+
 	// Timeout stores the timeout used by the github client
 	Timeout = 3 * time.Minute
+	// JWTLifetime defines the lifetime of the JWT used to authenticate as a GitHub App (max 10 minutes)
+	JWTLifetime = 9 * time.Minute
+	// TokenRefreshWindow defines how long before expiry an installation token is refreshed
+	TokenRefreshWindow = 5 * time.Minute
+)
+
+var (
+	// ErrMissingCredentials Error
+	ErrMissingCredentials = errors.New("either an access token or a GitHub App id and private key is required")
+	// ErrInvalidPrivateKey Error
+	ErrInvalidPrivateKey = errors.New("could not parse the GitHub App private key")
 )
 
 // State defines the type that represents different commit status states
-type State string
+type State = scm.State
 
 var (
 	// PendingState for running jobs
-	PendingState State = "pending"
+	PendingState = scm.PendingState
 	// SuccessState for success jobs
-	SuccessState State = "success"
+	SuccessState = scm.SuccessState
 	// ErrorState for jobs with system failure
-	ErrorState State = "error"
+	ErrorState = scm.ErrorState
 	// FailureState for jobs that failed
-	FailureState State = "failure"
+	FailureState = scm.FailureState
 )
 
-func (s *State) String() string {
+// CheckRunStatus defines the type that represents the check run statuses
+type CheckRunStatus string
+
+var (
+	// CheckRunInProgress for running check runs
+	CheckRunInProgress CheckRunStatus = "in_progress"
+	// CheckRunCompleted for finished check runs, requires a conclusion
+	CheckRunCompleted CheckRunStatus = "completed"
+)
+
+func (s *CheckRunStatus) String() string {
 	return string(*s)
 }
+
+// CheckRunConclusion defines the type that represents the check run conclusions
+type CheckRunConclusion string
+
+var (
+	// CheckRunSuccess for successful check runs
+	CheckRunSuccess CheckRunConclusion = "success"
+	// CheckRunFailure for failed check runs
+	CheckRunFailure CheckRunConclusion = "failure"
+	// CheckRunNeutral for skipped check runs
+	CheckRunNeutral CheckRunConclusion = "neutral"
+	// CheckRunCancelled for check runs replaced by a newer run
+	CheckRunCancelled CheckRunConclusion = "cancelled"
+)
+
+func (c *CheckRunConclusion) String() string {
+	return string(*c)
+}
+
+// CheckRunUpdate contains the values used to update a check run
+type CheckRunUpdate struct {
+	Status     CheckRunStatus
+	Conclusion CheckRunConclusion
+	DetailsURL string
+
+	// Output rendered by GitHub (markdown)
+	Title   string
+	Summary string
+	Text    string
+}
+
+// DeploymentState defines the type that represents the deployment states
+type DeploymentState string
+
+var (
+	// DeploymentQueued for deployments waiting on a build worker
+	DeploymentQueued DeploymentState = "queued"
+	// DeploymentInProgress for deployments being built and rolled out
+	DeploymentInProgress DeploymentState = "in_progress"
+	// DeploymentSuccess for deployments ready to receive traffic
+	DeploymentSuccess DeploymentState = "success"
+	// DeploymentFailure for failed deployments
+	DeploymentFailure DeploymentState = "failure"
+	// DeploymentInactive for removed environments
+	DeploymentInactive DeploymentState = "inactive"
+)
+
+func (s *DeploymentState) String() string {
+	return string(*s)
+}
+
+// Permission defines the type that represents the repository permission levels
+type Permission string
+
+var (
+	// NonePermission for users without access to the repository
+	NonePermission Permission = "none"
+	// ReadPermission for users with read access
+	ReadPermission Permission = "read"
+	// WritePermission for users with push access
+	WritePermission Permission = "write"
+	// AdminPermission for repository administrators
+	AdminPermission Permission = "admin"
+
+	// permissionRanks orders the permission levels, higher levels includes the lower levels
+	permissionRanks = map[Permission]int{
+		NonePermission:  0,
+		ReadPermission:  1,
+		WritePermission: 2,
+		AdminPermission: 3,
+	}
+)
+
+func (p *Permission) String() string {
+	return string(*p)
+}
+
+// Includes returns true if the permission grants the required permission level
+func (p Permission) Includes(required Permission) bool {
+	return permissionRanks[p] >= permissionRanks[required]
+}
+
+// Reaction defines the type that represents the comment reactions
+type Reaction string
+
+var (
+	// ThumbsUpReaction acknowledges an accepted command
+	ThumbsUpReaction Reaction = "+1"
+	// ThumbsDownReaction rejects a command
+	ThumbsDownReaction Reaction = "-1"
+	// ConfusedReaction marks an unknown or invalid command
+	ConfusedReaction Reaction = "confused"
+)
+
+func (r *Reaction) String() string {
+	return string(*r)
+}
@@ -0,0 +1,387 @@
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/kolonialno/pr-deployment-controller/pkg/scm"
+	"github.com/sirupsen/logrus"
+)
+
+// GitLab defines the interface used to talk with GitLab. Repositories are identified
+// by the project namespace (owner, may include subgroups) and the project path.
+type GitLab interface {
+	// Provider implements CloneBuild, PostBuildStatus, PRComment and UpsertPRComment
+	scm.Provider
+
+	// GetMergeRequest returns a merge request
+	GetMergeRequest(ctx context.Context, owner, repository string, mergeRequestIID int64) (*MergeRequest, error)
+
+	// GetAccessLevel returns the project access level granted to a user
+	GetAccessLevel(ctx context.Context, owner, repository string, userID int64) (AccessLevel, error)
+
+	// AwardEmoji adds an emoji reaction to a merge request note
+	AwardEmoji(ctx context.Context, owner, repository string, mergeRequestIID, noteID int64, name string) error
+}
+
+type baseGitLab struct {
+	logger *logrus.Entry
+	url    string
+	token  string
+	http   *http.Client
+
+	// userID of the token user, resolved on first use
+	lock   sync.Mutex
+	userID int64
+}
+
+// Config stores the config for the gitlab controller
+type Config struct {
+	// URL of the GitLab instance (https://gitlab.example.com)
+	URL string
+	// Token is a personal or project access token with the api scope
+	Token string
+}
+
+// MergeRequest contains the merge request values used by the controller
+type MergeRequest struct {
+	IID            int64    `json:"iid"`
+	SHA            string   `json:"sha"`
+	Draft          bool     `json:"draft"`
+	WorkInProgress bool     `json:"work_in_progress"` // Replaced by draft in GitLab 13.2
+	Labels         []string `json:"labels"`
+	Author         struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	SourceProjectID int64 `json:"source_project_id"`
+	TargetProjectID int64 `json:"target_project_id"`
+}
+
+// Fork returns true if the merge request is opened from another project
+func (m *MergeRequest) Fork() bool {
+	return m.SourceProjectID != m.TargetProjectID
+}
+
+// note is the subset of a merge request note used by the controller
+type note struct {
+	ID     int64  `json:"id"`
+	Body   string `json:"body"`
+	Author struct {
+		ID int64 `json:"id"`
+	} `json:"author"`
+}
+
+// New creates a new GitLab controller
+func New(logger *logrus.Entry, config *Config) (GitLab, error) {
+	if config.URL == "" {
+		return nil, ErrMissingURL
+	}
+	if config.Token == "" {
+		return nil, ErrMissingToken
+	}
+
+	return &baseGitLab{
+		logger: logger,
+		url:    strings.TrimSuffix(config.URL, "/"),
+		token:  config.Token,
+		http: &http.Client{
+			Timeout: Timeout,
+		},
+	}, nil
+}
+
+// Name returns the provider name
+func (g *baseGitLab) Name() string {
+	return scm.GitLabProvider
+}
+
+// CloneBuild downloads the repository archive through the repository archive API
+func (g *baseGitLab) CloneBuild(
+	ctx context.Context,
+	owner string,
+	repository string,
+	ref string,
+) (io.ReadCloser, error) {
+	req, err := g.newRequest(
+		"GET",
+		g.projectURL(owner, repository, "repository/archive.tar.gz?sha=%s", url.QueryEscape(ref)),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := g.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkResponse(resp); err != nil {
+		resp.Body.Close() // nolint: errcheck, gas
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// PostBuildStatus updates the commit status on a given commit
+func (g *baseGitLab) PostBuildStatus(
+	ctx context.Context,
+	owner string,
+	repository string,
+	ref string,
+	state scm.State,
+	description string,
+	targetURL string,
+) error {
+	status := map[string]string{
+		"state":       commitStates[state],
+		"name":        StatusName,
+		"description": description,
+	}
+	if targetURL != "" {
+		status["target_url"] = targetURL
+	}
+
+	_, err := g.request(ctx, "POST", g.projectURL(owner, repository, "statuses/%s", ref), status, nil)
+	return err
+}
+
+// PRComment adds a note to a merge request
+func (g *baseGitLab) PRComment(
+	ctx context.Context,
+	owner string,
+	repository string,
+	pullRequestNumber int64,
+	comment string,
+) error {
+	_, err := g.request(
+		ctx,
+		"POST",
+		g.projectURL(owner, repository, "merge_requests/%d/notes", pullRequestNumber),
+		map[string]string{"body": comment},
+		nil,
+	)
+	return err
+}
+
+// UpsertPRComment updates the merge request note containing the marker, a new note is created if not found
+func (g *baseGitLab) UpsertPRComment(
+	ctx context.Context,
+	owner string,
+	repository string,
+	pullRequestNumber int64,
+	marker string,
+	comment string,
+) error {
+	// Only notes created by the controller are updated, user notes may quote the marker
+	userID, err := g.currentUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	page := "1"
+
+	for page != "" {
+		var notes []*note
+
+		resp, err := g.request(ctx, "GET", g.projectURL(
+			owner,
+			repository,
+			"merge_requests/%d/notes?sort=asc&per_page=%d&page=%s",
+			pullRequestNumber,
+			notesPerPage,
+			page,
+		), nil, &notes)
+		if err != nil {
+			return err
+		}
+
+		for _, n := range notes {
+			if n.Author.ID == userID && strings.Contains(n.Body, marker) {
+				_, err = g.request(
+					ctx,
+					"PUT",
+					g.projectURL(owner, repository, "merge_requests/%d/notes/%d", pullRequestNumber, n.ID),
+					map[string]string{"body": comment},
+					nil,
+				)
+				return err
+			}
+		}
+
+		page = resp.Header.Get("X-Next-Page")
+	}
+
+	return g.PRComment(ctx, owner, repository, pullRequestNumber, comment)
+}
+
+// currentUserID returns the ID of the user authenticated by the access token
+func (g *baseGitLab) currentUserID(ctx context.Context) (int64, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.userID != 0 {
+		return g.userID, nil
+	}
+
+	var user struct {
+		ID int64 `json:"id"`
+	}
+	if _, err := g.request(ctx, "GET", fmt.Sprintf("%s/api/v4/user", g.url), nil, &user); err != nil {
+		return 0, err
+	}
+	g.userID = user.ID
+
+	return g.userID, nil
+}
+
+// GetMergeRequest returns a merge request
+func (g *baseGitLab) GetMergeRequest(
+	ctx context.Context,
+	owner string,
+	repository string,
+	mergeRequestIID int64,
+) (*MergeRequest, error) {
+	mergeRequest := &MergeRequest{}
+
+	_, err := g.request(
+		ctx, "GET", g.projectURL(owner, repository, "merge_requests/%d", mergeRequestIID), nil, mergeRequest,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeRequest, nil
+}
+
+// GetAccessLevel returns the project access level granted to a user, inherited
+// group memberships included
+func (g *baseGitLab) GetAccessLevel(
+	ctx context.Context,
+	owner string,
+	repository string,
+	userID int64,
+) (AccessLevel, error) {
+	var member struct {
+		AccessLevel AccessLevel `json:"access_level"`
+	}
+
+	resp, err := g.request(
+		ctx, "GET", g.projectURL(owner, repository, "members/all/%d", userID), nil, &member,
+	)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return NoAccess, nil
+	} else if err != nil {
+		return NoAccess, err
+	}
+
+	return member.AccessLevel, nil
+}
+
+// AwardEmoji adds an emoji reaction to a merge request note
+func (g *baseGitLab) AwardEmoji(
+	ctx context.Context,
+	owner string,
+	repository string,
+	mergeRequestIID int64,
+	noteID int64,
+	name string,
+) error {
+	_, err := g.request(
+		ctx,
+		"POST",
+		g.projectURL(owner, repository, "merge_requests/%d/notes/%d/award_emoji", mergeRequestIID, noteID),
+		map[string]string{"name": name},
+		nil,
+	)
+	return err
+}
+
+//
+// Internal methods
+//
+
+// projectURL creates the url to a project API resource, the project is
+// identified by the url encoded path with namespace
+func (g *baseGitLab) projectURL(owner, repository, format string, a ...interface{}) string {
+	project := strings.Replace(url.PathEscape(fmt.Sprintf("%s/%s", owner, repository)), "/", "%2F", -1)
+
+	return fmt.Sprintf("%s/api/v4/projects/%s/%s", g.url, project, fmt.Sprintf(format, a...))
+}
+
+// newRequest creates a new authenticated http request
+func (g *baseGitLab) newRequest(method, url string, body interface{}) (*http.Request, error) {
+	var buf io.ReadWriter
+	if body != nil {
+		buf = new(bytes.Buffer)
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, url, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("PRIVATE-TOKEN", g.token)
+
+	return req, nil
+}
+
+// request executes an API request and decodes the response into v
+func (g *baseGitLab) request(
+	ctx context.Context, method, url string, body interface{}, v interface{},
+) (*http.Response, error) {
+	req, err := g.newRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := g.http.Do(req.WithContext(ctx))
+	if err != nil {
+		// If we got an error, and the context has been canceled,
+		// the context's error is probably more useful.
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if err = checkResponse(resp); err != nil {
+		return resp, err
+	}
+
+	if v != nil {
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil && err != io.EOF {
+			return resp, err
+		}
+	}
+
+	return resp, nil
+}
+
+// checkResponse validates the response status code
+func checkResponse(resp *http.Response) error {
+	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
+		return fmt.Errorf("response status not in range [200, 300], actual code %d", resp.StatusCode)
+	}
+
+	return nil
+}
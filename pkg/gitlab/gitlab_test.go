@@ -0,0 +1,115 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kolonialno/pr-deployment-controller/pkg/scm"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestGitLab(t *testing.T, handler http.HandlerFunc) (GitLab, func()) {
+	server := httptest.NewServer(handler)
+
+	g, err := New(logrus.NewEntry(logrus.New()), &Config{URL: server.URL + "/", Token: "secret"})
+	assert.Nil(t, err)
+
+	return g, server.Close
+}
+
+func TestCloneBuild(t *testing.T) {
+	g, closeServer := newTestGitLab(t, func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("PRIVATE-TOKEN"))
+		assert.Equal(t, "/api/v4/projects/group%2Fsub%2Fproject/repository/archive.tar.gz", r.URL.EscapedPath())
+		assert.Equal(t, "abc123", r.URL.Query().Get("sha"))
+
+		rw.Write([]byte("archive")) // nolint: errcheck
+	})
+	defer closeServer()
+
+	archive, err := g.CloneBuild(context.Background(), "group/sub", "project", "abc123")
+	assert.Nil(t, err)
+	defer archive.Close() // nolint: errcheck
+
+	content, err := ioutil.ReadAll(archive)
+	assert.Nil(t, err)
+	assert.Equal(t, "archive", string(content))
+}
+
+func TestPostBuildStatus(t *testing.T) {
+	g, closeServer := newTestGitLab(t, func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/api/v4/projects/group%2Fproject/statuses/abc123", r.URL.EscapedPath())
+
+		var status map[string]string
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&status))
+		assert.Equal(t, "failed", status["state"])
+		assert.Equal(t, StatusName, status["name"])
+		assert.Equal(t, "Build failed", status["description"])
+		assert.Equal(t, "https://example.com", status["target_url"])
+
+		rw.WriteHeader(http.StatusCreated)
+	})
+	defer closeServer()
+
+	err := g.PostBuildStatus(
+		context.Background(), "group", "project", "abc123", scm.FailureState, "Build failed", "https://example.com",
+	)
+	assert.Nil(t, err)
+}
+
+func TestUpsertPRComment(t *testing.T) {
+	var updated, created bool
+
+	g, closeServer := newTestGitLab(t, func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v4/user":
+			rw.Write([]byte(`{"id": 9}`)) // nolint: errcheck
+		case r.Method == "GET" && r.URL.Query().Get("page") == "1":
+			rw.Header().Set("X-Next-Page", "2")
+			rw.Write([]byte(`[{"id": 1, "body": "unrelated", "author": {"id": 9}}]`)) // nolint: errcheck
+		case r.Method == "GET" && r.URL.Query().Get("page") == "2":
+			// Notes quoting the marker are only updated if created by the controller
+			notes := `[{"id": 3, "body": "> <!-- marker -->", "author": {"id": 4}}, ` +
+				`{"id": 2, "body": "<!-- marker --> old", "author": {"id": 9}}]`
+			rw.Write([]byte(notes)) // nolint: errcheck
+		case r.Method == "PUT":
+			assert.Equal(t, "/api/v4/projects/group%2Fproject/merge_requests/5/notes/2", r.URL.EscapedPath())
+			updated = true
+			rw.Write([]byte(`{}`)) // nolint: errcheck
+		default:
+			created = true
+			rw.WriteHeader(http.StatusCreated)
+		}
+	})
+	defer closeServer()
+
+	err := g.UpsertPRComment(context.Background(), "group", "project", 5, "<!-- marker -->", "<!-- marker --> new")
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	assert.False(t, created)
+}
+
+func TestGetAccessLevel(t *testing.T) {
+	g, closeServer := newTestGitLab(t, func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() == "/api/v4/projects/group%2Fproject/members/all/7" {
+			rw.Write([]byte(`{"id": 7, "access_level": 30}`)) // nolint: errcheck
+			return
+		}
+		rw.WriteHeader(http.StatusNotFound)
+	})
+	defer closeServer()
+
+	level, err := g.GetAccessLevel(context.Background(), "group", "project", 7)
+	assert.Nil(t, err)
+	assert.True(t, level.Includes(DeveloperAccess))
+
+	level, err = g.GetAccessLevel(context.Background(), "group", "project", 8)
+	assert.Nil(t, err)
+	assert.Equal(t, NoAccess, level)
+}
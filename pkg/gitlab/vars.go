@@ -0,0 +1,55 @@
+package gitlab
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kolonialno/pr-deployment-controller/pkg/scm"
+)
+
+const (
+	// Timeout stores the timeout used by the gitlab client
+	Timeout = 3 * time.Minute
+	// StatusName is the name of the commit status, matches the GitHub status context
+	StatusName = "test-environment"
+	// notesPerPage is the page size used when looking up merge request notes
+	notesPerPage = 100
+)
+
+var (
+	// ErrMissingURL Error
+	ErrMissingURL = errors.New("the GitLab url is required")
+	// ErrMissingToken Error
+	ErrMissingToken = errors.New("a GitLab access token is required")
+)
+
+// AccessLevel defines the type that represents the GitLab project access levels
+type AccessLevel int
+
+var (
+	// NoAccess for users without access to the project
+	NoAccess AccessLevel
+	// GuestAccess for guests
+	GuestAccess AccessLevel = 10
+	// ReporterAccess for users with read access
+	ReporterAccess AccessLevel = 20
+	// DeveloperAccess for users allowed to push to the project
+	DeveloperAccess AccessLevel = 30
+	// MaintainerAccess for project maintainers
+	MaintainerAccess AccessLevel = 40
+	// OwnerAccess for group owners
+	OwnerAccess AccessLevel = 50
+)
+
+// Includes returns true if the access level grants the required access level
+func (a AccessLevel) Includes(required AccessLevel) bool {
+	return a >= required
+}
+
+// commitStates maps the build states to GitLab commit status states
+var commitStates = map[scm.State]string{
+	scm.PendingState: "running",
+	scm.SuccessState: "success",
+	scm.ErrorState:   "failed",
+	scm.FailureState: "failed",
+}
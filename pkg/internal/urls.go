@@ -1,16 +1,57 @@
 package internal
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxBranchIdentifierLength limits the branch part of resource names and urls
+const maxBranchIdentifierLength = 40
+
+var invalidIdentifierChars = regexp.MustCompile("[^a-z0-9]+")
+
+// BuildIdentifier returns the value identifying a build inside a repository, the pull
+// request number or a DNS safe version of the branch name for branch builds
+func BuildIdentifier(pullRequestNumber int64, branch string) string {
+	if branch == "" {
+		return strconv.FormatInt(pullRequestNumber, 10)
+	}
+
+	identifier := strings.Trim(invalidIdentifierChars.ReplaceAllString(strings.ToLower(branch), "-"), "-")
+	if len(identifier) > maxBranchIdentifierLength {
+		identifier = strings.TrimRight(identifier[:maxBranchIdentifierLength], "-")
+	}
+
+	// Avoid collisions with pull request builds
+	if _, err := strconv.ParseInt(identifier, 10, 64); err == nil || identifier == "" {
+		identifier = "branch-" + identifier
+	}
+
+	return identifier
+}
+
+// OwnerName returns the owner as used in resource names, GitLab subgroups (group/subgroup) are
+// joined with a dash
+func OwnerName(owner string) string {
+	return strings.Replace(owner, "/", "-", -1)
+}
+
+// GenerateBuildName creates the name used by the build manifest and (prefixed) build namespace
+func GenerateBuildName(owner, repository, identifier string) string {
+	return fmt.Sprintf("%s-%s-%s", OwnerName(owner), repository, identifier)
+}
 
 // GenerateBuildURL creates the build URL that exposes the test-environment (without protocol prefix)
 func GenerateBuildURL(
-	owner, repository string, pullRequestNumber int64, clusterDomain string,
+	owner, repository, identifier string, clusterDomain string,
 ) string {
 	// dont include the owner in the url to reduce the url length
 	buildName := fmt.Sprintf(
-		"%s-%d",
+		"%s-%s",
 		repository,
-		pullRequestNumber,
+		identifier,
 	)
 
 	return fmt.Sprintf("%s.%s", buildName, clusterDomain)
@@ -18,14 +59,12 @@ func GenerateBuildURL(
 
 // GenerateLogsURL creates the url to access environment logs (without protocol prefix)
 func GenerateLogsURL(
-	buildPrefix, owner, repository string, pullRequestNumber int64, kibanaURL string,
+	buildPrefix, owner, repository, identifier string, kibanaURL string,
 ) string {
 	namespace := fmt.Sprintf(
-		"%s%s-%s-%d",
+		"%s%s",
 		buildPrefix,
-		owner,
-		repository,
-		pullRequestNumber,
+		GenerateBuildName(owner, repository, identifier),
 	)
 
 	return fmt.Sprintf(
@@ -0,0 +1,22 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildIdentifier(t *testing.T) {
+	assert.Equal(t, "42", BuildIdentifier(42, ""))
+	assert.Equal(t, "main", BuildIdentifier(0, "main"))
+	assert.Equal(t, "release-1-2", BuildIdentifier(0, "Release/1.2"))
+	assert.Equal(t, "branch-42", BuildIdentifier(0, "42"))
+	assert.Equal(t, "feature-a", BuildIdentifier(0, "feature/a-"))
+	assert.Len(t, BuildIdentifier(0, strings.Repeat("a", 60)), 40)
+}
+
+func TestGenerateBuildName(t *testing.T) {
+	assert.Equal(t, "kolonialno-test-42", GenerateBuildName("kolonialno", "test", "42"))
+	assert.Equal(t, "group-sub-test-main", GenerateBuildName("group/sub", "test", "main"))
+}
@@ -0,0 +1,48 @@
+package scm
+
+import (
+	"context"
+	"io"
+)
+
+// Provider defines the source control operations required to build and report on
+// pull requests (GitHub) and merge requests (GitLab).
+type Provider interface {
+	// Name returns the provider name, stored on the build manifests
+	Name() string
+	// CloneBuild downloads the repository archive (tar.gz) of a ref
+	CloneBuild(
+		ctx context.Context,
+		owner,
+		repository,
+		ref string,
+	) (io.ReadCloser, error)
+	// PostBuildStatus updates the build status on a commit
+	PostBuildStatus(
+		ctx context.Context,
+		owner,
+		repository,
+		ref string,
+		state State,
+		description,
+		url string,
+	) error
+	// PRComment comments in a pull/merge request
+	PRComment(
+		ctx context.Context,
+		owner,
+		repository string,
+		pullRequestNumber int64,
+		comment string,
+	) error
+	// UpsertPRComment updates the pull/merge request comment containing the marker, a new
+	// comment is created if not found
+	UpsertPRComment(
+		ctx context.Context,
+		owner,
+		repository string,
+		pullRequestNumber int64,
+		marker,
+		comment string,
+	) error
+}
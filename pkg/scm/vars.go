@@ -0,0 +1,26 @@
+package scm
+
+const (
+	// GitHubProvider is the name of the GitHub provider
+	GitHubProvider = "github"
+	// GitLabProvider is the name of the GitLab provider
+	GitLabProvider = "gitlab"
+)
+
+// State defines the type that represents different commit status states
+type State string
+
+var (
+	// PendingState for running jobs
+	PendingState State = "pending"
+	// SuccessState for success jobs
+	SuccessState State = "success"
+	// ErrorState for jobs with system failure
+	ErrorState State = "error"
+	// FailureState for jobs that failed
+	FailureState State = "failure"
+)
+
+func (s *State) String() string {
+	return string(*s)
+}
@@ -0,0 +1,106 @@
+package status
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// buildLogHandler renders the page streaming the output of the latest job for a build
+func (s *Status) buildLogHandler(rw http.ResponseWriter, r *http.Request) {
+	build, ok := mux.Vars(r)["build"]
+	if !ok {
+		errorHandler(rw, ErrMissingVar)
+		return
+	}
+
+	buildLog, ok := s.buildLogs.Get(build)
+	if !ok {
+		errorHandler(rw, ErrNoBuildLogFound, http.StatusNotFound)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, _ := template.New("buildLogTemplate").Parse(buildLogTemplate)
+	data := map[string]interface{}{
+		"Build": build,
+		"JobID": buildLog.JobID,
+	}
+
+	render(rw, r, tmpl, "buildLogTemplate", data)
+}
+
+// streamBuildLog writes the build log to a websocket connection, new lines are sent until
+// the job finishes
+func (s *Status) streamBuildLog(rw http.ResponseWriter, r *http.Request) {
+	build, ok := mux.Vars(r)["build"]
+	if !ok {
+		errorHandler(rw, ErrMissingVar)
+		return
+	}
+
+	buildLog, ok := s.buildLogs.Get(build)
+	if !ok {
+		errorHandler(rw, ErrNoBuildLogFound, http.StatusNotFound)
+		return
+	}
+
+	// Upgrade connections
+	ws, err := upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		errorHandler(rw, err)
+		return
+	}
+	defer ws.Close() // nolint: errcheck
+
+	// Read the client messages, processes the control frames and stops the stream once the
+	// client closes the connection
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(BuildLogPingInterval)
+	defer ping.Stop()
+
+	var offset int
+	for {
+		lines, next, finished, changed := buildLog.Read(offset)
+		offset = next
+
+		if len(lines) > 0 {
+			if err = ws.WriteMessage(websocket.TextMessage, []byte(strings.Join(lines, "\n")+"\n")); err != nil {
+				return
+			}
+		}
+
+		if finished {
+			ws.WriteMessage( // nolint: gas, errcheck
+				websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "job finished"),
+			)
+			return
+		}
+
+		// Wait for new output, the ping detects closed connections while the job is idle
+		select {
+		case <-changed:
+		case <-closed:
+			return
+		case <-ping.C:
+			if err = ws.WriteControl(
+				websocket.PingMessage, nil, time.Now().Add(BuildLogPingInterval),
+			); err != nil {
+				return
+			}
+		}
+	}
+}
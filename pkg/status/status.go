@@ -8,6 +8,7 @@ import (
 
 	"github.com/oklog/oklog/pkg/group"
 
+	"github.com/kolonialno/pr-deployment-controller/pkg/buildlog"
 	"github.com/kolonialno/pr-deployment-controller/pkg/k8s"
 
 	corev1 "k8s.io/api/core/v1"
@@ -42,10 +43,13 @@ type Status struct {
 	restconfig   *rest.Config
 	k8sEnv       *k8s.Environment
 	corev1client *corev1client.CoreV1Client
+	buildLogs    *buildlog.Store
 }
 
 // New creates a new instance of the status page server
-func New(logger *logrus.Entry, restconfig *rest.Config, k8sEnv *k8s.Environment) (*Status, error) {
+func New(
+	logger *logrus.Entry, restconfig *rest.Config, k8sEnv *k8s.Environment, buildLogs *buildlog.Store,
+) (*Status, error) {
 	r := mux.NewRouter()
 
 	status := &Status{
@@ -54,6 +58,7 @@ func New(logger *logrus.Entry, restconfig *rest.Config, k8sEnv *k8s.Environment)
 
 		restconfig: restconfig,
 		k8sEnv:     k8sEnv,
+		buildLogs:  buildLogs,
 	}
 
 	err := status.createCoreV1Client()
@@ -66,6 +71,12 @@ func New(logger *logrus.Entry, restconfig *rest.Config, k8sEnv *k8s.Environment)
 	r.Path("/term/{build}/{container}/{cmd}/").HandlerFunc(status.terminalHandler)
 	r.Path("/term/{build}/{container}/{cmd}/ws/").HandlerFunc(status.streamTerminal)
 
+	// Build logs streamed from the builder
+	if buildLogs != nil {
+		r.Path("/builds/{build}/logs/").HandlerFunc(status.buildLogHandler)
+		r.Path("/builds/{build}/logs/ws/").HandlerFunc(status.streamBuildLog)
+	}
+
 	// Generic statuspage before environment is ready
 	r.PathPrefix("/").HandlerFunc(status.statusHandler)
 
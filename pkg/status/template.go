@@ -77,5 +77,52 @@ const (
 	<script src="/term/__static/main.js"></script>
 	</body>
 </html>
+`
+	buildLogTemplate = `
+<!doctype html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<title>{{ .Build }} &bullet; build log</title>
+		<style>
+			body {
+				background-color: black;
+				color: #ddd;
+				margin: 20px;
+			}
+			h3 {
+				font-family: Walsheim, "Helvetica Neue", Helvetica, Arial, sans-serif;
+				font-weight: 400;
+			}
+			#log {
+				font-family: Menlo, Monaco, "Courier New", monospace;
+				font-size: 13px;
+				white-space: pre-wrap;
+				word-wrap: break-word;
+			}
+		</style>
+	</head>
+	<body>
+	<h3>{{ .Build }} &bullet; job {{ .JobID }} &bullet; <span id="state">running</span></h3>
+	<div id="log"></div>
+	<script type="text/javascript">
+		var log = document.getElementById("log");
+		var state = document.getElementById("state");
+		var protocol = window.location.protocol === "https:" ? "wss://" : "ws://";
+		var ws = new WebSocket(protocol + window.location.host + window.location.pathname + "ws/");
+
+		ws.onmessage = function(event) {
+			var follow = window.innerHeight + window.scrollY >= document.body.offsetHeight - 10;
+			log.appendChild(document.createTextNode(event.data));
+			if (follow) {
+				window.scrollTo(0, document.body.scrollHeight);
+			}
+		};
+		ws.onclose = function(event) {
+			state.textContent = event.code === 1000 ? "finished" : "disconnected, reload to resume";
+		};
+	</script>
+	</body>
+</html>
 `
 )
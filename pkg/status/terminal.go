@@ -10,6 +10,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	testenvironmentv1alpha1 "github.com/kolonialno/pr-deployment-controller/pkg/apis/testenvironment/v1alpha1"
+	"github.com/kolonialno/pr-deployment-controller/pkg/internal"
 	"github.com/kolonialno/pr-deployment-controller/pkg/k8s"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -53,11 +54,13 @@ func getpod(k *k8s.Environment, build, container, cmd string) (*corev1.Pod, []st
 
 	pods := &corev1.PodList{}
 	err = k.List(ctx, &client.ListOptions{Namespace: fmt.Sprintf(
-		"%s%s-%s-%d",
+		"%s%s",
 		k.BuildPrefix,
-		foundbuild.Spec.Git.Owner,
-		foundbuild.Spec.Git.Repository,
-		foundbuild.Spec.Git.PullRequestNumber,
+		internal.GenerateBuildName(
+			foundbuild.Spec.Git.Owner,
+			foundbuild.Spec.Git.Repository,
+			internal.BuildIdentifier(foundbuild.Spec.Git.PullRequestNumber, foundbuild.Spec.Git.Branch),
+		),
 	)}, pods)
 	if err != nil {
 		return nil, nil, err
@@ -1,6 +1,14 @@
 package status
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// BuildLogPingInterval defines how often idle build log streams are checked
+	BuildLogPingInterval = 30 * time.Second
+)
 
 var (
 	// ErrMissingVar Error
@@ -9,4 +17,6 @@ var (
 	ErrUnknownCommand = errors.New("unknown remote command")
 	// ErrNoPodFound Error
 	ErrNoPodFound = errors.New("no pod found")
+	// ErrNoBuildLogFound Error
+	ErrNoBuildLogFound = errors.New("no build log found")
 )
@@ -0,0 +1,151 @@
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/kolonialno/pr-deployment-controller/pkg/auth"
+	"github.com/kolonialno/pr-deployment-controller/pkg/builder"
+	"github.com/kolonialno/pr-deployment-controller/pkg/scm"
+)
+
+// registerAPI adds the authenticated API routes used to manage builds
+func (w *Webhook) registerAPI() {
+	w.r.HandleFunc("/api/v1/builds", w.authenticator.Handler(w.listBuildsHandler)).Methods(http.MethodGet)
+	w.r.HandleFunc("/api/v1/builds", w.authenticator.Handler(w.deleteBuildHandler)).Methods(http.MethodDelete)
+	w.r.HandleFunc("/api/v1/builds/rebuild", w.authenticator.Handler(w.rebuildHandler)).Methods(http.MethodPost)
+	w.r.HandleFunc("/api/v1/builds/summary", w.authenticator.Handler(w.buildSummaryHandler)).Methods(http.MethodGet)
+	w.r.HandleFunc("/api/v1/jobs", w.authenticator.Handler(w.jobStatusHandler)).Methods(http.MethodGet)
+}
+
+// apiRequest returns the pull request targeted by an API request, identified by the provider
+// (github if empty), owner, repository and number query parameters
+func (w *Webhook) apiRequest(r *http.Request) (*commandRequest, error) {
+	query := r.URL.Query()
+
+	number, err := strconv.ParseInt(query.Get("number"), 10, 64)
+	if err != nil || number <= 0 || query.Get("owner") == "" || query.Get("repository") == "" {
+		return nil, ErrInvalidAPIRequest
+	}
+
+	req := &commandRequest{
+		provider:          query.Get("provider"),
+		owner:             query.Get("owner"),
+		repository:        query.Get("repository"),
+		pullRequestNumber: number,
+		user:              fmt.Sprintf("api:%s", auth.Client(r.Context())),
+	}
+
+	switch req.provider {
+	case "", scm.GitHubProvider:
+		req.provider = scm.GitHubProvider
+		req.pullRequestURL = w.g.PullRequestURL(req.owner, req.repository, req.pullRequestNumber)
+	case scm.GitLabProvider:
+		if w.gitlab == nil {
+			return nil, ErrProviderNotEnabled
+		}
+	default:
+		return nil, ErrProviderNotEnabled
+	}
+
+	return req, nil
+}
+
+// listBuildsHandler lists the builds of every enabled provider, optionally filtered by the owner
+// and repository query parameters
+func (w *Webhook) listBuildsHandler(rw http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	builds, err := w.b.ListBuilds(r.Context(), query.Get("owner"), query.Get("repository"))
+	if err != nil {
+		errorHandler(rw, err)
+		return
+	}
+
+	if w.gitlab != nil {
+		gitlabBuilds, gitlabErr := w.gitlab.Builder.ListBuilds(r.Context(), query.Get("owner"), query.Get("repository"))
+		if gitlabErr != nil {
+			errorHandler(rw, gitlabErr)
+			return
+		}
+		builds = append(builds, gitlabBuilds...)
+	}
+
+	jsonHandler(rw, http.StatusOK, builds)
+}
+
+// buildSummaryHandler returns the summary of the build deployed for a pull request
+func (w *Webhook) buildSummaryHandler(rw http.ResponseWriter, r *http.Request) {
+	req, err := w.apiRequest(r)
+	if err != nil {
+		errorHandler(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	summary, err := w.builder(req).BuildSummary(r.Context(), req.owner, req.repository, req.pullRequestNumber)
+	if err == builder.ErrBuildNotFound {
+		errorHandler(rw, err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		errorHandler(rw, err)
+		return
+	}
+
+	jsonHandler(rw, http.StatusOK, summary)
+}
+
+// rebuildHandler queues a new build of the pull request head, the build is forced and the
+// database is recreated if the clean query parameter is true
+func (w *Webhook) rebuildHandler(rw http.ResponseWriter, r *http.Request) {
+	req, err := w.apiRequest(r)
+	if err != nil {
+		errorHandler(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	clean, _ := strconv.ParseBool(r.URL.Query().Get("clean")) // nolint: gosec, errcheck
+
+	if err = w.rebuild(r.Context(), req, clean); err != nil {
+		errorHandler(rw, err, http.StatusNotAcceptable)
+		return
+	}
+
+	rw.WriteHeader(http.StatusAccepted) // nolint: gosec, gas
+}
+
+// deleteBuildHandler queues the deletion of the build deployed for a pull request
+func (w *Webhook) deleteBuildHandler(rw http.ResponseWriter, r *http.Request) {
+	req, err := w.apiRequest(r)
+	if err != nil {
+		errorHandler(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	if err = w.builder(req).DeleteBuild(r.Context(), req.owner, req.repository, req.pullRequestNumber); err != nil {
+		errorHandler(rw, err, http.StatusNotAcceptable)
+		return
+	}
+
+	rw.WriteHeader(http.StatusAccepted) // nolint: gosec, gas
+}
+
+// jobStatusHandler returns the status of the latest job queued for a pull request
+func (w *Webhook) jobStatusHandler(rw http.ResponseWriter, r *http.Request) {
+	req, err := w.apiRequest(r)
+	if err != nil {
+		errorHandler(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	status, err := w.builder(req).JobStatus(r.Context(), req.owner, req.repository, req.pullRequestNumber)
+	if err == builder.ErrJobNotFound {
+		errorHandler(rw, err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		errorHandler(rw, err)
+		return
+	}
+
+	jsonHandler(rw, http.StatusOK, status)
+}
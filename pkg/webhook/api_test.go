@@ -0,0 +1,32 @@
+package webhook
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIRequest(t *testing.T) {
+	w := &Webhook{}
+
+	_, err := w.apiRequest(httptest.NewRequest("GET", "/api/v1/jobs?owner=kolonialno&repository=test", nil))
+	assert.Equal(t, ErrInvalidAPIRequest, err)
+
+	_, err = w.apiRequest(httptest.NewRequest("GET", "/api/v1/jobs?owner=kolonialno&repository=test&number=x", nil))
+	assert.Equal(t, ErrInvalidAPIRequest, err)
+
+	_, err = w.apiRequest(httptest.NewRequest(
+		"GET", "/api/v1/jobs?provider=gitlab&owner=group/sub&repository=test&number=3", nil,
+	))
+	assert.Equal(t, ErrProviderNotEnabled, err)
+
+	w.gitlab = &GitLabOptions{}
+	req, err := w.apiRequest(httptest.NewRequest(
+		"GET", "/api/v1/jobs?provider=gitlab&owner=group/sub&repository=test&number=3", nil,
+	))
+	assert.Nil(t, err)
+	assert.Equal(t, "group/sub", req.owner)
+	assert.Equal(t, int64(3), req.pullRequestNumber)
+	assert.Equal(t, "api:", req.user)
+}
@@ -0,0 +1,498 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kolonialno/pr-deployment-controller/pkg/builder"
+	"github.com/kolonialno/pr-deployment-controller/pkg/comment"
+	"github.com/kolonialno/pr-deployment-controller/pkg/github"
+	"github.com/kolonialno/pr-deployment-controller/pkg/scm"
+	"github.com/sirupsen/logrus"
+)
+
+// CommandPrefix defines the prefix used to identify PR comment commands
+const CommandPrefix = "/"
+
+// MinCommitSHALength defines the shortest commit sha prefix accepted by /approve
+const MinCommitSHALength = 7
+
+// commandRequest contains the PR context a command is executed in
+type commandRequest struct {
+	provider          string
+	owner             string
+	repository        string
+	pullRequestNumber int64
+	pullRequestURL    string // GitHub only
+	commentID         int64
+	user              string
+	userID            int64 // GitLab only
+}
+
+// Command defines a command triggered by a PR comment
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+
+	// Permission defines the repository permission required to execute the command
+	Permission github.Permission
+
+	// Handler executes the command, ErrInvalidCommandArguments replies with the command usage
+	Handler func(ctx context.Context, req *commandRequest, args []string) error
+}
+
+// parsedCommand contains a command parsed from a comment
+type parsedCommand struct {
+	name string
+	args []string
+}
+
+// parseCommands returns the commands in a comment body, commands must start a line.
+// Quoted lines and code blocks are ignored.
+func parseCommands(body string) []*parsedCommand {
+	var commands []*parsedCommand
+
+	inCodeBlock := false
+
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~") {
+			inCodeBlock = !inCodeBlock
+			continue
+		}
+		if inCodeBlock || !strings.HasPrefix(line, CommandPrefix) {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		name := strings.ToLower(strings.TrimPrefix(fields[0], CommandPrefix))
+		if name == "" {
+			continue
+		}
+
+		commands = append(commands, &parsedCommand{
+			name: name,
+			args: fields[1:],
+		})
+	}
+
+	return commands
+}
+
+// registerCommand makes a command available through PR comments
+func (w *Webhook) registerCommand(command *Command) {
+	w.commands[command.Name] = command
+}
+
+// registerCommands registers the built-in commands
+func (w *Webhook) registerCommands() {
+	w.registerCommand(&Command{
+		Name:        "rebuild",
+		Description: "issue a new deployment to the test-environment based on the latest commit",
+		Permission:  github.WritePermission,
+		Handler: func(ctx context.Context, req *commandRequest, args []string) error {
+			if len(args) != 0 {
+				return ErrInvalidCommandArguments
+			}
+
+			return w.rebuild(ctx, req, false)
+		},
+	})
+
+	w.registerCommand(&Command{
+		Name:        "clean",
+		Description: "remove the current test-environment build if exists and issue a new deployment based on the latest commit",
+		Permission:  github.WritePermission,
+		Handler: func(ctx context.Context, req *commandRequest, args []string) error {
+			if len(args) != 0 {
+				return ErrInvalidCommandArguments
+			}
+
+			return w.rebuild(ctx, req, true)
+		},
+	})
+
+	w.registerCommand(&Command{
+		Name:        "approve",
+		Usage:       "<reviewed commit sha>",
+		Description: "approve building the reviewed commit of a PR from a fork or an untrusted author",
+		Permission:  github.WritePermission,
+		Handler: func(ctx context.Context, req *commandRequest, args []string) error {
+			if len(args) != 1 || !validCommitSHA(args[0]) {
+				return ErrInvalidCommandArguments
+			}
+
+			return w.approve(ctx, req, strings.ToLower(args[0]))
+		},
+	})
+
+	w.registerCommand(&Command{
+		Name:        "destroy",
+		Description: "remove the test-environment while the PR stays open",
+		Permission:  github.WritePermission,
+		Handler: func(ctx context.Context, req *commandRequest, args []string) error {
+			if len(args) != 0 {
+				return ErrInvalidCommandArguments
+			}
+
+			return w.builder(req).DeleteBuild(ctx, req.owner, req.repository, req.pullRequestNumber)
+		},
+	})
+
+	w.registerCommand(&Command{
+		Name:        "extend",
+		Usage:       "<duration, e.g. 72h>",
+		Description: "postpone the automatic removal of the test-environment",
+		Permission:  github.WritePermission,
+		Handler: func(ctx context.Context, req *commandRequest, args []string) error {
+			if len(args) != 1 {
+				return ErrInvalidCommandArguments
+			}
+
+			duration, err := time.ParseDuration(args[0])
+			if err != nil || duration <= 0 {
+				return ErrInvalidCommandArguments
+			}
+
+			expiry, err := w.builder(req).ExtendBuild(ctx, req.owner, req.repository, req.pullRequestNumber, duration)
+			if err == builder.ErrBuildNotFound {
+				return w.reply(ctx, req, "No test-environment is deployed for this PR.")
+			} else if err != nil {
+				return err
+			}
+
+			return w.reply(ctx, req, fmt.Sprintf(
+				"The test-environment is removed after %s.", expiry.UTC().Format(comment.TimeFormat),
+			))
+		},
+	})
+
+	w.registerCommand(&Command{
+		Name:        "status",
+		Description: "summarise the deployed test-environment",
+		Permission:  github.ReadPermission,
+		Handler: func(ctx context.Context, req *commandRequest, args []string) error {
+			if len(args) != 0 {
+				return ErrInvalidCommandArguments
+			}
+
+			summary, err := w.builder(req).BuildSummary(ctx, req.owner, req.repository, req.pullRequestNumber)
+			if err == builder.ErrBuildNotFound {
+				return w.reply(ctx, req, "No test-environment is deployed for this PR.")
+			} else if err != nil {
+				return err
+			}
+
+			return w.reply(ctx, req, renderSummary(summary))
+		},
+	})
+
+	w.registerCommand(&Command{
+		Name:        "help",
+		Description: "list the available commands",
+		Permission:  github.ReadPermission,
+		Handler: func(ctx context.Context, req *commandRequest, args []string) error {
+			return w.reply(ctx, req, w.help())
+		},
+	})
+}
+
+// handleCommands executes the commands found in a PR comment
+func (w *Webhook) handleCommands(ctx context.Context, req *commandRequest, body string) error {
+	parsed := parseCommands(body)
+	if len(parsed) == 0 {
+		return nil
+	}
+
+	logger := w.logger.WithFields(logrus.Fields{"user": req.user, "provider": req.provider})
+
+	// react acknowledges the comment, failures are logged only
+	react := func(reaction github.Reaction) {
+		if err := w.react(ctx, req, reaction); err != nil {
+			logger.WithError(err).Warn("could not react to comment")
+		}
+	}
+
+	// The permission is looked up once, before any reply is posted
+	permission, err := w.permission(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range parsed {
+		logger := logger.WithField("command", p.name)
+
+		command, ok := w.commands[p.name]
+		if !ok {
+			logger.Info("unknown command")
+			react(github.ConfusedReaction)
+
+			// Only collaborators get the help reply, anyone can comment on public repositories
+			if !permission.Includes(github.WritePermission) {
+				continue
+			}
+			if err := w.reply(ctx, req, fmt.Sprintf(
+				"Unknown command `%s%s`.\n\n%s", CommandPrefix, p.name, w.help(),
+			)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !permission.Includes(command.Permission) {
+			logger.WithField("permission", permission.String()).Warn("command not permitted")
+			react(github.ThumbsDownReaction)
+			continue
+		}
+
+		logger.Info("executing command")
+
+		err := command.Handler(ctx, req, p.args)
+		if err == ErrInvalidCommandArguments {
+			react(github.ConfusedReaction)
+			if err = w.reply(ctx, req, fmt.Sprintf(
+				"Invalid arguments, usage: `%s`", command.usage(),
+			)); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		react(github.ThumbsUpReaction)
+	}
+
+	return nil
+}
+
+// pullRequest contains the PR values used to queue a build
+type pullRequest struct {
+	number int64
+	sha    string
+	author string
+	labels []string
+	fork   bool
+	draft  bool
+}
+
+// fetchPullRequest returns the PR the command was issued on, the comment payloads
+// doesn't contain the PR head
+func (w *Webhook) fetchPullRequest(ctx context.Context, req *commandRequest) (*pullRequest, error) {
+	if req.provider == scm.GitLabProvider {
+		return w.fetchMergeRequest(ctx, req)
+	}
+
+	var response PullRequestResponse
+	res, err := w.g.Get(ctx, req.pullRequestURL, nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close() // nolint: errcheck
+
+	return &pullRequest{
+		number: response.Number,
+		sha:    response.Head.Sha,
+		author: response.User.Login,
+		labels: labelNames(response.Labels),
+		fork:   fromFork(response.Head.Repo, response.Base.Repo.FullName),
+		draft:  response.Draft,
+	}, nil
+}
+
+// rebuild initializes a new build based on the PR head
+func (w *Webhook) rebuild(ctx context.Context, req *commandRequest, clean bool) error {
+	pullRequest, err := w.fetchPullRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return w.builder(req).NewBuild(ctx, builder.BuildOptions{
+		Owner:      req.owner,
+		Repository: req.repository,
+		Number:     pullRequest.number,
+		SHA:        pullRequest.sha,
+		User:       req.user,
+		Author:     pullRequest.author,
+		Labels:     pullRequest.labels,
+		Fork:       pullRequest.fork,
+		Draft:      pullRequest.draft,
+		Clean:      clean,
+		Force:      true,
+	})
+}
+
+// approve approves building the PR head and initializes a new build, the sha must match
+// the PR head to avoid approving a commit pushed after the review
+func (w *Webhook) approve(ctx context.Context, req *commandRequest, sha string) error {
+	pullRequest, err := w.fetchPullRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(pullRequest.sha, sha) {
+		return w.reply(ctx, req, fmt.Sprintf(
+			"Commit `%s` is not the PR head (`%s`), review the latest commit before approving it.",
+			sha, pullRequest.sha,
+		))
+	}
+
+	if err = w.builder(req).ApproveBuild(
+		ctx, req.owner, req.repository, pullRequest.number, pullRequest.sha, req.user,
+	); err != nil {
+		return err
+	}
+
+	return w.builder(req).NewBuild(ctx, builder.BuildOptions{
+		Owner:      req.owner,
+		Repository: req.repository,
+		Number:     pullRequest.number,
+		SHA:        pullRequest.sha,
+		User:       req.user,
+		Author:     pullRequest.author,
+		Labels:     pullRequest.labels,
+		Fork:       pullRequest.fork,
+		Draft:      pullRequest.draft,
+	})
+}
+
+// validCommitSHA returns true if the value is a full commit sha or a hex prefix long enough
+// to not be guessed
+func validCommitSHA(sha string) bool {
+	if len(sha) < MinCommitSHALength || len(sha) > 64 {
+		return false
+	}
+
+	for _, c := range strings.ToLower(sha) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reply comments on the PR the command was issued on
+func (w *Webhook) reply(ctx context.Context, req *commandRequest, body string) error {
+	if req.provider == scm.GitLabProvider {
+		return w.gitlab.GitLab.PRComment(ctx, req.owner, req.repository, req.pullRequestNumber, body)
+	}
+
+	return w.g.PRComment(ctx, req.owner, req.repository, req.pullRequestNumber, body)
+}
+
+// react adds a reaction to the comment the command was issued in
+func (w *Webhook) react(ctx context.Context, req *commandRequest, reaction github.Reaction) error {
+	if req.provider == scm.GitLabProvider {
+		return w.gitlab.GitLab.AwardEmoji(
+			ctx, req.owner, req.repository, req.pullRequestNumber, req.commentID, gitlabEmojis[reaction],
+		)
+	}
+
+	return w.g.ReactToComment(ctx, req.owner, req.repository, req.commentID, reaction)
+}
+
+// permission returns the repository permission granted to the user issuing the command
+func (w *Webhook) permission(ctx context.Context, req *commandRequest) (github.Permission, error) {
+	if req.provider == scm.GitLabProvider {
+		level, err := w.gitlab.GitLab.GetAccessLevel(ctx, req.owner, req.repository, req.userID)
+		if err != nil {
+			return github.NonePermission, err
+		}
+
+		return gitlabPermission(level), nil
+	}
+
+	return w.g.GetPermissionLevel(ctx, req.owner, req.repository, req.user)
+}
+
+// builder returns the builder responsible for builds from the command provider
+func (w *Webhook) builder(req *commandRequest) builder.Builder {
+	if req.provider == scm.GitLabProvider {
+		return w.gitlab.Builder
+	}
+
+	return w.b
+}
+
+// help renders the list of available commands
+func (w *Webhook) help() string {
+	names := make([]string, 0, len(w.commands))
+	for name := range w.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{"Available test-environment commands:"}
+	for _, name := range names {
+		command := w.commands[name]
+		lines = append(lines, fmt.Sprintf(
+			"- `%s` %s (requires %s access)", command.usage(), command.Description, command.Permission,
+		))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// usage returns the command usage, including the arguments
+func (c *Command) usage() string {
+	if c.Usage == "" {
+		return CommandPrefix + c.Name
+	}
+
+	return fmt.Sprintf("%s%s %s", CommandPrefix, c.Name, c.Usage)
+}
+
+// renderSummary renders a build summary as markdown
+func renderSummary(summary *builder.BuildSummary) string {
+	deployedAt := "never"
+	if summary.DeployedAt != nil {
+		deployedAt = summary.DeployedAt.UTC().Format(comment.TimeFormat)
+	}
+
+	lines := []string{
+		fmt.Sprintf("**Build** `%s` (environment `%s`)", summary.Name, summary.Environment),
+		"",
+		fmt.Sprintf("- Commit: `%s`", summary.Ref),
+		fmt.Sprintf("- Image: `%s`", summary.Image),
+	}
+
+	names := make([]string, 0, len(summary.Images))
+	for name := range summary.Images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("- Image `%s`: `%s`", name, summary.Images[name]))
+	}
+
+	lines = append(
+		lines,
+		fmt.Sprintf("- Last deploy: %s", deployedAt),
+		fmt.Sprintf("- Removed after: %s", summary.ExpiresAt.UTC().Format(comment.TimeFormat)),
+	)
+	if summary.Database != "" {
+		lines = append(lines, fmt.Sprintf("- Database: `%s`", summary.Database))
+	}
+
+	lines = append(lines, "", "**Pods**", "", "| Pod | Phase | Ready | Restarts |", "| --- | --- | --- | --- |")
+	for _, pod := range summary.Pods {
+		ready := "❌"
+		if pod.Ready {
+			ready = "✅"
+		}
+		lines = append(lines, fmt.Sprintf("| %s | %s | %s | %d |", pod.Name, pod.Phase, ready, pod.Restarts))
+	}
+
+	lines = append(lines, "", "**Routes**", "")
+	for _, route := range summary.Routes {
+		lines = append(lines, fmt.Sprintf("- %s", route))
+	}
+
+	return strings.Join(lines, "\n")
+}
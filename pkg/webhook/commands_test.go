@@ -0,0 +1,44 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCommands(t *testing.T) {
+	commands := parseCommands("/rebuild\n  /Clean now please\nnot a /rebuild command")
+
+	assert.Len(t, commands, 2)
+	assert.Equal(t, "rebuild", commands[0].name)
+	assert.Empty(t, commands[0].args)
+	assert.Equal(t, "clean", commands[1].name)
+	assert.Equal(t, []string{"now", "please"}, commands[1].args)
+}
+
+func TestParseCommandsIgnoresQuotesAndCode(t *testing.T) {
+	commands := parseCommands("> /rebuild\n```\n/clean\n```\nComment `/rebuild` to deploy\n/")
+
+	assert.Empty(t, commands)
+}
+
+func TestValidCommitSHA(t *testing.T) {
+	assert.True(t, validCommitSHA("3f2a9c1"))
+	assert.True(t, validCommitSHA("3F2A9C1D8E7B6A5F4E3D2C1B0A9F8E7D6C5B4A39"))
+	assert.False(t, validCommitSHA("3"), "guessable prefix")
+	assert.False(t, validCommitSHA("3f2a9c"), "guessable prefix")
+	assert.False(t, validCommitSHA("master1"), "not hex")
+}
+
+func TestApproveRequiresSHA(t *testing.T) {
+	w := &Webhook{commands: map[string]*Command{}}
+	w.registerCommands()
+	approve := w.commands["approve"]
+
+	// The arguments are validated before the PR is fetched
+	assert.Equal(t, ErrInvalidCommandArguments, approve.Handler(context.Background(), &commandRequest{}, nil))
+	assert.Equal(
+		t, ErrInvalidCommandArguments, approve.Handler(context.Background(), &commandRequest{}, []string{"3f"}),
+	)
+}
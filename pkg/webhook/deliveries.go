@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kolonialno/pr-deployment-controller/pkg/delivery"
+)
+
+// newDelivery creates the delivery record of a parsed payload
+func newDelivery(id string, event Event, payload interface{}) *delivery.Delivery {
+	d := &delivery.Delivery{
+		ID:         id,
+		Event:      string(event),
+		ReceivedAt: time.Now(),
+		Outcome:    delivery.PendingOutcome,
+	}
+
+	switch payload := payload.(type) {
+	case PullRequestPayload:
+		d.Action = payload.Action
+		d.Repository = payload.PullRequest.Base.Repo.FullName
+		d.PullRequestNumber = payload.Number
+	case IssueCommentPayload:
+		d.Action = payload.Action
+		d.Repository = fmt.Sprintf("%s/%s", payload.Repository.Owner.Login, payload.Repository.Name)
+		d.PullRequestNumber = payload.Issue.Number
+	case CheckRunPayload:
+		d.Action = payload.Action
+		d.Repository = fmt.Sprintf("%s/%s", payload.Repository.Owner.Login, payload.Repository.Name)
+		if len(payload.CheckRun.PullRequests) > 0 {
+			d.PullRequestNumber = payload.CheckRun.PullRequests[0].Number
+		}
+	case PushPayload:
+		d.Action = payload.Ref
+		d.Repository = fmt.Sprintf("%s/%s", payload.Repository.Owner.Login, payload.Repository.Name)
+	case WorkflowRunPayload:
+		d.Action = payload.Action
+		d.Repository = fmt.Sprintf("%s/%s", payload.Repository.Owner.Login, payload.Repository.Name)
+	case RepositoryDispatchPayload:
+		d.Action = payload.Action
+		d.Repository = fmt.Sprintf("%s/%s", payload.Repository.Owner.Login, payload.Repository.Name)
+	}
+
+	return d
+}
+
+// listDeliveriesHandler lists the recent deliveries, newest first
+func (w *Webhook) listDeliveriesHandler(rw http.ResponseWriter, r *http.Request) {
+	deliveries, err := w.deliveries.List(r.Context())
+	if err != nil {
+		errorHandler(rw, err)
+		return
+	}
+
+	jsonHandler(rw, http.StatusOK, deliveries)
+}
+
+// replayDeliveryHandler handles a stored delivery again, the duplicate check is skipped
+func (w *Webhook) replayDeliveryHandler(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	d, body, err := w.deliveries.Get(ctx, id)
+	if err == delivery.ErrDeliveryNotFound || err == delivery.ErrPayloadNotStored {
+		errorHandler(rw, err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		errorHandler(rw, err)
+		return
+	}
+
+	w.logger.WithField("delivery", id).Info("replaying delivery")
+
+	// The signature was verified when the delivery was received
+	payload, err := parsePayload(Event(d.Event), body)
+	if err != nil {
+		errorHandler(rw, err, http.StatusNotAcceptable)
+		return
+	}
+
+	handleErr := w.handlePayload(ctx, payload)
+	if err = w.deliveries.Complete(ctx, id, true, handleErr); err != nil {
+		w.logger.WithError(err).Warn("could not record delivery outcome")
+	}
+
+	if handleErr != nil {
+		errorHandler(rw, handleErr, http.StatusNotAcceptable)
+		return
+	}
+
+	if d, _, err = w.deliveries.Get(ctx, id); err != nil {
+		errorHandler(rw, err)
+		return
+	}
+
+	jsonHandler(rw, http.StatusAccepted, d)
+}
@@ -0,0 +1,214 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/kolonialno/pr-deployment-controller/pkg/builder"
+	"github.com/kolonialno/pr-deployment-controller/pkg/github"
+	"github.com/kolonialno/pr-deployment-controller/pkg/gitlab"
+	"github.com/kolonialno/pr-deployment-controller/pkg/scm"
+)
+
+// GitLabOptions enables the GitLab webhook, merge requests are built by a separate
+// builder reporting through GitLab
+type GitLabOptions struct {
+	Builder builder.Builder
+	GitLab  gitlab.GitLab
+
+	// Secrets accepted in the X-Gitlab-Token header, multiple secrets allows secret rotation
+	Secrets []string
+	// Username of the token owner, used to filter notes
+	Username string
+}
+
+// gitlabEmojis maps the command reactions to GitLab award emojis
+var gitlabEmojis = map[github.Reaction]string{
+	github.ThumbsUpReaction:   "thumbsup",
+	github.ThumbsDownReaction: "thumbsdown",
+	github.ConfusedReaction:   "confused",
+}
+
+// nolint: gocyclo
+func (w *Webhook) gitlabWebhookHandler(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	payload, err := w.ParseGitLab(r)
+	if err != nil {
+		w.logger.WithError(err).Warn("could not parese gitlab webhook payload")
+		errorHandler(rw, err)
+		return
+	}
+
+	// Generic error handler used if one of the steps returns an error
+	handleErr := func(err error) {
+		w.logger.WithError(err).Warn("could not handle gitlab webhook payload")
+		errorHandler(rw, err, http.StatusNotAcceptable)
+	}
+
+	switch payload := payload.(type) {
+	case GitLabMergeRequestPayload:
+
+		w.logger.Info("received gitlab merge request payload")
+
+		owner, repository := splitProjectPath(payload.Project.PathWithNamespace)
+		attributes := payload.ObjectAttributes
+		labels := gitLabLabelNames(payload.Labels)
+		draft := attributes.Draft || attributes.WorkInProgress
+		labelsAdded, labelsRemoved := payload.labelChanges()
+
+		// Only the deploy label of the environment triggers a build, a build for other labels
+		// would supersede the running build of the commit
+		deploy := false
+		if attributes.Action == "update" && attributes.OldRev == "" && len(labelsAdded) > 0 {
+			deploy, err = deployLabelAdded(ctx, w.gitlab.Builder, owner, repository, labelsAdded)
+			if err != nil {
+				handleErr(err)
+				return
+			}
+		}
+
+		switch {
+		case attributes.Action == "open" || attributes.Action == "reopen" || (attributes.Action == "update" &&
+			(attributes.OldRev != "" || deploy || payload.markedAsReady())):
+			// Create new build on the open, reopen and update (new commits, deploy label added
+			// or marked as ready) action. The payload doesn't contain the author username
+			// and source project, these are fetched from GitLab.
+			var mergeRequest *gitlab.MergeRequest
+			mergeRequest, err = w.gitlab.GitLab.GetMergeRequest(ctx, owner, repository, attributes.IID)
+			if err != nil {
+				break
+			}
+
+			err = w.gitlab.Builder.NewBuild(ctx, builder.BuildOptions{
+				Owner:      owner,
+				Repository: repository,
+				Number:     attributes.IID,
+				SHA:        attributes.LastCommit.ID,
+				User:       payload.User.Username,
+				Author:     mergeRequest.Author.Username,
+				Labels:     labels,
+				Fork:       mergeRequest.Fork(),
+				Draft:      draft,
+				FirstRun:   attributes.Action == "open",
+			})
+		case attributes.Action == "update" && (labelsRemoved || payload.markedAsDraft()):
+			// Delete build if removed labels or the draft state no longer allows deployment
+			err = w.gitlab.Builder.ReevaluateBuild(ctx, owner, repository, attributes.IID, labels, draft)
+		case attributes.Action == "close" || attributes.Action == "merge":
+			// Delete build on the close and merge action
+			err = w.gitlab.Builder.DeleteBuild(ctx, owner, repository, attributes.IID)
+		}
+		if err != nil {
+			handleErr(err)
+			return
+		}
+
+	case GitLabNotePayload:
+
+		w.logger.Info("received gitlab note payload")
+
+		if w.gitlab.Username != "" && payload.User.Username == w.gitlab.Username {
+			w.logger.Info("skipping note, created by us")
+			return
+		}
+
+		// Execute the commands in notes created on a merge request
+		if payload.ObjectAttributes.NoteableType == "MergeRequest" && payload.MergeRequest != nil {
+			owner, repository := splitProjectPath(payload.Project.PathWithNamespace)
+
+			req := &commandRequest{
+				provider:          scm.GitLabProvider,
+				owner:             owner,
+				repository:        repository,
+				pullRequestNumber: payload.MergeRequest.IID,
+				commentID:         payload.ObjectAttributes.ID,
+				user:              payload.User.Username,
+				userID:            payload.User.ID,
+			}
+
+			if err = w.handleCommands(ctx, req, payload.ObjectAttributes.Note); err != nil {
+				handleErr(err)
+				return
+			}
+		}
+	}
+
+	rw.WriteHeader(http.StatusAccepted) // nolint: gosec, gas
+}
+
+// fetchMergeRequest returns the merge request the note was created on, the note
+// payload doesn't contain the current head and labels
+func (w *Webhook) fetchMergeRequest(ctx context.Context, req *commandRequest) (*pullRequest, error) {
+	mergeRequest, err := w.gitlab.GitLab.GetMergeRequest(ctx, req.owner, req.repository, req.pullRequestNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pullRequest{
+		number: mergeRequest.IID,
+		sha:    mergeRequest.SHA,
+		author: mergeRequest.Author.Username,
+		labels: mergeRequest.Labels,
+		fork:   mergeRequest.Fork(),
+		draft:  mergeRequest.Draft || mergeRequest.WorkInProgress,
+	}, nil
+}
+
+// labelChanges returns the labels added to the merge request and if labels were removed
+func (p *GitLabMergeRequestPayload) labelChanges() ([]string, bool) {
+	if p.Changes.Labels == nil {
+		return nil, false
+	}
+
+	previous := map[string]bool{}
+	for _, label := range p.Changes.Labels.Previous {
+		previous[label.Title] = true
+	}
+
+	var added []string
+	for _, label := range p.Changes.Labels.Current {
+		if !previous[label.Title] {
+			added = append(added, label.Title)
+		}
+		delete(previous, label.Title)
+	}
+
+	return added, len(previous) > 0
+}
+
+// markedAsReady returns true if the merge request was changed from draft to ready
+func (p *GitLabMergeRequestPayload) markedAsReady() bool {
+	return p.Changes.Draft != nil && p.Changes.Draft.Previous && !p.Changes.Draft.Current
+}
+
+// markedAsDraft returns true if the merge request was changed to a draft
+func (p *GitLabMergeRequestPayload) markedAsDraft() bool {
+	return p.Changes.Draft != nil && !p.Changes.Draft.Previous && p.Changes.Draft.Current
+}
+
+// gitlabPermission maps a GitLab access level to the command permissions
+func gitlabPermission(level gitlab.AccessLevel) github.Permission {
+	switch {
+	case level.Includes(gitlab.MaintainerAccess):
+		return github.AdminPermission
+	case level.Includes(gitlab.DeveloperAccess):
+		return github.WritePermission
+	case level.Includes(gitlab.ReporterAccess):
+		return github.ReadPermission
+	default:
+		return github.NonePermission
+	}
+}
+
+// splitProjectPath splits the project path into the namespace (owner) and the project
+// path, the namespace includes subgroups
+func splitProjectPath(pathWithNamespace string) (string, string) {
+	i := strings.LastIndex(pathWithNamespace, "/")
+	if i < 0 {
+		return "", pathWithNamespace
+	}
+
+	return pathWithNamespace[:i], pathWithNamespace[i+1:]
+}
@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newGitLabRequest(token string) *http.Request {
+	r := httptest.NewRequest(
+		http.MethodPost,
+		"/gitlab/webhook",
+		bytes.NewReader([]byte(`{"object_attributes": {"iid": 3, "action": "open"}}`)),
+	)
+	r.Header.Set("X-Gitlab-Event", string(GitLabMergeRequestEvent))
+	if token != "" {
+		r.Header.Set("X-Gitlab-Token", token)
+	}
+	return r
+}
+
+func TestParseGitLabToken(t *testing.T) {
+	w := &Webhook{gitlab: &GitLabOptions{Secrets: []string{"new", "old"}}}
+
+	payload, err := w.ParseGitLab(newGitLabRequest("old"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), payload.(GitLabMergeRequestPayload).ObjectAttributes.IID)
+
+	_, err = w.ParseGitLab(newGitLabRequest("wrong"))
+	assert.Equal(t, ErrGitLabTokenVerificationFailed, err)
+
+	_, err = w.ParseGitLab(newGitLabRequest(""))
+	assert.Equal(t, ErrMissingGitLabTokenHeader, err)
+}
+
+func TestGitLabLabelChanges(t *testing.T) {
+	payload := GitLabMergeRequestPayload{}
+	added, removed := payload.labelChanges()
+	assert.Empty(t, added)
+	assert.False(t, removed)
+
+	payload.Changes.Labels = &struct {
+		Previous []GitLabLabelPayload `json:"previous"`
+		Current  []GitLabLabelPayload `json:"current"`
+	}{
+		Previous: []GitLabLabelPayload{{Title: "deploy"}, {Title: "bug"}},
+		Current:  []GitLabLabelPayload{{Title: "deploy"}},
+	}
+	added, removed = payload.labelChanges()
+	assert.Empty(t, added)
+	assert.True(t, removed)
+
+	payload.Changes.Labels.Previous = []GitLabLabelPayload{{Title: "bug"}}
+	payload.Changes.Labels.Current = []GitLabLabelPayload{{Title: "deploy"}, {Title: "bug"}}
+	added, removed = payload.labelChanges()
+	assert.Equal(t, []string{"deploy"}, added)
+	assert.False(t, removed)
+}
+
+func TestSplitProjectPath(t *testing.T) {
+	owner, repository := splitProjectPath("group/subgroup/project")
+	assert.Equal(t, "group/subgroup", owner)
+	assert.Equal(t, "project", repository)
+}
@@ -4,12 +4,15 @@ package webhook
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io/ioutil"
 	"net/http"
+	"strings"
 )
 
 // Event represent supported webhook events
@@ -22,51 +25,92 @@ var (
 	PullRequestEvent Event = "pull_request"
 	// IssueCommentEvent stores the action reported by GitHub on a PR/Issue comment event
 	IssueCommentEvent Event = "issue_comment"
+	// CheckRunEvent stores the action reported by GitHub on a check run event
+	CheckRunEvent Event = "check_run"
+	// PushEvent stores the action reported by GitHub on a push event
+	PushEvent Event = "push"
+	// WorkflowRunEvent stores the action reported by GitHub on a workflow run event
+	WorkflowRunEvent Event = "workflow_run"
+	// RepositoryDispatchEvent stores the action reported by GitHub on a repository dispatch event
+	RepositoryDispatchEvent Event = "repository_dispatch"
+
+	// GitLabMergeRequestEvent stores the event reported by GitLab on a merge request event
+	GitLabMergeRequestEvent Event = "Merge Request Hook"
+	// GitLabNoteEvent stores the event reported by GitLab on a comment event
+	GitLabNoteEvent Event = "Note Hook"
 )
 
+// ImageDispatchEventType defines the repository dispatch event type sent by external CI
+// when the image of a commit is pushed
+const ImageDispatchEventType = "test-environment-image"
+
 var (
 	// ErrInvalidHTTPMethod Error
 	ErrInvalidHTTPMethod = errors.New("invalid HTTP Method")
 	// ErrMissingGithubEventHeader Error
 	ErrMissingGithubEventHeader = errors.New("missing X-GitHub-Event Header")
 	// ErrMissingHubSignatureHeader Error
-	ErrMissingHubSignatureHeader = errors.New("missing X-Hub-Signature Header")
+	ErrMissingHubSignatureHeader = errors.New("missing X-Hub-Signature-256 Header")
+	// ErrInvalidHubSignatureHeader Error
+	ErrInvalidHubSignatureHeader = errors.New("malformed X-Hub-Signature Header")
+	// ErrSHA1SignatureNotAllowed Error
+	ErrSHA1SignatureNotAllowed = errors.New("SHA-1 signatures not allowed, missing X-Hub-Signature-256 Header")
 	// ErrParsingPayload Error
 	ErrParsingPayload = errors.New("error parsing payload")
 	// ErrHMACVerificationFailed Error
 	ErrHMACVerificationFailed = errors.New("HMAC verification failed")
+	// ErrMissingWebhookSecret Error
+	ErrMissingWebhookSecret = errors.New("at least one webhook secret is required")
+	// ErrMissingGitLabEventHeader Error
+	ErrMissingGitLabEventHeader = errors.New("missing X-Gitlab-Event Header")
+	// ErrMissingGitLabTokenHeader Error
+	ErrMissingGitLabTokenHeader = errors.New("missing X-Gitlab-Token Header")
+	// ErrGitLabTokenVerificationFailed Error
+	ErrGitLabTokenVerificationFailed = errors.New("X-Gitlab-Token verification failed")
+	// ErrInvalidAPIRequest Error
+	ErrInvalidAPIRequest = errors.New("the owner, repository and number query parameters are required")
+	// ErrProviderNotEnabled Error
+	ErrProviderNotEnabled = errors.New("source control provider not enabled")
+	// ErrInvalidCommandArguments Error
+	ErrInvalidCommandArguments = errors.New("invalid command arguments")
 )
 
 // Parse parses GitHub webhooks
-// nolint: gocyclo
 func (w *Webhook) Parse(r *http.Request) (interface{}, error) {
+	_, _, payload, err := w.parse(r)
+	return payload, err
+}
+
+// parse verifies and parses a GitHub webhook, the event and raw payload is returned
+// together with the parsed payload
+func (w *Webhook) parse(r *http.Request) (Event, []byte, interface{}, error) {
 	if r.Method != http.MethodPost {
-		return nil, ErrInvalidHTTPMethod
+		return "", nil, nil, ErrInvalidHTTPMethod
 	}
 
 	event := r.Header.Get("X-GitHub-Event")
 	if event == "" {
-		return nil, ErrMissingGithubEventHeader
+		return "", nil, nil, ErrMissingGithubEventHeader
 	}
 	gitHubEvent := Event(event)
 
 	payload, err := ioutil.ReadAll(r.Body)
 	defer r.Body.Close() // nolint: errcheck
 	if err != nil || len(payload) == 0 {
-		return nil, ErrParsingPayload
+		return "", nil, nil, ErrParsingPayload
 	}
 
-	signature := r.Header.Get("X-Hub-Signature")
-	if len(signature) == 0 {
-		return nil, ErrMissingHubSignatureHeader
+	if err = w.verifySignature(r.Header, payload); err != nil {
+		return "", nil, nil, err
 	}
-	mac := hmac.New(sha1.New, []byte(w.secret))
-	_, _ = mac.Write(payload)
-	expectedMAC := hex.EncodeToString(mac.Sum(nil))
 
-	if !hmac.Equal([]byte(signature[5:]), []byte(expectedMAC)) {
-		return nil, ErrHMACVerificationFailed
-	}
+	parsed, err := parsePayload(gitHubEvent, payload)
+	return gitHubEvent, payload, parsed, err
+}
+
+// parsePayload parses a verified GitHub payload
+func parsePayload(gitHubEvent Event, payload []byte) (interface{}, error) {
+	var err error
 
 	switch gitHubEvent {
 	case PingEvent:
@@ -81,7 +125,122 @@ func (w *Webhook) Parse(r *http.Request) (interface{}, error) {
 		var pl IssueCommentPayload
 		err = json.Unmarshal(payload, &pl)
 		return pl, err
+	case CheckRunEvent:
+		var pl CheckRunPayload
+		err = json.Unmarshal(payload, &pl)
+		return pl, err
+	case PushEvent:
+		var pl PushPayload
+		err = json.Unmarshal(payload, &pl)
+		return pl, err
+	case WorkflowRunEvent:
+		var pl WorkflowRunPayload
+		err = json.Unmarshal(payload, &pl)
+		return pl, err
+	case RepositoryDispatchEvent:
+		var pl RepositoryDispatchPayload
+		err = json.Unmarshal(payload, &pl)
+		return pl, err
 	default:
 		return nil, fmt.Errorf("unknown event %s", gitHubEvent)
 	}
 }
+
+// ParseGitLab parses GitLab webhooks
+func (w *Webhook) ParseGitLab(r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, ErrInvalidHTTPMethod
+	}
+
+	event := r.Header.Get("X-Gitlab-Event")
+	if event == "" {
+		return nil, ErrMissingGitLabEventHeader
+	}
+	gitLabEvent := Event(event)
+
+	if err := checkToken(w.gitlab.Secrets, r.Header.Get("X-Gitlab-Token")); err != nil {
+		return nil, err
+	}
+
+	payload, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close() // nolint: errcheck
+	if err != nil || len(payload) == 0 {
+		return nil, ErrParsingPayload
+	}
+
+	switch gitLabEvent {
+	case GitLabMergeRequestEvent:
+		var pl GitLabMergeRequestPayload
+		err = json.Unmarshal(payload, &pl)
+		return pl, err
+	case GitLabNoteEvent:
+		var pl GitLabNotePayload
+		err = json.Unmarshal(payload, &pl)
+		return pl, err
+	default:
+		return nil, fmt.Errorf("unknown event %s", gitLabEvent)
+	}
+}
+
+// verifySignature validates the payload signature, X-Hub-Signature-256 is
+// preferred and the legacy SHA-1 signature is only used if allowed.
+func (w *Webhook) verifySignature(header http.Header, payload []byte) error {
+	if signature := header.Get("X-Hub-Signature-256"); signature != "" {
+		return checkSignature(w.secrets, payload, signature, "sha256=", sha256.New)
+	}
+
+	if signature := header.Get("X-Hub-Signature"); signature != "" {
+		if !w.allowSHA1 {
+			return ErrSHA1SignatureNotAllowed
+		}
+		return checkSignature(w.secrets, payload, signature, "sha1=", sha1.New)
+	}
+
+	return ErrMissingHubSignatureHeader
+}
+
+// checkSignature compares the signature against the HMAC of the payload
+// computed with each of the active secrets
+func checkSignature(
+	secrets []string,
+	payload []byte,
+	signature string,
+	prefix string,
+	h func() hash.Hash,
+) error {
+	if !strings.HasPrefix(signature, prefix) {
+		return ErrInvalidHubSignatureHeader
+	}
+
+	signatureMAC, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil || len(signatureMAC) != h().Size() {
+		return ErrInvalidHubSignatureHeader
+	}
+
+	for _, secret := range secrets {
+		mac := hmac.New(h, []byte(secret))
+		_, _ = mac.Write(payload)
+
+		if hmac.Equal(signatureMAC, mac.Sum(nil)) {
+			return nil
+		}
+	}
+
+	return ErrHMACVerificationFailed
+}
+
+// checkToken compares the GitLab token against each of the active secrets,
+// GitLab sends the secret as is
+func checkToken(secrets []string, token string) error {
+	if token == "" {
+		return ErrMissingGitLabTokenHeader
+	}
+
+	for _, secret := range secrets {
+		if hmac.Equal([]byte(token), []byte(secret)) {
+			return nil
+		}
+	}
+
+	return ErrGitLabTokenVerificationFailed
+}
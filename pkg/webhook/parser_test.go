@@ -0,0 +1,92 @@
+package webhook
+
+// nolint: gosec
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPayload = []byte(`{"hook_id": 1}`)
+
+func sign(h func() hash.Hash, secret string, payload []byte) string {
+	mac := hmac.New(h, []byte(secret))
+	_, _ = mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newPingRequest(headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(testPayload))
+	r.Header.Set("X-GitHub-Event", string(PingEvent))
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	return r
+}
+
+func TestParseSHA256Signature(t *testing.T) {
+	w := &Webhook{secrets: []string{"secret"}}
+
+	payload, err := w.Parse(newPingRequest(map[string]string{
+		"X-Hub-Signature-256": "sha256=" + sign(sha256.New, "secret", testPayload),
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, PingPayload{HookID: 1}, payload)
+
+	_, err = w.Parse(newPingRequest(map[string]string{
+		"X-Hub-Signature-256": "sha256=" + sign(sha256.New, "wrong", testPayload),
+	}))
+	assert.Equal(t, ErrHMACVerificationFailed, err)
+}
+
+func TestParseRotatedSecrets(t *testing.T) {
+	w := &Webhook{secrets: []string{"new", "old"}}
+
+	_, err := w.Parse(newPingRequest(map[string]string{
+		"X-Hub-Signature-256": "sha256=" + sign(sha256.New, "old", testPayload),
+	}))
+	assert.Nil(t, err)
+
+	_, err = w.Parse(newPingRequest(map[string]string{
+		"X-Hub-Signature-256": "sha256=" + sign(sha256.New, "new", testPayload),
+	}))
+	assert.Nil(t, err)
+}
+
+func TestParseSHA1Signature(t *testing.T) {
+	headers := map[string]string{
+		"X-Hub-Signature": "sha1=" + sign(sha1.New, "secret", testPayload),
+	}
+
+	w := &Webhook{secrets: []string{"secret"}}
+	_, err := w.Parse(newPingRequest(headers))
+	assert.Equal(t, ErrSHA1SignatureNotAllowed, err)
+
+	w = &Webhook{secrets: []string{"secret"}, allowSHA1: true}
+	_, err = w.Parse(newPingRequest(headers))
+	assert.Nil(t, err)
+}
+
+func TestParseMalformedSignature(t *testing.T) {
+	w := &Webhook{secrets: []string{"secret"}, allowSHA1: true}
+
+	for _, headers := range []map[string]string{
+		{},
+		{"X-Hub-Signature": "abc"},
+		{"X-Hub-Signature": "sha256=" + sign(sha256.New, "secret", testPayload)},
+		{"X-Hub-Signature-256": "sha256="},
+		{"X-Hub-Signature-256": "sha256=zz"},
+		{"X-Hub-Signature-256": "sha256=" + sign(sha1.New, "secret", testPayload)},
+	} {
+		_, err := w.Parse(newPingRequest(headers))
+		assert.Error(t, err)
+	}
+}
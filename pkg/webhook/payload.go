@@ -5,16 +5,33 @@ type PingPayload struct {
 	HookID int `json:"hook_id"`
 }
 
+// LabelPayload contains the information about a label
+type LabelPayload struct {
+	Name string `json:"name"`
+}
+
+// HeadRepositoryPayload contains the information about the repository a PR is opened from
+type HeadRepositoryPayload struct {
+	FullName string `json:"full_name"`
+}
+
 // PullRequestPayload contains the information for GitHub's pull_request hook event
 type PullRequestPayload struct {
-	Action      string `json:"action"`
-	Number      int64  `json:"number"`
+	Action      string       `json:"action"`
+	Number      int64        `json:"number"`
+	Label       LabelPayload `json:"label"` // Set on the labeled and unlabeled action
 	PullRequest struct {
+		Draft  bool           `json:"draft"`
+		Labels []LabelPayload `json:"labels"`
+		User   struct {
+			Login string `json:"login"`
+		} `json:"user"`
 		Head struct {
 			Sha  string `json:"sha"`
 			User struct {
 				Login string `json:"login"`
 			} `json:"user"`
+			Repo *HeadRepositoryPayload `json:"repo"` // nil if the fork is deleted
 		} `json:"head"`
 		Base struct {
 			Repo struct {
@@ -41,11 +58,95 @@ type IssueCommentIssuePullRequestPayload struct {
 type IssueCommentPayload struct {
 	Action string `json:"action"`
 	Issue  struct {
+		Number      int64                                `json:"number"`
 		PullRequest *IssueCommentIssuePullRequestPayload `json:"pull_request,omitempty"`
 	} `json:"issue"`
 	Comment struct {
+		ID   int64  `json:"id"`
 		Body string `json:"body"`
 	} `json:"comment"`
+	Repository struct {
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	}
+}
+
+// CheckRunPayload contains the information for GitHub's check_run hook event
+type CheckRunPayload struct {
+	Action   string `json:"action"`
+	CheckRun struct {
+		Name         string `json:"name"`
+		HeadSha      string `json:"head_sha"`
+		PullRequests []struct {
+			Number int64  `json:"number"`
+			URL    string `json:"url"`
+		} `json:"pull_requests"`
+	} `json:"check_run"`
+	Repository struct {
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	}
+}
+
+// PushPayload contains the information for GitHub's push hook event
+type PushPayload struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	}
+}
+
+// WorkflowRunPayload contains the information for GitHub's workflow_run hook event
+type WorkflowRunPayload struct {
+	Action      string `json:"action"`
+	WorkflowRun struct {
+		Name       string `json:"name"`
+		HeadSha    string `json:"head_sha"`
+		Conclusion string `json:"conclusion"`
+	} `json:"workflow_run"`
+	Repository struct {
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	}
+}
+
+// RepositoryDispatchPayload contains the information for GitHub's repository_dispatch hook event,
+// the client payload is sent by external CI when the image is pushed
+type RepositoryDispatchPayload struct {
+	Action        string `json:"action"`
+	ClientPayload struct {
+		Sha   string `json:"sha"`
+		Image string `json:"image"`
+	} `json:"client_payload"`
+	Repository struct {
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
 	Sender struct {
 		Login string `json:"login"`
 	}
@@ -53,12 +154,18 @@ type IssueCommentPayload struct {
 
 // PullRequestResponse defines the response from GET pull_request Github api
 type PullRequestResponse struct {
-	Number int64 `json:"number"`
-	Head   struct {
+	Number int64          `json:"number"`
+	Draft  bool           `json:"draft"`
+	Labels []LabelPayload `json:"labels"`
+	User   struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Head struct {
 		Sha  string `json:"sha"`
 		User struct {
 			Login string `json:"login"`
 		} `json:"user"`
+		Repo *HeadRepositoryPayload `json:"repo"` // nil if the fork is deleted
 	} `json:"head"`
 	Base struct {
 		Repo struct {
@@ -71,3 +178,85 @@ type PullRequestResponse struct {
 		} `json:"repo"`
 	} `json:"base"`
 }
+
+// GitLabLabelPayload contains the information about a GitLab label
+type GitLabLabelPayload struct {
+	Title string `json:"title"`
+}
+
+// GitLabProjectPayload contains the information about a GitLab project
+type GitLabProjectPayload struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+}
+
+// GitLabUserPayload contains the information about a GitLab user
+type GitLabUserPayload struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+// GitLabMergeRequestPayload contains the information for GitLab's Merge Request Hook event
+type GitLabMergeRequestPayload struct {
+	User             GitLabUserPayload    `json:"user"`
+	Project          GitLabProjectPayload `json:"project"`
+	ObjectAttributes struct {
+		IID            int64  `json:"iid"`
+		Action         string `json:"action"`
+		OldRev         string `json:"oldrev"` // Set on updates with new commits
+		Draft          bool   `json:"draft"`
+		WorkInProgress bool   `json:"work_in_progress"`
+		LastCommit     struct {
+			ID string `json:"id"`
+		} `json:"last_commit"`
+	} `json:"object_attributes"`
+	Labels  []GitLabLabelPayload `json:"labels"`
+	Changes struct {
+		Labels *struct {
+			Previous []GitLabLabelPayload `json:"previous"`
+			Current  []GitLabLabelPayload `json:"current"`
+		} `json:"labels"`
+		Draft *struct {
+			Previous bool `json:"previous"`
+			Current  bool `json:"current"`
+		} `json:"draft"`
+	} `json:"changes"`
+}
+
+// GitLabNotePayload contains the information for GitLab's Note Hook event
+type GitLabNotePayload struct {
+	User             GitLabUserPayload    `json:"user"`
+	Project          GitLabProjectPayload `json:"project"`
+	ObjectAttributes struct {
+		ID           int64  `json:"id"`
+		Note         string `json:"note"`
+		NoteableType string `json:"noteable_type"`
+	} `json:"object_attributes"`
+	MergeRequest *struct {
+		IID int64 `json:"iid"`
+	} `json:"merge_request,omitempty"`
+}
+
+// fromFork returns true if the PR head repository differs from the base repository
+func fromFork(head *HeadRepositoryPayload, baseFullName string) bool {
+	return head == nil || head.FullName != baseFullName
+}
+
+// labelNames returns the names of the labels
+func labelNames(labels []LabelPayload) []string {
+	names := make([]string, 0, len(labels))
+	for _, label := range labels {
+		names = append(names, label.Name)
+	}
+
+	return names
+}
+
+// gitLabLabelNames returns the titles of the GitLab labels
+func gitLabLabelNames(labels []GitLabLabelPayload) []string {
+	names := make([]string, 0, len(labels))
+	for _, label := range labels {
+		names = append(names, label.Title)
+	}
+
+	return names
+}
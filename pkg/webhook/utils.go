@@ -27,3 +27,17 @@ func errorHandler(rw http.ResponseWriter, err error, statusCode ...int) {
 	rw.WriteHeader(status) // nolint: gosec, gas, errcheck
 	rw.Write(body)         // nolint: gosec, gas, errcheck
 }
+
+// jsonHandler writes a value as a json response
+func jsonHandler(rw http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		errorHandler(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(status) // nolint: gosec, gas, errcheck
+	rw.Write(body)         // nolint: gosec, gas, errcheck
+}
@@ -1,22 +1,32 @@
 package webhook
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/kolonialno/pr-deployment-controller/pkg/auth"
 	"github.com/kolonialno/pr-deployment-controller/pkg/builder"
+	"github.com/kolonialno/pr-deployment-controller/pkg/delivery"
 	"github.com/kolonialno/pr-deployment-controller/pkg/github"
+	"github.com/kolonialno/pr-deployment-controller/pkg/scm"
 	"github.com/sirupsen/logrus"
 )
 
 // Webhook contains the required controllers to handle webhooks
 type Webhook struct {
-	logger   *logrus.Entry
-	b        builder.Builder
-	g        github.Github
-	secret   string
-	username string
+	logger    *logrus.Entry
+	b         builder.Builder
+	g         github.Github
+	secrets   []string
+	allowSHA1 bool
+	username  string
+	commands  map[string]*Command
+	gitlab    *GitLabOptions
+
+	deliveries    delivery.Store
+	authenticator *auth.Authenticator
 
 	r *mux.Router
 }
@@ -26,23 +36,58 @@ func New(
 	logger *logrus.Entry,
 	b builder.Builder,
 	g github.Github,
-	githubWebhookSecret string,
+	githubWebhookSecrets []string,
+	githubWebhookAllowSHA1 bool,
 	githubUsername string,
+	gitlabOptions *GitLabOptions,
+	deliveries delivery.Store,
+	authenticator *auth.Authenticator,
 ) (http.Handler, error) {
+	if len(githubWebhookSecrets) == 0 {
+		return nil, ErrMissingWebhookSecret
+	}
+	if gitlabOptions != nil && len(gitlabOptions.Secrets) == 0 {
+		return nil, ErrMissingWebhookSecret
+	}
+
 	r := mux.NewRouter()
 
 	w := &Webhook{
-		logger:   logger,
-		b:        b,
-		g:        g,
-		secret:   githubWebhookSecret,
-		username: githubUsername,
+		logger:    logger,
+		b:         b,
+		g:         g,
+		secrets:   githubWebhookSecrets,
+		allowSHA1: githubWebhookAllowSHA1,
+		username:  githubUsername,
+		commands:  map[string]*Command{},
+		gitlab:    gitlabOptions,
+
+		deliveries:    deliveries,
+		authenticator: authenticator,
 
 		r: r,
 	}
 
+	w.registerCommands()
+
 	r.HandleFunc("/health", w.healthHandler)
 	r.HandleFunc("/webhook", w.webhookHandler)
+	if gitlabOptions != nil {
+		r.HandleFunc("/gitlab/webhook", w.gitlabWebhookHandler)
+	}
+
+	// Authenticated delivery log, used to debug and replay webhooks
+	if deliveries != nil && authenticator != nil {
+		r.HandleFunc("/deliveries", authenticator.Handler(w.listDeliveriesHandler)).Methods(http.MethodGet)
+		r.HandleFunc(
+			"/deliveries/{id}/replay", authenticator.Handler(w.replayDeliveryHandler),
+		).Methods(http.MethodPost)
+	}
+
+	// Authenticated API used to manage builds
+	if authenticator != nil {
+		w.registerAPI()
+	}
 
 	return w, nil
 }
@@ -51,56 +96,118 @@ func (w *Webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	w.r.ServeHTTP(rw, r)
 }
 
-// nolint: gocyclo
 func (w *Webhook) webhookHandler(rw http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	payload, err := w.Parse(r)
+	event, body, payload, err := w.parse(r)
 	if err != nil {
 		w.logger.WithError(err).Warn("could not parese webhook payload")
 		errorHandler(rw, err)
 		return
 	}
 
-	// Generic error handler used if one of the steps returns an error
-	handleErr := func(err error) {
+	// Record the delivery, redeliveries within the duplicate window are ignored unless the delivery failed
+	deliveryID := r.Header.Get("X-GitHub-Delivery")
+	if w.deliveries != nil && deliveryID != "" {
+		duplicate, err := w.deliveries.Record(ctx, newDelivery(deliveryID, event, payload), body)
+		if err != nil {
+			w.logger.WithError(err).Warn("could not record delivery")
+		} else if duplicate {
+			w.logger.WithField("delivery", deliveryID).Info("skipping duplicate delivery")
+			rw.WriteHeader(http.StatusOK) // nolint: gosec, gas
+			return
+		}
+	}
+
+	err = w.handlePayload(ctx, payload)
+
+	if w.deliveries != nil && deliveryID != "" {
+		if completeErr := w.deliveries.Complete(ctx, deliveryID, false, err); completeErr != nil {
+			w.logger.WithError(completeErr).Warn("could not record delivery outcome")
+		}
+	}
+
+	if err != nil {
 		w.logger.WithError(err).Warn("could not handle webhook payload")
 		errorHandler(rw, err, http.StatusNotAcceptable)
+		return
 	}
 
+	rw.WriteHeader(http.StatusAccepted) // nolint: gosec, gas
+}
+
+// handlePayload schedules the builder jobs for a parsed GitHub payload
+// nolint: gocyclo
+func (w *Webhook) handlePayload(ctx context.Context, payload interface{}) error {
+	var err error
+
 	switch payload := payload.(type) {
 	case PullRequestPayload:
 
 		w.logger.Info("received pull request payload")
 
+		// Only the deploy label of the environment triggers a build, a build for other labels
+		// would supersede the running build of the commit
+		if payload.Action == "labeled" {
+			var deploy bool
+			deploy, err = deployLabelAdded(
+				ctx,
+				w.b,
+				payload.PullRequest.Base.Repo.Owner.Login,
+				payload.PullRequest.Base.Repo.Name,
+				[]string{payload.Label.Name},
+			)
+			if err != nil {
+				return err
+			} else if !deploy {
+				w.logger.WithField("label", payload.Label.Name).Info("skipping label, not the deploy label")
+				return nil
+			}
+		}
+
 		if payload.Action == "opened" ||
 			payload.Action == "synchronize" ||
-			payload.Action == "reopened" {
-			// Create new build on the opened, synchronize (new commit) and reopened action
-			if err = w.b.NewBuild(
+			payload.Action == "reopened" ||
+			payload.Action == "labeled" ||
+			payload.Action == "ready_for_review" {
+			// Create new build on the opened, synchronize (new commit), reopened, labeled
+			// and ready_for_review action
+			if err = w.b.NewBuild(ctx, builder.BuildOptions{
+				Owner:      payload.PullRequest.Base.Repo.Owner.Login,
+				Repository: payload.PullRequest.Base.Repo.Name,
+				Number:     payload.Number,
+				SHA:        payload.PullRequest.Head.Sha,
+				User:       payload.Sender.Login,
+				Author:     payload.PullRequest.User.Login,
+				Labels:     labelNames(payload.PullRequest.Labels),
+				Fork:       fromFork(payload.PullRequest.Head.Repo, payload.PullRequest.Base.Repo.FullName),
+				Draft:      payload.PullRequest.Draft,
+				FirstRun:   payload.Action == "opened",
+			}); err != nil {
+				return err
+			}
+		} else if payload.Action == "closed" {
+			// Delete build on the closed action (merged included)
+			if err = w.b.DeleteBuild(
 				ctx,
 				payload.PullRequest.Base.Repo.Owner.Login,
 				payload.PullRequest.Base.Repo.Name,
 				payload.Number,
-				payload.PullRequest.Head.Sha,
-				payload.Sender.Login,
-				payload.Action == "opened",
-				false,
-				false,
 			); err != nil {
-				handleErr(err)
-				return
+				return err
 			}
-		} else if payload.Action == "closed" {
-			// Delete build on the closed action (merged included)
-			if err = w.b.DeleteBuild(
+		} else if payload.Action == "unlabeled" || payload.Action == "converted_to_draft" {
+			// Delete build on the unlabeled and converted_to_draft action, the build
+			// is kept if the labels and draft state still allows deployment
+			if err = w.b.ReevaluateBuild(
 				ctx,
 				payload.PullRequest.Base.Repo.Owner.Login,
 				payload.PullRequest.Base.Repo.Name,
 				payload.Number,
+				labelNames(payload.PullRequest.Labels),
+				payload.PullRequest.Draft,
 			); err != nil {
-				handleErr(err)
-				return
+				return err
 			}
 		}
 
@@ -110,64 +217,130 @@ func (w *Webhook) webhookHandler(rw http.ResponseWriter, r *http.Request) {
 
 		if w.username != "" && payload.Sender.Login == w.username {
 			w.logger.Info("skipping comment, created by us")
-			return
+			return nil
 		}
 
-		// Initialize a new build if a user comments "/rebuild" on a PR
-		if payload.Action == "created" &&
-			payload.Issue.PullRequest != nil &&
-			strings.Contains(payload.Comment.Body, "/rebuild") {
-			// The issue comment payload doesn't contain the PR head, fetch this from GitHub
-			var pullRequest PullRequestResponse
-			res, err := w.g.Get(ctx, payload.Issue.PullRequest.URL, nil, &pullRequest)
-			if err != nil {
-				handleErr(err)
-				return
+		// Execute the commands in comments created on a PR
+		if payload.Action == "created" && payload.Issue.PullRequest != nil {
+			req := &commandRequest{
+				provider:          scm.GitHubProvider,
+				owner:             payload.Repository.Owner.Login,
+				repository:        payload.Repository.Name,
+				pullRequestNumber: payload.Issue.Number,
+				pullRequestURL:    payload.Issue.PullRequest.URL,
+				commentID:         payload.Comment.ID,
+				user:              payload.Sender.Login,
 			}
-			res.Body.Close() // nolint: errcheck
 
-			if err = w.b.NewBuild(
+			if err = w.handleCommands(ctx, req, payload.Comment.Body); err != nil {
+				return err
+			}
+		}
+
+	case CheckRunPayload:
+
+		w.logger.Info("received check run payload")
+
+		// Initialize a new build if a user re-runs the check run from the GitHub UI, the
+		// check run payload doesn't include PRs from forks or the PR author. The PR is fetched
+		// to apply the deployment and approval policies like other builds.
+		if payload.Action == "rerequested" && payload.CheckRun.Name == builder.CheckRunName {
+			for _, checkRunPullRequest := range payload.CheckRun.PullRequests {
+				req := &commandRequest{
+					provider:          scm.GitHubProvider,
+					owner:             payload.Repository.Owner.Login,
+					repository:        payload.Repository.Name,
+					pullRequestNumber: checkRunPullRequest.Number,
+					pullRequestURL:    checkRunPullRequest.URL,
+					user:              payload.Sender.Login,
+				}
+
+				pullRequest, err := w.fetchPullRequest(ctx, req)
+				if err != nil {
+					return err
+				}
+
+				// Check runs of outdated commits are not rebuilt
+				if pullRequest.sha != payload.CheckRun.HeadSha {
+					w.logger.WithField("sha", payload.CheckRun.HeadSha).Info("skipping re-run of outdated commit")
+					continue
+				}
+
+				if err = w.b.NewBuild(ctx, builder.BuildOptions{
+					Owner:      req.owner,
+					Repository: req.repository,
+					Number:     pullRequest.number,
+					SHA:        pullRequest.sha,
+					User:       req.user,
+					Author:     pullRequest.author,
+					Labels:     pullRequest.labels,
+					Fork:       pullRequest.fork,
+					Draft:      pullRequest.draft,
+				}); err != nil {
+					return err
+				}
+			}
+		}
+
+	case PushPayload:
+
+		w.logger.Info("received push payload")
+
+		// Only branch pushes are deployed, tags are ignored
+		if !strings.HasPrefix(payload.Ref, "refs/heads/") {
+			break
+		}
+		branch := strings.TrimPrefix(payload.Ref, "refs/heads/")
+
+		if payload.Deleted {
+			// Delete the branch build when the branch is deleted
+			err = w.b.DeleteBranchBuild(ctx, payload.Repository.Owner.Login, payload.Repository.Name, branch)
+		} else {
+			// Create new branch build, skipped if the branch isn't configured on the environment
+			err = w.b.NewBranchBuild(
 				ctx,
-				pullRequest.Base.Repo.Owner.Login,
-				pullRequest.Base.Repo.Name,
-				pullRequest.Number,
-				pullRequest.Head.Sha,
+				payload.Repository.Owner.Login,
+				payload.Repository.Name,
+				branch,
+				payload.After,
 				payload.Sender.Login,
-				false,
-				false,
-				true,
+			)
+		}
+		if err != nil {
+			return err
+		}
+
+	case WorkflowRunPayload:
+
+		w.logger.Info("received workflow run payload")
+
+		// A successful workflow may have pushed the image, waiting jobs checks the registry again
+		if payload.Action == "completed" && payload.WorkflowRun.Conclusion == "success" {
+			if err = w.b.ExternalImageReady(
+				ctx,
+				payload.Repository.Owner.Login,
+				payload.Repository.Name,
+				payload.WorkflowRun.HeadSha,
+				"",
 			); err != nil {
-				handleErr(err)
-				return
+				return err
 			}
 		}
 
-		// Initialize a new build with clean DB if a user comments "/clean" on a PR
-		if payload.Action == "created" &&
-			payload.Issue.PullRequest != nil &&
-			strings.Contains(payload.Comment.Body, "/clean") {
-			// The issue comment payload doesn't contain the PR head, fetch this from GitHub
-			var pullRequest PullRequestResponse
-			res, err := w.g.Get(ctx, payload.Issue.PullRequest.URL, nil, &pullRequest)
-			if err != nil {
-				handleErr(err)
-				return
-			}
-			res.Body.Close() // nolint: errcheck
+	case RepositoryDispatchPayload:
+
+		w.logger.Info("received repository dispatch payload")
 
-			if err = w.b.NewBuild(
+		// External CI reports the pushed image reference
+		if payload.Action == ImageDispatchEventType && payload.ClientPayload.Sha != "" {
+			if err = w.b.ExternalImageReady(
 				ctx,
-				pullRequest.Base.Repo.Owner.Login,
-				pullRequest.Base.Repo.Name,
-				pullRequest.Number,
-				pullRequest.Head.Sha,
-				payload.Sender.Login,
-				false,
-				true,
-				true,
+				payload.Repository.Owner.Login,
+				payload.Repository.Name,
+				payload.ClientPayload.Sha,
+				payload.ClientPayload.Image,
 			); err != nil {
-				handleErr(err)
-				return
+				return err
 			}
 		}
 
@@ -176,9 +349,25 @@ func (w *Webhook) webhookHandler(rw http.ResponseWriter, r *http.Request) {
 		w.logger.Info("received ping payload")
 	}
 
-	rw.WriteHeader(http.StatusAccepted) // nolint: gosec, gas
+	return nil
 }
 
 func (w *Webhook) healthHandler(rw http.ResponseWriter, r *http.Request) {
 	rw.WriteHeader(http.StatusOK) // nolint: gosec, gas
 }
+
+// deployLabelAdded returns true if one of the added labels is the deploy label of the environment
+func deployLabelAdded(ctx context.Context, b builder.Builder, owner, repository string, added []string) (bool, error) {
+	deployLabel, err := b.DeployLabel(ctx, owner, repository)
+	if err != nil || deployLabel == "" {
+		return false, err
+	}
+
+	for _, label := range added {
+		if label == deployLabel {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}